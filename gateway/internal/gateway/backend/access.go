@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 )
@@ -12,23 +16,163 @@ import (
 // KeyPaths maps from key ID to repository subpath
 type KeyPaths map[string]string
 
+// LeaseLimits contains the maximum and default lease durations in seconds.
+// A zero value means that the limit is not set
+type LeaseLimits struct {
+	MaxLeaseTime     int `json:"max_lease_time,omitempty"`
+	DefaultLeaseTime int `json:"default_lease_time,omitempty"`
+}
+
+// GCPolicy is the garbage collection schedule and retention of a repository.
+// The gateway runs garbage collection every Interval seconds, keeping the
+// last NumRevisions revisions and/or the revisions newer than MaxAge seconds
+type GCPolicy struct {
+	Interval     int `json:"interval"`
+	NumRevisions int `json:"num_revisions,omitempty"`
+	MaxAge       int `json:"max_age,omitempty"`
+}
+
+// Options returns the garbage collection options for a run of the policy
+// starting at the given time
+func (p *GCPolicy) Options(repository string, now time.Time) GCOptions {
+	options := GCOptions{Repository: repository, NumRevisions: p.NumRevisions}
+	if p.MaxAge > 0 {
+		options.Timestamp = now.Add(-time.Duration(p.MaxAge) * time.Second)
+	}
+	return options
+}
+
+func (p *GCPolicy) validate() error {
+	if p.Interval <= 0 {
+		return fmt.Errorf("missing or invalid gc interval")
+	}
+	if p.NumRevisions <= 0 && p.MaxAge <= 0 {
+		return fmt.Errorf("gc policy needs num_revisions or max_age")
+	}
+	return nil
+}
+
 // RepositoryConfig contains the access configuration (registered keys and
 // enabled status) for a repository
 type RepositoryConfig struct {
 	Keys    KeyPaths `json:"keys"`
 	Enabled bool     `json:"enabled"`
+	// DisabledReason and DisabledSince are set for disabled repositories
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	DisabledSince  *time.Time `json:"disabled_since,omitempty"`
+	// MaxLeaseLifetime is the maximum time in seconds a lease can be held
+	// through renewals, counted from its creation (0 means the maximum lease
+	// time)
+	MaxLeaseLifetime int `json:"max_lease_lifetime,omitempty"`
+	// Lease duration limits for the repository, they override max_lease_time
+	// from the gateway configuration
+	LeaseLimits
+	// KeyLeaseLimits further restricts the lease durations of individual keys
+	KeyLeaseLimits map[string]LeaseLimits `json:"key_lease_limits,omitempty"`
+	// NotificationPublishers is the set of key IDs allowed to publish
+	// manifests of the repository to the notification system
+	NotificationPublishers map[string]bool `json:"notification_publishers,omitempty"`
+	// GC is the scheduled garbage collection policy of the repository
+	GC *GCPolicy `json:"gc,omitempty"`
+	// LastGC is the outcome of the last scheduled garbage collection run
+	LastGC *GCOutcome `json:"last_gc,omitempty"`
+	// RejectSHA1 refuses the requests for the repository signed with HMAC-SHA1
+	RejectSHA1 bool `json:"reject_sha1,omitempty"`
+}
+
+// LeaseTime returns the maximum and the default lease duration for a key in
+// the repository. The repository limits replace the global maximum, while the
+// key limits can only shorten the repository ones
+func (rc *RepositoryConfig) LeaseTime(keyID string, globalMax time.Duration) (time.Duration, time.Duration) {
+	maxTime := globalMax
+	if rc.MaxLeaseTime > 0 {
+		maxTime = time.Duration(rc.MaxLeaseTime) * time.Second
+	}
+	defaultTime := time.Duration(rc.DefaultLeaseTime) * time.Second
+
+	if kl, present := rc.KeyLeaseLimits[keyID]; present {
+		if km := time.Duration(kl.MaxLeaseTime) * time.Second; km > 0 && km < maxTime {
+			maxTime = km
+		}
+		if kl.DefaultLeaseTime > 0 {
+			defaultTime = time.Duration(kl.DefaultLeaseTime) * time.Second
+		}
+	}
+
+	if defaultTime <= 0 || defaultTime > maxTime {
+		defaultTime = maxTime
+	}
+
+	return maxTime, defaultTime
+}
+
+// LeaseLifetime returns the maximum time a lease of a key can be held in the
+// repository, renewals included. It is the max_lease_lifetime of the
+// repository if there is one, otherwise the maximum lease duration. The
+// maximum lease duration of the key always applies
+func (rc *RepositoryConfig) LeaseLifetime(keyID string, globalMax time.Duration) time.Duration {
+	lifetime, _ := rc.LeaseTime(keyID, globalMax)
+	if rc.MaxLeaseLifetime > 0 {
+		lifetime = time.Duration(rc.MaxLeaseLifetime) * time.Second
+	}
+	if kl, present := rc.KeyLeaseLimits[keyID]; present {
+		if km := time.Duration(kl.MaxLeaseTime) * time.Second; km > 0 && km < lifetime {
+			lifetime = km
+		}
+	}
+	return lifetime
 }
 
 // KeyConfig contains the secret part and the enabled status of a key
 type KeyConfig struct {
-	Secret string `json:"secret"`
+	Secret string `json:"secret"` // empty for keys which only accept client certificates
 	Admin  bool   `json:"admin"`
+	// ClientCertSubject is the subject of the TLS client certificates which
+	// authenticate as this key, in RFC 2253 format (optional)
+	ClientCertSubject string `json:"client_cert_subject,omitempty"`
+	// RejectSHA1 refuses the requests of the key signed with HMAC-SHA1
+	RejectSHA1 bool `json:"reject_sha1,omitempty"`
+	// PreviousSecret is also accepted while a rotation of the key overlaps
+	// with its previous secret
+	PreviousSecret string `json:"-"`
+	// NotBefore and NotAfter delimit the validity window of the key. They are
+	// zero if the key has no such limit
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
 }
 
-// AccessConfig is the configuration of a single repository
+// CheckValidity returns an error if the key is outside of its validity
+// window at the given time
+func (k *KeyConfig) CheckValidity(now time.Time) error {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return fmt.Errorf("key_not_yet_valid")
+	}
+	if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+		return fmt.Errorf("key_expired")
+	}
+	return nil
+}
+
+// AccessConfig is the configuration of a single repository. It can be
+// replaced at runtime with Swap, so all the accessors are thread-safe.
+// The keys managed at runtime are layered over the keys and repositories
+// from the configuration file, and they are kept by Swap
 type AccessConfig struct {
 	Repositories map[string]RepositoryConfig
 	Keys         map[string]KeyConfig
+	managedKeys  map[string]ManagedKey
+	managedPaths map[string]KeyPaths // repository subpaths of the managed keys, by repository
+	lock         sync.RWMutex
+}
+
+// AccessConfigDiff lists the differences between two access configurations
+type AccessConfigDiff struct {
+	AddedRepos   []string `json:"added_repos"`
+	RemovedRepos []string `json:"removed_repos"`
+	ChangedRepos []string `json:"changed_repos"`
+	AddedKeys    []string `json:"added_keys"`
+	RemovedKeys  []string `json:"removed_keys"`
+	ChangedKeys  []string `json:"changed_keys"`
 }
 
 // RepositorySpecV1 lists the keys associated with a repository in the configuration file
@@ -44,7 +188,15 @@ type RepositorySpecV2 struct {
 		ID    string `json:"id"`
 		Admin bool   `json:"admin"`
 		Path  string `json:"path"`
+		// optional: allows publishing repository manifests to the
+		// notification system
+		CanPublishNotifications bool `json:"can_publish_notifications"`
+		LeaseLimits
 	} `json:"keys"`
+	MaxLeaseLifetime int       `json:"max_lease_lifetime"` // optional, in seconds
+	LeaseLimits                // optional, in seconds
+	GC               *GCPolicy `json:"gc"`          // optional
+	RejectSHA1       bool      `json:"reject_sha1"` // optional: only accept stronger HMACs
 }
 
 // KeySpec is a gateway key specification from the configuration file
@@ -55,6 +207,15 @@ type KeySpec struct {
 	FileName string `json:"file_name"`    // required for type "file"
 	Path     string `json:"repo_subpath"` // present if config is v1
 	Admin    bool   `json:"admin"`        // optional: designates an administration key
+	// optional: subject of the TLS client certificates authenticating as the
+	// key; a key of type "client_cert" has no secret and can only be used
+	// with a client certificate
+	ClientCertSubject string `json:"client_cert_subject"`
+	// optional: refuse the requests signed with HMAC-SHA1
+	RejectSHA1 bool `json:"reject_sha1"`
+	// optional: validity window of the key, as RFC 3339 timestamps
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
 }
 
 // KeyImportFun is the prototype of the function which imports keys based on
@@ -90,39 +251,246 @@ func NewAccessConfig(fileName string) (*AccessConfig, error) {
 // GetRepos returns a map where the keys are repository names and the
 // values are KeyPaths maps
 func (c *AccessConfig) GetRepos() map[string]RepositoryConfig {
-	return c.Repositories
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	repos := make(map[string]RepositoryConfig, len(c.Repositories))
+	for name, cfg := range c.Repositories {
+		repos[name] = c.withManagedPaths(name, cfg)
+	}
+	return repos
 }
 
 // GetRepo returns a map where the keys are key ID registered for the
 // repository and the values are repository subpath where the keys are
 // valid
 func (c *AccessConfig) GetRepo(repoName string) *RepositoryConfig {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	if cfg, present := c.Repositories[repoName]; present {
+		cfg = c.withManagedPaths(repoName, cfg)
 		return &cfg
 	}
 	return nil
 }
 
-// GetKeyConfig returns the key configuration corresponding to a key ID
+// withManagedPaths returns the configuration of a repository with the
+// subpaths of the managed keys added to the ones from the configuration file
+func (c *AccessConfig) withManagedPaths(repoName string, cfg RepositoryConfig) RepositoryConfig {
+	managed := c.managedPaths[repoName]
+	if len(managed) == 0 {
+		return cfg
+	}
+	keys := make(KeyPaths, len(cfg.Keys)+len(managed))
+	for id, path := range cfg.Keys {
+		keys[id] = path
+	}
+	for id, path := range managed {
+		keys[id] = path
+	}
+	cfg.Keys = keys
+	return cfg
+}
+
+// GetKeyConfig returns the key configuration corresponding to a key ID. The
+// managed keys take precedence over the keys from the configuration file, and
+// nil is returned for disabled keys
 func (c *AccessConfig) GetKeyConfig(keyID string) *KeyConfig {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.layeredKeyConfig(keyID, time.Now())
+}
+
+// layeredKeyConfig applies the managed key with the given ID over the key
+// from the configuration file. The lock must be held by the caller
+func (c *AccessConfig) layeredKeyConfig(keyID string, now time.Time) *KeyConfig {
+	cfg, inFile := c.Keys[keyID]
+	managed, present := c.managedKeys[keyID]
+	if !present {
+		if inFile {
+			return &cfg
+		}
+		return nil
+	}
+
+	if !managed.Enabled {
+		return nil
+	}
+	if !inFile {
+		cfg = KeyConfig{Admin: managed.Admin}
+	}
+	if managed.Secret != "" {
+		cfg.Secret = managed.Secret
+	}
+	if managed.ClientCertSubject != "" {
+		cfg.ClientCertSubject = managed.ClientCertSubject
+	}
+	if !managed.NotBefore.IsZero() {
+		cfg.NotBefore = managed.NotBefore
+	}
+	if !managed.NotAfter.IsZero() {
+		cfg.NotAfter = managed.NotAfter
+	}
+	if now.Before(managed.PreviousSecretExpiration) {
+		cfg.PreviousSecret = managed.PreviousSecret
+	}
+	return &cfg
+}
+
+// getFileKeyConfig returns the configuration of a key from the configuration
+// file, ignoring the managed keys
+func (c *AccessConfig) getFileKeyConfig(keyID string) *KeyConfig {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	if cfg, present := c.Keys[keyID]; present {
 		return &cfg
 	}
 	return nil
 }
 
+// getManagedKey returns the managed key with the given ID, or nil
+func (c *AccessConfig) getManagedKey(keyID string) *ManagedKey {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if key, present := c.managedKeys[keyID]; present {
+		return &key
+	}
+	return nil
+}
+
+// getKeyIDs returns the sorted IDs of the keys from the configuration file
+// and of the managed keys
+func (c *AccessConfig) getKeyIDs() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	ids := make([]string, 0, len(c.Keys)+len(c.managedKeys))
+	for id := range c.Keys {
+		ids = append(ids, id)
+	}
+	for id := range c.managedKeys {
+		if _, present := c.Keys[id]; !present {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetManagedKeys replaces the managed keys and their repository subpaths
+func (c *AccessConfig) SetManagedKeys(keys []ManagedKey, paths []ManagedKeyPath) {
+	managedKeys := make(map[string]ManagedKey, len(keys))
+	for _, k := range keys {
+		managedKeys[k.ID] = k
+	}
+	managedPaths := make(map[string]KeyPaths)
+	for _, kp := range paths {
+		if _, present := managedPaths[kp.Repository]; !present {
+			managedPaths[kp.Repository] = make(KeyPaths)
+		}
+		managedPaths[kp.Repository][kp.KeyID] = kp.Path
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.managedKeys = managedKeys
+	c.managedPaths = managedPaths
+}
+
+// GetKeyIDByCertSubject returns the ID of the key associated with a TLS client
+// certificate subject, or an empty string. The managed keys take precedence
+// over the keys from the configuration file, and disabled keys are ignored
+func (c *AccessConfig) GetKeyIDByCertSubject(subject string) string {
+	if subject == "" {
+		return ""
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	for id := range c.Keys {
+		if cfg := c.layeredKeyConfig(id, now); cfg != nil && cfg.ClientCertSubject == subject {
+			return id
+		}
+	}
+	for id := range c.managedKeys {
+		if cfg := c.layeredKeyConfig(id, now); cfg != nil && cfg.ClientCertSubject == subject {
+			return id
+		}
+	}
+	return ""
+}
+
+// Swap atomically replaces the repositories and keys with the ones from
+// another access configuration, and returns the differences between the two
+func (c *AccessConfig) Swap(other *AccessConfig) AccessConfigDiff {
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	diff := AccessConfigDiff{
+		AddedRepos:   make([]string, 0),
+		RemovedRepos: make([]string, 0),
+		ChangedRepos: make([]string, 0),
+		AddedKeys:    make([]string, 0),
+		RemovedKeys:  make([]string, 0),
+		ChangedKeys:  make([]string, 0),
+	}
+	for name, cfg := range other.Repositories {
+		if old, present := c.Repositories[name]; !present {
+			diff.AddedRepos = append(diff.AddedRepos, name)
+		} else if !reflect.DeepEqual(old, cfg) {
+			diff.ChangedRepos = append(diff.ChangedRepos, name)
+		}
+	}
+	for name := range c.Repositories {
+		if _, present := other.Repositories[name]; !present {
+			diff.RemovedRepos = append(diff.RemovedRepos, name)
+		}
+	}
+	for id, cfg := range other.Keys {
+		if old, present := c.Keys[id]; !present {
+			diff.AddedKeys = append(diff.AddedKeys, id)
+		} else if !reflect.DeepEqual(old, cfg) {
+			diff.ChangedKeys = append(diff.ChangedKeys, id)
+		}
+	}
+	for id := range c.Keys {
+		if _, present := other.Keys[id]; !present {
+			diff.RemovedKeys = append(diff.RemovedKeys, id)
+		}
+	}
+	for _, l := range [][]string{
+		diff.AddedRepos, diff.RemovedRepos, diff.ChangedRepos,
+		diff.AddedKeys, diff.RemovedKeys, diff.ChangedKeys} {
+		sort.Strings(l)
+	}
+
+	c.Repositories = other.Repositories
+	c.Keys = other.Keys
+
+	return diff
+}
+
 // Check verifies the given key and path are compatible with the access
 // configuration of the repository
 func (c *AccessConfig) Check(keyID, leasePath, repoName string) *AuthError {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	cfg, ok := c.Repositories[repoName]
 	if !ok {
 		return &AuthError{"invalid_repo"}
 	}
 
 	keyPath, ok := cfg.Keys[keyID]
+	if managedPath, present := c.managedPaths[repoName][keyID]; present {
+		keyPath, ok = managedPath, true
+	}
 	if !ok {
 		return &AuthError{"invalid_key"}
 	}
+	if managed, present := c.managedKeys[keyID]; present && !managed.Enabled {
+		return &AuthError{"invalid_key"}
+	}
 
 	overlapping := gw.CheckPathOverlap(leasePath, keyPath)
 	isSubpath := len(leasePath) >= len(keyPath)
@@ -134,6 +502,23 @@ func (c *AccessConfig) Check(keyID, leasePath, repoName string) *AuthError {
 	return nil
 }
 
+// CheckNotificationPublisher verifies that the given key is allowed to
+// publish manifests of the repository to the notification system
+func (c *AccessConfig) CheckNotificationPublisher(keyID, repoName string) *AuthError {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cfg, ok := c.Repositories[repoName]
+	if !ok {
+		return &AuthError{"invalid_repo"}
+	}
+
+	if !cfg.NotificationPublishers[keyID] {
+		return &AuthError{"no_publish_permission"}
+	}
+
+	return nil
+}
+
 func newAccessConfigWithImporter(fileName string, importer KeyImportFun) (*AccessConfig, error) {
 	ac := emptyAccessConfig()
 
@@ -147,11 +532,11 @@ func newAccessConfigWithImporter(fileName string, importer KeyImportFun) (*Acces
 		return nil, err
 	}
 
-	return &ac, nil
+	return ac, nil
 }
 
-func emptyAccessConfig() AccessConfig {
-	return AccessConfig{
+func emptyAccessConfig() *AccessConfig {
+	return &AccessConfig{
 		Repositories: make(map[string]RepositoryConfig),
 		Keys:         make(map[string]KeyConfig),
 	}
@@ -190,7 +575,16 @@ func (c *AccessConfig) loadV1(cfg rawConfig, importer KeyImportFun) error {
 				return fmt.Errorf("could not import key %v: %w", spec.ID, err)
 			}
 			keyPaths[keyID] = repoPath
-			c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
+			if err := c.addKey(keyID, KeyConfig{
+				Secret:            secret,
+				Admin:             admin,
+				ClientCertSubject: spec.ClientCertSubject,
+				RejectSHA1:        spec.RejectSHA1,
+				NotBefore:         spec.NotBefore,
+				NotAfter:          spec.NotAfter,
+			}); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -239,11 +633,30 @@ func (c *AccessConfig) loadV2(cfg rawConfig, importer KeyImportFun) error {
 				// Item is a RepositorySpecV2; associate the key IDs and paths to the
 				// repository
 				ks := make(KeyPaths)
+				kl := make(map[string]LeaseLimits)
+				np := make(map[string]bool)
 				for _, k := range spec.Keys {
 					ks[k.ID] = k.Path
+					if k.LeaseLimits != (LeaseLimits{}) {
+						kl[k.ID] = k.LeaseLimits
+					}
+					if k.CanPublishNotifications {
+						np[k.ID] = true
+					}
+				}
+				if spec.GC != nil {
+					if err := spec.GC.validate(); err != nil {
+						return fmt.Errorf("invalid gc policy for repository %v: %w", spec.Name, err)
+					}
 				}
 				c.Repositories[spec.Name] = RepositoryConfig{
-					Keys: ks,
+					Keys:                   ks,
+					MaxLeaseLifetime:       spec.MaxLeaseLifetime,
+					LeaseLimits:            spec.LeaseLimits,
+					KeyLeaseLimits:         kl,
+					NotificationPublishers: np,
+					GC:                     spec.GC,
+					RejectSHA1:             spec.RejectSHA1,
 				}
 			}
 		}
@@ -260,7 +673,16 @@ func (c *AccessConfig) loadV2(cfg rawConfig, importer KeyImportFun) error {
 			if err != nil {
 				return fmt.Errorf("could not import key %v: %w", spec.ID, err)
 			}
-			c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
+			if err := c.addKey(keyID, KeyConfig{
+				Secret:            secret,
+				Admin:             admin,
+				ClientCertSubject: spec.ClientCertSubject,
+				RejectSHA1:        spec.RejectSHA1,
+				NotBefore:         spec.NotBefore,
+				NotAfter:          spec.NotAfter,
+			}); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -284,10 +706,33 @@ func (c *AccessConfig) loadV2(cfg rawConfig, importer KeyImportFun) error {
 	return nil
 }
 
+// addKey stores a key configuration, checking that no other key is associated
+// with the same client certificate subject and that the validity window of
+// the key is not empty
+func (c *AccessConfig) addKey(keyID string, cfg KeyConfig) error {
+	if cfg.ClientCertSubject != "" {
+		for id, other := range c.Keys {
+			if id != keyID && other.ClientCertSubject == cfg.ClientCertSubject {
+				return fmt.Errorf(
+					"keys %v and %v have the same client certificate subject", id, keyID)
+			}
+		}
+	} else if cfg.Secret == "" {
+		return fmt.Errorf("key %v has neither a secret nor a client certificate subject", keyID)
+	}
+	if !cfg.NotBefore.IsZero() && !cfg.NotAfter.IsZero() && !cfg.NotAfter.After(cfg.NotBefore) {
+		return fmt.Errorf("key %v expires before it becomes valid", keyID)
+	}
+	c.Keys[keyID] = cfg
+	return nil
+}
+
 func keyImporter(ks KeySpec) (string, string, string, bool, error) {
 	switch ks.KeyType {
 	case "plain_text":
 		return ks.ID, ks.Secret, ks.Path, ks.Admin, nil
+	case "client_cert":
+		return ks.ID, "", ks.Path, ks.Admin, nil
 	case "file":
 		id, sec, err := gw.LoadKey(ks.FileName)
 		if err != nil {
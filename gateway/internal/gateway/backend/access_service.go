@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// RevocationReport lists the leases revoked by a change of the access
+// configuration
+type RevocationReport struct {
+	// RevokedLeases are the active leases whose key is no longer allowed to
+	// hold them under the new configuration
+	RevokedLeases []LeaseDTO `json:"revoked_leases"`
+	// Cancelled is true if the revoked leases were cancelled
+	Cancelled bool `json:"cancelled"`
+	// CommittingLeases are the revoked leases which were not cancelled,
+	// because their commit was in progress
+	CommittingLeases []LeaseDTO `json:"committing_leases,omitempty"`
+}
+
+// ReloadReport is the outcome of an access configuration reload
+type ReloadReport struct {
+	Diff AccessConfigDiff `json:"diff"`
+	RevocationReport
+}
+
+// ReloadAccessConfig reads the access configuration file again and replaces
+// the current access configuration with its content. The Repository table is
+// reconciled with the new configuration. Leases held by keys which are revoked
+// in the new configuration are reported, and also cancelled if the
+// revoked_lease_policy is "cancel", unless their commit is in progress
+func (s *Services) ReloadAccessConfig(ctx context.Context) (*ReloadReport, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "reload_access_config", &outcome, t0)
+
+	ac, err := NewAccessConfig(s.Config.AccessConfigFile)
+	if err != nil {
+		outcome = err.Error()
+		return nil, fmt.Errorf("loading repository access configuration failed: %w", err)
+	}
+
+	report, err := s.swapAccessConfig(ctx, ac)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	outcome = fmt.Sprintf(
+		"success: %v repos added, %v removed, %v changed; %v keys added, %v removed, %v changed; %v revoked leases",
+		len(report.Diff.AddedRepos), len(report.Diff.RemovedRepos), len(report.Diff.ChangedRepos),
+		len(report.Diff.AddedKeys), len(report.Diff.RemovedKeys), len(report.Diff.ChangedKeys),
+		len(report.RevokedLeases))
+
+	return report, nil
+}
+
+// swapAccessConfig installs the new access configuration and then looks for
+// the leases of revoked keys. The repository table is reconciled with the new
+// configuration first, so the current configuration stays active if that fails
+func (s *Services) swapAccessConfig(ctx context.Context, ac *AccessConfig) (*ReloadReport, error) {
+	if err := s.reconcileRepositories(ctx, ac.GetRepos()); err != nil {
+		return nil, fmt.Errorf("could not reconcile repository table: %w", err)
+	}
+
+	diff := s.Access.Swap(ac)
+
+	revocation, err := s.revokeLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReloadReport{Diff: diff, RevocationReport: *revocation}, nil
+}
+
+// revokeLeases looks for the active leases whose key is no longer allowed to
+// hold them, after a change of the access configuration, and cancels them if
+// the revoked_lease_policy is "cancel", except the leases being committed.
+// The new leases are granted in write transactions which also check the key,
+// so a lease granted with the old configuration is always committed before
+// the revoked leases are looked up
+func (s *Services) revokeLeases(ctx context.Context) (*RevocationReport, error) {
+	report := &RevocationReport{
+		RevokedLeases: make([]LeaseDTO, 0),
+		Cancelled:     s.Config.RevokedLeasePolicy == "cancel",
+	}
+
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	leases, err := FindAllActiveLeases(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range leases {
+		if s.Access.GetKeyConfig(l.KeyID) != nil && s.Access.Check(l.KeyID, l.Path, l.Repository) == nil {
+			continue
+		}
+
+		dto := LeaseDTO{
+			KeyID:     l.KeyID,
+			LeasePath: l.CombinedLeasePath(),
+			Expires:   l.Expiration.String(),
+			Hostname:  l.Hostname,
+		}
+		report.RevokedLeases = append(report.RevokedLeases, dto)
+
+		// Like CancelLease, a lease whose commit is in progress is not
+		// cancelled. The commit deletes it once finished
+		cancel := report.Cancelled && !s.LeaseCommits.inProgress(l.Token)
+		if report.Cancelled && !cancel {
+			report.CommittingLeases = append(report.CommittingLeases, dto)
+		}
+
+		gw.LogC(ctx, "actions", gw.LogInfo).
+			Str("key_id", l.KeyID).
+			Str("lease_path", l.CombinedLeasePath()).
+			Bool("cancelled", cancel).
+			Msg("lease held by revoked key")
+
+		if cancel {
+			if err := DeleteLeaseByToken(ctx, tx, l.Token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	if report.Cancelled {
+		for _, l := range leases {
+			s.LeaseQueue.notify(l.Repository)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+)
+
+// accessConfigReloaded is the access configuration used to replace
+// accessConfigV2 in the reload tests: test1.repo.org is removed, test3.repo.org
+// is added, and keyid1 is revoked from test2.repo.org
+const accessConfigReloaded = `
+{
+	"version": 2,
+	"repos" : [
+		{
+			"domain": "test2.repo.org",
+			"keys": [
+				{
+					"id": "keyid2",
+					"path": "/restricted/to/subdir"
+				}
+			]
+		},
+		{
+			"domain": "test3.repo.org",
+			"keys": [
+				{
+					"id": "keyid2",
+					"path": "/"
+				}
+			]
+		}
+	],
+	"keys": [
+		{
+			"type": "plain_text",
+			"id": "keyid2",
+			"secret": "secret2"
+		}
+	]
+}
+`
+
+func TestAccessServiceReload(t *testing.T) {
+	lastProtocolVersion := 3
+	for _, policy := range []string{"report", "cancel"} {
+		t.Run(policy, func(t *testing.T) {
+			backend, tmp := StartTestBackend("access_service_test", 1*time.Second)
+			defer func() {
+				backend.Stop()
+				os.RemoveAll(tmp)
+			}()
+
+			configFile := path.Join(tmp, "repo.json")
+			if err := ioutil.WriteFile(configFile, []byte(accessConfigReloaded), 0644); err != nil {
+				t.Fatalf("could not write access configuration: %v", err)
+			}
+			backend.Config.AccessConfigFile = configFile
+			backend.Config.RevokedLeasePolicy = policy
+
+			ctx := context.TODO()
+			token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+			if err != nil {
+				t.Fatalf("could not obtain new lease: %v", err)
+			}
+			if err := backend.SetRepoEnabled(ctx, "test2.repo.org", false, "maintenance"); err != nil {
+				t.Fatalf("could not disable repository: %v", err)
+			}
+
+			report, err := backend.ReloadAccessConfig(ctx)
+			if err != nil {
+				t.Fatalf("could not reload access configuration: %v", err)
+			}
+
+			diff := report.Diff
+			if len(diff.AddedRepos) != 1 || diff.AddedRepos[0] != "test3.repo.org" {
+				t.Fatalf("invalid added repositories: %v", diff.AddedRepos)
+			}
+			if len(diff.RemovedRepos) != 1 || diff.RemovedRepos[0] != "test1.repo.org" {
+				t.Fatalf("invalid removed repositories: %v", diff.RemovedRepos)
+			}
+			if len(diff.ChangedRepos) != 1 || diff.ChangedRepos[0] != "test2.repo.org" {
+				t.Fatalf("invalid changed repositories: %v", diff.ChangedRepos)
+			}
+			if len(diff.RemovedKeys) != 2 {
+				t.Fatalf("invalid removed keys: %v", diff.RemovedKeys)
+			}
+
+			if len(report.RevokedLeases) != 1 || report.RevokedLeases[0].KeyID != "keyid1" {
+				t.Fatalf("invalid revoked leases: %v", report.RevokedLeases)
+			}
+			_, err = backend.GetLease(ctx, token)
+			if policy == "cancel" && err == nil {
+				t.Fatalf("lease of revoked key was not cancelled")
+			}
+			if policy == "report" && err != nil {
+				t.Fatalf("lease of revoked key should not have been cancelled: %v", err)
+			}
+
+			repos, err := backend.GetRepos(ctx)
+			if err != nil {
+				t.Fatalf("could not query repositories: %v", err)
+			}
+			if _, present := repos["test1.repo.org"]; present {
+				t.Fatalf("removed repository is still present")
+			}
+			if !repos["test3.repo.org"].Enabled {
+				t.Fatalf("added repository should be enabled")
+			}
+			if repos["test2.repo.org"].Enabled {
+				t.Fatalf("repository state was not preserved by the reload")
+			}
+		})
+	}
+}
+
+func TestAccessServiceReloadDuringCommit(t *testing.T) {
+	lastProtocolVersion := 3
+	receiver.MockCommitLatency = 200 * time.Millisecond
+	defer func() {
+		receiver.MockCommitLatency = 0
+	}()
+
+	backend, tmp := StartTestBackend("access_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	configFile := path.Join(tmp, "repo.json")
+	if err := ioutil.WriteFile(configFile, []byte(accessConfigReloaded), 0644); err != nil {
+		t.Fatalf("could not write access configuration: %v", err)
+	}
+	backend.Config.AccessConfigFile = configFile
+	backend.Config.RevokedLeasePolicy = "cancel"
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+
+	committed := make(chan error, 1)
+	go func() {
+		_, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{})
+		committed <- err
+	}()
+	for !backend.LeaseCommits.inProgress(token) {
+		time.Sleep(time.Millisecond)
+	}
+
+	report, err := backend.ReloadAccessConfig(ctx)
+	if err != nil {
+		t.Fatalf("could not reload access configuration: %v", err)
+	}
+	if len(report.RevokedLeases) != 1 || report.RevokedLeases[0].KeyID != "keyid1" {
+		t.Fatalf("invalid revoked leases: %v", report.RevokedLeases)
+	}
+	if len(report.CommittingLeases) != 1 || report.CommittingLeases[0].KeyID != "keyid1" {
+		t.Fatalf("lease being committed should be reported as not cancelled: %v", report.CommittingLeases)
+	}
+	if _, err := backend.GetLease(ctx, token); err != nil {
+		t.Fatalf("lease being committed was cancelled: %v", err)
+	}
+
+	if err := <-committed; err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+}
+
+func TestAccessServiceReloadFailure(t *testing.T) {
+	backend, tmp := StartTestBackend("access_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	configFile := path.Join(tmp, "repo.json")
+	if err := ioutil.WriteFile(configFile, []byte(accessConfigReloaded), 0644); err != nil {
+		t.Fatalf("could not write access configuration: %v", err)
+	}
+	backend.Config.AccessConfigFile = configFile
+
+	// The repository table can't be reconciled with a cancelled context
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if _, err := backend.ReloadAccessConfig(ctx); err == nil {
+		t.Fatalf("reload should fail when the repository table can't be reconciled")
+	}
+
+	if backend.Access.GetRepo("test1.repo.org") == nil {
+		t.Fatalf("current access configuration was replaced by a failed reload")
+	}
+	if backend.Access.GetRepo("test3.repo.org") != nil {
+		t.Fatalf("repository of a failed reload was added")
+	}
+}
@@ -1,8 +1,10 @@
 package backend
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEmptyAccessConfig(t *testing.T) {
@@ -80,6 +82,54 @@ func TestLoadAccessConfigVersion2NoKeys(t *testing.T) {
 	}
 }
 
+func TestLoadAccessConfigLeaseLimits(t *testing.T) {
+	ac := emptyAccessConfig()
+	rd := strings.NewReader(accessConfigV2LeaseLimits)
+	err := ac.load(rd, mockKeyImporter)
+	if err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	rc := ac.GetRepo("test.repo.org")
+	if rc.MaxLeaseTime != 600 || rc.DefaultLeaseTime != 300 {
+		t.Fatalf("invalid repository lease limits: %+v", rc.LeaseLimits)
+	}
+	t.Run("repository limits", func(t *testing.T) {
+		maxTime, defaultTime := rc.LeaseTime("keyid1", 2*time.Hour)
+		if maxTime != 10*time.Minute || defaultTime != 5*time.Minute {
+			t.Errorf("invalid lease times: %v, %v", maxTime, defaultTime)
+		}
+	})
+	t.Run("key limits", func(t *testing.T) {
+		maxTime, defaultTime := rc.LeaseTime("keyid2", 2*time.Hour)
+		if maxTime != 1*time.Minute || defaultTime != 1*time.Minute {
+			t.Errorf("invalid lease times: %v, %v", maxTime, defaultTime)
+		}
+	})
+	t.Run("no limits", func(t *testing.T) {
+		rc := RepositoryConfig{}
+		maxTime, defaultTime := rc.LeaseTime("keyid1", 2*time.Hour)
+		if maxTime != 2*time.Hour || defaultTime != 2*time.Hour {
+			t.Errorf("invalid lease times: %v, %v", maxTime, defaultTime)
+		}
+	})
+	t.Run("lifetime", func(t *testing.T) {
+		if lifetime := rc.LeaseLifetime("keyid1", 2*time.Hour); lifetime != 10*time.Minute {
+			t.Errorf("invalid lease lifetime: %v", lifetime)
+		}
+		if lifetime := rc.LeaseLifetime("keyid2", 2*time.Hour); lifetime != 1*time.Minute {
+			t.Errorf("invalid lease lifetime of key: %v", lifetime)
+		}
+		rc := *rc
+		rc.MaxLeaseLifetime = 3600
+		if lifetime := rc.LeaseLifetime("keyid1", 2*time.Hour); lifetime != 1*time.Hour {
+			t.Errorf("invalid lease lifetime with max_lease_lifetime: %v", lifetime)
+		}
+		if lifetime := rc.LeaseLifetime("keyid2", 2*time.Hour); lifetime != 1*time.Minute {
+			t.Errorf("invalid lease lifetime of key with max_lease_lifetime: %v", lifetime)
+		}
+	})
+}
+
 func TestKeyCheck(t *testing.T) {
 	ac := emptyAccessConfig()
 	rd := strings.NewReader(accessConfigV2)
@@ -108,3 +158,225 @@ func TestKeyCheck(t *testing.T) {
 		}
 	})
 }
+
+func TestNotificationPublisherCheck(t *testing.T) {
+	ac := emptyAccessConfig()
+	rd := strings.NewReader(accessConfigV2)
+	if err := ac.load(rd, mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	if err := ac.CheckNotificationPublisher("keyid1", "test2.repo.org"); err != nil {
+		t.Errorf("valid publisher key was rejected: %v", err)
+	}
+	if ac.CheckNotificationPublisher("keyid2", "test2.repo.org") == nil {
+		t.Errorf("key without publish permission was accepted")
+	}
+	if ac.CheckNotificationPublisher("keyid1", "test1.repo.org") == nil {
+		t.Errorf("publisher key was accepted for another repository")
+	}
+}
+
+func TestLoadAccessConfigGCPolicy(t *testing.T) {
+	const cfg = `
+{
+	"version": 2,
+	"repos" : [
+		{
+			"domain": "test.repo.org",
+			"keys": [{"id": "keyid1", "path": "/"}],
+			"gc": %v
+		}
+	],
+	"keys": [{"type": "plain_text", "id": "keyid1", "secret": "secret1"}]
+}
+`
+	t.Run("valid policy", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg, `{"interval": 3600, "num_revisions": 5, "max_age": 86400}`))
+		if err := ac.load(rd, mockKeyImporter); err != nil {
+			t.Fatalf("access config loading failed: %v", err)
+		}
+		p := ac.GetRepo("test.repo.org").GC
+		if p == nil || p.Interval != 3600 || p.NumRevisions != 5 || p.MaxAge != 86400 {
+			t.Fatalf("invalid gc policy: %+v", p)
+		}
+		now := time.Now()
+		options := p.Options("test.repo.org", now)
+		if options.Repository != "test.repo.org" || options.NumRevisions != 5 ||
+			!options.Timestamp.Equal(now.Add(-24*time.Hour)) {
+			t.Fatalf("invalid gc options: %+v", options)
+		}
+	})
+	t.Run("missing interval", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg, `{"num_revisions": 5}`))
+		if err := ac.load(rd, mockKeyImporter); err == nil {
+			t.Fatalf("gc policy without interval should be rejected")
+		}
+	})
+	t.Run("missing retention", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg, `{"interval": 3600}`))
+		if err := ac.load(rd, mockKeyImporter); err == nil {
+			t.Fatalf("gc policy without retention should be rejected")
+		}
+	})
+}
+
+func TestLoadAccessConfigClientCertSubject(t *testing.T) {
+	const cfg = `
+{
+	"version": 2,
+	"repos" : [
+		{
+			"domain": "test.repo.org",
+			"keys": [{"id": "keyid1", "path": "/"}, {"id": "keyid2", "path": "/"}]
+		}
+	],
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1", "client_cert_subject": "CN=publisher1,O=Test"},
+		%v
+	]
+}
+`
+	t.Run("certificate only key", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg,
+			`{"type": "client_cert", "id": "keyid2", "client_cert_subject": "CN=publisher2,O=Test"}`))
+		if err := ac.load(rd, mockKeyImporter); err != nil {
+			t.Fatalf("access config loading failed: %v", err)
+		}
+		if id := ac.GetKeyIDByCertSubject("CN=publisher1,O=Test"); id != "keyid1" {
+			t.Errorf("invalid key for certificate subject: %q", id)
+		}
+		if id := ac.GetKeyIDByCertSubject("CN=publisher2,O=Test"); id != "keyid2" {
+			t.Errorf("invalid key for certificate subject: %q", id)
+		}
+		if id := ac.GetKeyIDByCertSubject("CN=publisher3,O=Test"); id != "" {
+			t.Errorf("key found for unknown certificate subject: %q", id)
+		}
+		if secret := ac.GetKeyConfig("keyid2").Secret; secret != "" {
+			t.Errorf("certificate only key has a secret: %q", secret)
+		}
+	})
+	t.Run("duplicate subject", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg,
+			`{"type": "client_cert", "id": "keyid2", "client_cert_subject": "CN=publisher1,O=Test"}`))
+		if err := ac.load(rd, mockKeyImporter); err == nil {
+			t.Errorf("keys with the same certificate subject should be rejected")
+		}
+	})
+	t.Run("missing subject", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg, `{"type": "client_cert", "id": "keyid2"}`))
+		if err := ac.load(rd, mockKeyImporter); err == nil {
+			t.Errorf("key without secret and certificate subject should be rejected")
+		}
+	})
+	t.Run("managed keys", func(t *testing.T) {
+		ac := emptyAccessConfig()
+		rd := strings.NewReader(fmt.Sprintf(cfg,
+			`{"type": "client_cert", "id": "keyid2", "client_cert_subject": "CN=publisher2,O=Test"}`))
+		if err := ac.load(rd, mockKeyImporter); err != nil {
+			t.Fatalf("access config loading failed: %v", err)
+		}
+		ac.SetManagedKeys([]ManagedKey{
+			{ID: "keyid1", Enabled: false},
+			{ID: "keyid2", Enabled: true, ClientCertSubject: "CN=publisher2b,O=Test"},
+			{ID: "keyid3", Enabled: true, ClientCertSubject: "CN=publisher3,O=Test"},
+			{ID: "keyid4", Enabled: false, ClientCertSubject: "CN=publisher4,O=Test"},
+		}, nil)
+		for subject, keyID := range map[string]string{
+			"CN=publisher1,O=Test":  "",
+			"CN=publisher2,O=Test":  "",
+			"CN=publisher2b,O=Test": "keyid2",
+			"CN=publisher3,O=Test":  "keyid3",
+			"CN=publisher4,O=Test":  "",
+		} {
+			if id := ac.GetKeyIDByCertSubject(subject); id != keyID {
+				t.Errorf("invalid key for certificate subject %v: %q, expected %q", subject, id, keyID)
+			}
+		}
+	})
+}
+
+func TestLoadAccessConfigRejectSHA1(t *testing.T) {
+	const cfg = `
+{
+	"version": 2,
+	"repos" : [
+		{"domain": "strict.repo.org", "keys": [{"id": "keyid1", "path": "/"}], "reject_sha1": true},
+		{"domain": "test.repo.org", "keys": [{"id": "keyid1", "path": "/"}, {"id": "keyid2", "path": "/"}]}
+	],
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1"},
+		{"type": "plain_text", "id": "keyid2", "secret": "secret2", "reject_sha1": true}
+	]
+}
+`
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(cfg), mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	if !ac.GetRepo("strict.repo.org").RejectSHA1 || ac.GetRepo("test.repo.org").RejectSHA1 {
+		t.Errorf("invalid repository SHA-1 policy")
+	}
+	if ac.GetKeyConfig("keyid1").RejectSHA1 || !ac.GetKeyConfig("keyid2").RejectSHA1 {
+		t.Errorf("invalid key SHA-1 policy")
+	}
+}
+
+func TestLoadAccessConfigKeyValidity(t *testing.T) {
+	const cfg = `
+{
+	"version": 2,
+	"repos" : [
+		{"domain": "test.repo.org", "keys": [{"id": "keyid1", "path": "/"}, {"id": "keyid2", "path": "/"}]}
+	],
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1"},
+		{"type": "plain_text", "id": "keyid2", "secret": "secret2",
+		 "not_before": "2030-01-01T00:00:00Z", "not_after": "2030-02-01T00:00:00Z"}
+	]
+}
+`
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(cfg), mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+
+	notBefore := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		keyID    string
+		now      time.Time
+		expected string
+	}{
+		{"keyid1", notBefore.Add(-time.Hour), ""},
+		{"keyid1", notAfter, ""},
+		{"keyid2", notBefore.Add(-time.Second), "key_not_yet_valid"},
+		{"keyid2", notBefore, ""},
+		{"keyid2", notAfter.Add(-time.Second), ""},
+		{"keyid2", notAfter, "key_expired"},
+	}
+	for _, c := range cases {
+		err := ac.GetKeyConfig(c.keyID).CheckValidity(c.now)
+		if (c.expected == "" && err != nil) || (c.expected != "" && (err == nil || err.Error() != c.expected)) {
+			t.Errorf("invalid validity of %v at %v: %v", c.keyID, c.now, err)
+		}
+	}
+
+	const invalidCfg = `
+{
+	"version": 2,
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1",
+		 "not_before": "2030-02-01T00:00:00Z", "not_after": "2030-01-01T00:00:00Z"}
+	]
+}
+`
+	if err := emptyAccessConfig().load(strings.NewReader(invalidCfg), mockKeyImporter); err == nil {
+		t.Errorf("key expiring before it becomes valid was accepted")
+	}
+}
@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// AuditRecord is an entry of the audit log, describing an operation which
+// changed the state of a repository or of its leases
+type AuditRecord struct {
+	ID            int64
+	Time          time.Time
+	Action        string
+	Repository    string
+	LeasePath     string
+	KeyID         string
+	Hostname      string
+	Outcome       string
+	FinalRevision uint64
+	Tag           gw.RepositoryTag
+	Statistics    *stats.Statistics // nil if the operation has no publish statistics
+}
+
+// AuditFilter selects audit records. Empty fields match all the records
+type AuditFilter struct {
+	Repository string
+	KeyID      string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// CreateAuditRecord appends a record to the audit log
+func CreateAuditRecord(ctx context.Context, tx *sql.Tx, rec AuditRecord) error {
+	t0 := time.Now()
+
+	var statistics []byte
+	if rec.Statistics != nil {
+		var err error
+		statistics, err = json.Marshal(rec.Statistics)
+		if err != nil {
+			return fmt.Errorf("could not serialize statistics: %w", err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`insert into AuditLog
+		(Time, Action, Repository, LeasePath, KeyID, Hostname, Outcome, FinalRevision, TagName, TagDescription, Statistics)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		milliOrZero(rec.Time), rec.Action, rec.Repository, rec.LeasePath, rec.KeyID, rec.Hostname,
+		rec.Outcome, int64(rec.FinalRevision), rec.Tag.Name, rec.Tag.Description, string(statistics))
+	if err != nil {
+		return fmt.Errorf("could not insert audit record: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("audit record not inserted")
+	}
+
+	gw.LogC(ctx, "audit_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("action: %v, repo: %v", rec.Action, rec.Repository)
+
+	return nil
+}
+
+// FindAuditRecords returns the audit records matching the filter, most
+// recent first
+func FindAuditRecords(ctx context.Context, tx *sql.Tx, filter AuditFilter) ([]AuditRecord, error) {
+	t0 := time.Now()
+
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.Repository != "" {
+		conditions = append(conditions, "Repository = ?")
+		args = append(args, filter.Repository)
+	}
+	if filter.KeyID != "" {
+		conditions = append(conditions, "KeyID = ?")
+		args = append(args, filter.KeyID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "Time >= ?")
+		args = append(args, filter.From.UnixMilli())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "Time < ?")
+		args = append(args, filter.To.UnixMilli())
+	}
+
+	query := "select * from AuditLog"
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+	query += " order by ID desc"
+	if filter.Limit > 0 {
+		query += " limit ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := tx.QueryContext(ctx, query+";", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]AuditRecord, 0)
+	for rows.Next() {
+		var rec AuditRecord
+		if err := scanAuditRecord(rows, &rec); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		records = append(records, rec)
+	}
+
+	gw.LogC(ctx, "audit_entity", gw.LogDebug).
+		Str("operation", "find").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v audit records", len(records))
+
+	return records, nil
+}
+
+func scanAuditRecord(rows *sql.Rows, rec *AuditRecord) error {
+	var t, finalRev int64
+	var statistics string
+	if err := rows.Scan(
+		&rec.ID, &t, &rec.Action, &rec.Repository, &rec.LeasePath, &rec.KeyID, &rec.Hostname,
+		&rec.Outcome, &finalRev, &rec.Tag.Name, &rec.Tag.Description, &statistics); err != nil {
+		return err
+	}
+	rec.Time = timeOrZero(t)
+	rec.FinalRevision = uint64(finalRev)
+	if statistics != "" {
+		rec.Statistics = &stats.Statistics{}
+		if err := json.Unmarshal([]byte(statistics), rec.Statistics); err != nil {
+			return fmt.Errorf("could not deserialize statistics: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// MaxAuditRecords is the maximum number of audit records returned by a query
+const MaxAuditRecords = 1000
+
+// AuditRecordDTO is the audit record information returned to the HTTP frontend
+type AuditRecordDTO struct {
+	ID             int64             `json:"id"`
+	Time           string            `json:"time"`
+	Action         string            `json:"action"`
+	Repository     string            `json:"repository,omitempty"`
+	LeasePath      string            `json:"lease_path,omitempty"`
+	KeyID          string            `json:"key_id,omitempty"`
+	Hostname       string            `json:"hostname,omitempty"`
+	Outcome        string            `json:"outcome"`
+	FinalRevision  uint64            `json:"final_revision,omitempty"`
+	TagName        string            `json:"tag_name,omitempty"`
+	TagDescription string            `json:"tag_description,omitempty"`
+	Statistics     *stats.Statistics `json:"statistics,omitempty"`
+}
+
+// GetAuditRecords returns the audit records matching the filter, most recent
+// first. At most MaxAuditRecords are returned
+func (s *Services) GetAuditRecords(ctx context.Context, filter AuditFilter) ([]AuditRecordDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_audit_records", &outcome, t0)
+
+	if filter.Limit <= 0 || filter.Limit > MaxAuditRecords {
+		filter.Limit = MaxAuditRecords
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	records, err := FindAuditRecords(ctx, tx, filter)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := make([]AuditRecordDTO, 0, len(records))
+	for _, rec := range records {
+		ret = append(ret, AuditRecordDTO{
+			ID:             rec.ID,
+			Time:           rec.Time.Format(time.RFC3339Nano),
+			Action:         rec.Action,
+			Repository:     rec.Repository,
+			LeasePath:      rec.LeasePath,
+			KeyID:          rec.KeyID,
+			Hostname:       rec.Hostname,
+			Outcome:        rec.Outcome,
+			FinalRevision:  rec.FinalRevision,
+			TagName:        rec.Tag.Name,
+			TagDescription: rec.Tag.Description,
+			Statistics:     rec.Statistics,
+		})
+	}
+
+	return ret, nil
+}
+
+// audit appends the record of an operation to the audit log. The key ID is
+// taken from the request context when it is not set in the record, and the
+// repository from the lease path. A failure to write the record is logged,
+// and does not change the outcome of the operation
+func (s *Services) audit(ctx context.Context, rec AuditRecord, outcome string) {
+	rec.Time = time.Now()
+	rec.Outcome = outcome
+	if strings.HasPrefix(outcome, "success") {
+		// Drop the details, which may include the lease token
+		rec.Outcome = "success"
+	}
+	if rec.KeyID == "" {
+		rec.KeyID, _ = ctx.Value(gw.KeyIDKey).(string)
+	}
+	if rec.Repository == "" && rec.LeasePath != "" {
+		rec.Repository = strings.SplitN(rec.LeasePath, "/", 2)[0]
+	}
+
+	// The record is written even if the request has been cancelled
+	err := func() error {
+		tx, err := s.DB.BeginWriteTx(context.Background())
+		if err != nil {
+			return fmt.Errorf("could not begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if err := CreateAuditRecord(context.Background(), tx, rec); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}()
+
+	if err != nil {
+		gw.LogC(ctx, "actions", gw.LogError).
+			Err(err).
+			Str("action", rec.Action).
+			Msg("could not write audit record")
+	}
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestAuditLog(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("audit_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	t0 := time.Now()
+	ctx := context.WithValue(context.TODO(), gw.KeyIDKey, "keyid1")
+	adminCtx := context.WithValue(context.TODO(), gw.KeyIDKey, "admin0")
+
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	tag := gw.RepositoryTag{Name: "mytag", Description: "this is a tag"}
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", tag); err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+	token, err = backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host2", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	if err := backend.CancelLease(ctx, token); err != nil {
+		t.Fatalf("could not cancel lease: %v", err)
+	}
+	if err := backend.SetRepoEnabled(adminCtx, "test2.repo.org", false, "maintenance"); err != nil {
+		t.Fatalf("could not disable repository: %v", err)
+	}
+	if _, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion); err == nil {
+		t.Fatalf("new lease granted in disabled repository")
+	}
+	if err := backend.SetRepoEnabled(adminCtx, "test2.repo.org", true, ""); err != nil {
+		t.Fatalf("could not enable repository: %v", err)
+	}
+
+	t.Run("all records", func(t *testing.T) {
+		records, err := backend.GetAuditRecords(context.TODO(), AuditFilter{})
+		if err != nil {
+			t.Fatalf("could not get audit records: %v", err)
+		}
+		expected := []struct{ action, keyID, outcome string }{
+			{"enable_repo", "admin0", "success"},
+			{"new_lease", "keyid1", ErrRepoDisabled.Error()},
+			{"disable_repo", "admin0", "success"},
+			{"cancel_lease", "keyid1", "success"},
+			{"new_lease", "keyid1", "success"},
+			{"commit_lease", "keyid1", "success"},
+			{"new_lease", "keyid1", "success"},
+		}
+		if len(records) != len(expected) {
+			t.Fatalf("invalid audit records: %+v", records)
+		}
+		for i, e := range expected {
+			r := records[i]
+			if r.Action != e.action || r.KeyID != e.keyID || r.Outcome != e.outcome ||
+				r.Repository != "test2.repo.org" {
+				t.Errorf("invalid audit record %v: %+v", i, r)
+			}
+		}
+
+		commit := records[5]
+		if commit.LeasePath != "test2.repo.org/some/path" || commit.Hostname != "host1" ||
+			commit.FinalRevision != 1 || commit.TagName != "mytag" ||
+			commit.TagDescription != "this is a tag" || commit.Statistics == nil {
+			t.Errorf("invalid commit audit record: %+v", commit)
+		}
+	})
+	t.Run("filter by key", func(t *testing.T) {
+		records, err := backend.GetAuditRecords(context.TODO(), AuditFilter{KeyID: "admin0"})
+		if err != nil {
+			t.Fatalf("could not get audit records: %v", err)
+		}
+		if len(records) != 2 {
+			t.Errorf("invalid audit records: %+v", records)
+		}
+	})
+	t.Run("filter by repository and time", func(t *testing.T) {
+		records, err := backend.GetAuditRecords(
+			context.TODO(), AuditFilter{Repository: "test2.repo.org", From: t0, Limit: 3})
+		if err != nil {
+			t.Fatalf("could not get audit records: %v", err)
+		}
+		if len(records) != 3 {
+			t.Errorf("invalid audit records: %+v", records)
+		}
+		for _, f := range []AuditFilter{
+			{Repository: "test1.repo.org"},
+			{To: t0},
+			{From: time.Now().Add(time.Minute)},
+		} {
+			records, err := backend.GetAuditRecords(context.TODO(), f)
+			if err != nil {
+				t.Fatalf("could not get audit records: %v", err)
+			}
+			if len(records) != 0 {
+				t.Errorf("invalid audit records for filter %+v: %+v", f, records)
+			}
+		}
+	})
+	t.Run("append only", func(t *testing.T) {
+		if _, err := backend.DB.SQL.Exec("delete from AuditLog;"); err == nil {
+			t.Errorf("audit records could be deleted")
+		}
+		if _, err := backend.DB.SQL.Exec("update AuditLog set KeyID = 'other';"); err == nil {
+			t.Errorf("audit records could be modified")
+		}
+	})
+}
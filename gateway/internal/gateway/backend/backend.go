@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	"github.com/cvmfs/gateway/internal/gateway/receiver"
@@ -14,30 +15,58 @@ import (
 // backend services
 type Services struct {
 	Config        gw.Config
-	Access        AccessConfig
+	Access        *AccessConfig
 	DB            *DB
 	Pool          *receiver.Pool
 	Notifications *NotificationSystem
 	StatsMgr      *stats.StatisticsMgr
+	LeaseQueue    *LeaseQueue
+	LeaseCommits  *LeaseCommits
+	GCJobs        *GCJobRunner
+	Hooks         *HookRunner
+	Nonces        *NonceCache
+	KeyExpiry     *KeyExpiryMonitor
 }
 
 // ActionController contains the various actions that can be performed with the backend
 type ActionController interface {
 	GetKey(ctx context.Context, keyID string) *KeyConfig
+	GetKeyIDByCertSubject(ctx context.Context, subject string) string
+	CheckRequestNonce(ctx context.Context, keyID, nonce string, timestamp time.Time) error
 	GetRepo(ctx context.Context, repoName string) (*RepositoryConfig, error)
 	GetRepos(ctx context.Context) (map[string]RepositoryConfig, error)
-	SetRepoEnabled(ctx context.Context, repository string, enabled bool) error
+	ReloadAccessConfig(ctx context.Context) (*ReloadReport, error)
+	GetKeys(ctx context.Context) ([]KeyDTO, error)
+	GetExpiringKeys(ctx context.Context, within time.Duration) ([]KeyDTO, error)
+	CreateKey(ctx context.Context, keyID string, opts KeyOptions) (*KeySecretDTO, error)
+	SetKeyEnabled(ctx context.Context, keyID string, enable bool) (*RevocationReport, error)
+	RotateKey(ctx context.Context, keyID string, overlap time.Duration) (*KeySecretDTO, error)
+	DeleteKey(ctx context.Context, keyID string) (*RevocationReport, error)
+	AttachKey(ctx context.Context, keyID, repository, path string) error
+	DetachKey(ctx context.Context, keyID, repository string) (*RevocationReport, error)
+	SetRepoEnabled(ctx context.Context, repository string, enabled bool, reason string) error
 	NewLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int) (string, error)
+	WaitForLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int, maxWait time.Duration) (string, error)
+	GetLeaseQueue(ctx context.Context) ([]LeaseWaiterDTO, error)
 	GetLeases(ctx context.Context) (map[string]LeaseDTO, error)
 	GetLease(ctx context.Context, tokenStr string) (*LeaseDTO, error)
+	RenewLease(ctx context.Context, tokenStr string) (time.Time, error)
 	CancelLeases(ctx context.Context, repoPath string) error
 	CancelLease(ctx context.Context, tokenStr string) error
 	CommitLease(ctx context.Context, tokenStr, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error)
 	SubmitPayload(ctx context.Context, token string, payload io.Reader, digest string, headerSize int) error
-	RunGC(ctx context.Context, options GCOptions) (string, error)
-	PublishManifest(ctx context.Context, repository string, message NotificationMessage)
-	SubscribeToNotifications(ctx context.Context, repository string) SubscriberHandle
+	StartGC(ctx context.Context, options GCOptions) (string, error)
+	GetGCJobs(ctx context.Context) ([]GCJobDTO, error)
+	GetGCJob(ctx context.Context, id string) (*GCJobDTO, error)
+	CancelGCJob(ctx context.Context, id string) error
+	ReadGCJobLog(ctx context.Context, id string, offset int64, w io.Writer) (int64, bool, error)
+	PublishManifest(ctx context.Context, keyID, repository string, message NotificationMessage) error
+	SubscribeToNotifications(ctx context.Context, repository, lastEventID string) (SubscriberHandle, bool)
 	UnsubscribeFromNotifications(ctx context.Context, repository string, handle SubscriberHandle) error
+	WriteMetrics(ctx context.Context, w io.Writer) error
+	GetReceiverHealth(ctx context.Context) []receiver.WorkerHealth
+	GetAuditRecords(ctx context.Context, filter AuditFilter) ([]AuditRecordDTO, error)
+	GetCommitHistory(ctx context.Context, repository string, offset, limit int) (*CommitHistoryDTO, error)
 }
 
 // GetKey returns the key configuration associated with a key ID
@@ -45,6 +74,12 @@ func (s *Services) GetKey(ctx context.Context, keyID string) *KeyConfig {
 	return s.Access.GetKeyConfig(keyID)
 }
 
+// GetKeyIDByCertSubject returns the ID of the key associated with a TLS client
+// certificate subject, or an empty string if there is none
+func (s *Services) GetKeyIDByCertSubject(ctx context.Context, subject string) string {
+	return s.Access.GetKeyIDByCertSubject(subject)
+}
+
 // StartBackend initializes the various backend services
 func StartBackend(cfg gw.Config) (*Services, error) {
 	ac, err := NewAccessConfig(cfg.AccessConfigFile)
@@ -57,45 +92,80 @@ func StartBackend(cfg gw.Config) (*Services, error) {
 		return nil, fmt.Errorf("could not create lease DB: %w", err)
 	}
 
-	smgr := stats.NewStatisticsMgr()
+	smgr := stats.NewStatisticsMgr(leaseStatisticsStore{db: db})
 
-	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr)
+	pool, err := receiver.StartPool(
+		cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr,
+		receiver.PoolOptions{
+			MaxTasks:       cfg.ReceiverMaxTasks,
+			IdleTimeout:    cfg.ReceiverIdleTimeout,
+			PayloadTimeout: cfg.ReceiverPayloadTimeout,
+			CommitTimeout:  cfg.ReceiverCommitTimeout,
+		})
 	if err != nil {
 		return nil, fmt.Errorf("could not start receiver pool: %w", err)
 	}
 
-	ns, err := NewNotificationSystem(cfg.WorkDir)
+	ns, err := NewNotificationSystem(
+		cfg.WorkDir, cfg.NotificationQueueSize, SlowConsumerPolicy(cfg.NotificationSlowConsumerPolicy))
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize notification system: %w", err)
 	}
 
-	services := Services{Config: cfg, Access: *ac, DB: db, Pool: pool, Notifications: ns, StatsMgr: smgr}
+	gcJobs, err := NewGCJobRunner(cfg.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize gc job runner: %w", err)
+	}
+
+	services := Services{
+		Config:        cfg,
+		Access:        ac,
+		DB:            db,
+		Pool:          pool,
+		Notifications: ns,
+		StatsMgr:      smgr,
+		LeaseQueue:    NewLeaseQueue(),
+		LeaseCommits:  NewLeaseCommits(),
+		GCJobs:        gcJobs,
+		Hooks:         NewHookRunner(cfg.PreCommitHooks, cfg.PostCommitHooks, cfg.HookConcurrency, cfg.HookQueueSize),
+		Nonces:        NewNonceCache(cfg.RequestTimestampSkew),
+		KeyExpiry:     NewKeyExpiryMonitor(),
+	}
 
 	if err := PopulateRepositories(&services); err != nil {
 		return nil, fmt.Errorf("could not populate repository table: %w", err)
 	}
 
+	if err := services.LoadManagedKeys(context.Background()); err != nil {
+		return nil, fmt.Errorf("could not load managed keys: %w", err)
+	}
+
+	if err := services.interruptUnfinishedGCJobs(context.Background()); err != nil {
+		return nil, fmt.Errorf("could not update gc job table: %w", err)
+	}
+	if err := services.pruneGCJobs(context.Background(), time.Now()); err != nil {
+		return nil, fmt.Errorf("could not delete old gc jobs: %w", err)
+	}
+
+	services.StartGCScheduler(DefaultGCSchedulerInterval)
+	services.StartKeyExpiryMonitor(DefaultKeyExpiryCheckInterval)
+
 	return &services, nil
 }
 
 // Stop all the backend services
 func (s *Services) Stop() error {
+	s.GCJobs.stop()
+	s.KeyExpiry.stop()
+	s.Hooks.stop()
 	if err := s.DB.Close(); err != nil {
 		return fmt.Errorf("could not close database: %w", err)
 	}
 	return nil
 }
 
+// PopulateRepositories reconciles the Repository table with the access
+// configuration, preserving the state of the existing repositories
 func PopulateRepositories(s *Services) error {
-	ctx := context.Background()
-	if err := s.DeleteAllRepositories(ctx); err != nil {
-		return err
-	}
-	for name := range s.Access.Repositories {
-		if err := s.NewRepo(context.Background(), name, true); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return s.ReconcileRepositories(context.Background())
 }
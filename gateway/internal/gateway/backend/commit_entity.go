@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// CommitRecord is an entry of the commit history of a repository
+type CommitRecord struct {
+	ID          int64
+	Repository  string
+	Revision    uint64
+	OldRootHash string
+	NewRootHash string
+	Tag         gw.RepositoryTag
+	KeyID       string
+	Hostname    string
+	LeasePath   string
+	Time        time.Time
+	Duration    time.Duration
+	Statistics  *stats.Statistics // nil if no publish statistics were collected
+}
+
+// CreateCommitRecord appends a record to the commit history of a repository
+func CreateCommitRecord(ctx context.Context, tx *sql.Tx, rec CommitRecord) error {
+	t0 := time.Now()
+
+	var statistics []byte
+	if rec.Statistics != nil {
+		var err error
+		statistics, err = json.Marshal(rec.Statistics)
+		if err != nil {
+			return fmt.Errorf("could not serialize statistics: %w", err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`insert into CommitHistory
+		(Repository, Revision, OldRootHash, NewRootHash, TagName, TagDescription, KeyID, Hostname, LeasePath, Time, Duration, Statistics)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		rec.Repository, int64(rec.Revision), rec.OldRootHash, rec.NewRootHash, rec.Tag.Name,
+		rec.Tag.Description, rec.KeyID, rec.Hostname, rec.LeasePath, milliOrZero(rec.Time),
+		rec.Duration.Milliseconds(), string(statistics))
+	if err != nil {
+		return fmt.Errorf("could not insert commit record: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("commit record not inserted")
+	}
+
+	gw.LogC(ctx, "commit_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repo: %v, revision: %v", rec.Repository, rec.Revision)
+
+	return nil
+}
+
+// FindCommitRecordsByRepository returns a page of the commit history of a
+// repository, highest revision first, skipping the first offset records
+func FindCommitRecordsByRepository(ctx context.Context, tx *sql.Tx, repository string, offset, limit int) ([]CommitRecord, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx,
+		"select * from CommitHistory where Repository = ? order by Revision desc, ID desc limit ? offset ?;",
+		repository, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]CommitRecord, 0)
+	for rows.Next() {
+		var rec CommitRecord
+		if err := scanCommitRecord(rows, &rec); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		records = append(records, rec)
+	}
+
+	gw.LogC(ctx, "commit_entity", gw.LogDebug).
+		Str("operation", "find_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repo: %v, found %v commit records", repository, len(records))
+
+	return records, nil
+}
+
+// CountCommitRecordsByRepository returns the length of the commit history of
+// a repository
+func CountCommitRecordsByRepository(ctx context.Context, tx *sql.Tx, repository string) (int, error) {
+	t0 := time.Now()
+
+	var count int
+	if err := tx.QueryRowContext(ctx,
+		"select count(*) from CommitHistory where Repository = ?;", repository).Scan(&count); err != nil {
+		return 0, fmt.Errorf("query failed: %w", err)
+	}
+
+	gw.LogC(ctx, "commit_entity", gw.LogDebug).
+		Str("operation", "count_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repo: %v, count: %v", repository, count)
+
+	return count, nil
+}
+
+func scanCommitRecord(rows *sql.Rows, rec *CommitRecord) error {
+	var revision, t, duration int64
+	var statistics string
+	if err := rows.Scan(
+		&rec.ID, &rec.Repository, &revision, &rec.OldRootHash, &rec.NewRootHash,
+		&rec.Tag.Name, &rec.Tag.Description, &rec.KeyID, &rec.Hostname, &rec.LeasePath,
+		&t, &duration, &statistics); err != nil {
+		return err
+	}
+	rec.Revision = uint64(revision)
+	rec.Time = timeOrZero(t)
+	rec.Duration = time.Duration(duration) * time.Millisecond
+	if statistics != "" {
+		rec.Statistics = &stats.Statistics{}
+		if err := json.Unmarshal([]byte(statistics), rec.Statistics); err != nil {
+			return fmt.Errorf("could not deserialize statistics: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// Page sizes of the commit history
+const (
+	DefaultCommitHistoryLimit = 50
+	MaxCommitHistoryLimit     = 1000
+)
+
+// CommitRecordDTO is the commit information returned to the HTTP frontend
+type CommitRecordDTO struct {
+	Revision       uint64            `json:"revision"`
+	OldRootHash    string            `json:"old_root_hash"`
+	NewRootHash    string            `json:"new_root_hash"`
+	TagName        string            `json:"tag_name"`
+	TagDescription string            `json:"tag_description"`
+	KeyID          string            `json:"key_id"`
+	Hostname       string            `json:"hostname"`
+	LeasePath      string            `json:"lease_path"`
+	Time           string            `json:"time"`
+	Duration       float64           `json:"duration"` // seconds
+	Statistics     *stats.Statistics `json:"statistics,omitempty"`
+}
+
+// CommitHistoryDTO is a page of the commit history of a repository
+type CommitHistoryDTO struct {
+	Total   int               `json:"total"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+	Commits []CommitRecordDTO `json:"commits"`
+}
+
+// GetCommitHistory returns the commits of a repository, most recent first.
+// The first offset commits are skipped and at most limit commits are returned;
+// a limit of zero selects DefaultCommitHistoryLimit
+func (s *Services) GetCommitHistory(ctx context.Context, repository string, offset, limit int) (*CommitHistoryDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_commit_history", &outcome, t0)
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = DefaultCommitHistoryLimit
+	}
+	if limit > MaxCommitHistoryLimit {
+		limit = MaxCommitHistoryLimit
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	repo, err := FindRepositoryByName(ctx, tx, repository)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	if repo == nil {
+		outcome = "invalid_repo"
+		return nil, fmt.Errorf("invalid_repo")
+	}
+
+	total, err := CountCommitRecordsByRepository(ctx, tx, repository)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	records, err := FindCommitRecordsByRepository(ctx, tx, repository, offset, limit)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := &CommitHistoryDTO{
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Commits: make([]CommitRecordDTO, 0, len(records)),
+	}
+	for _, rec := range records {
+		ret.Commits = append(ret.Commits, CommitRecordDTO{
+			Revision:       rec.Revision,
+			OldRootHash:    rec.OldRootHash,
+			NewRootHash:    rec.NewRootHash,
+			TagName:        rec.Tag.Name,
+			TagDescription: rec.Tag.Description,
+			KeyID:          rec.KeyID,
+			Hostname:       rec.Hostname,
+			LeasePath:      rec.LeasePath,
+			Time:           rec.Time.Format(time.RFC3339Nano),
+			Duration:       rec.Duration.Seconds(),
+			Statistics:     rec.Statistics,
+		})
+	}
+
+	return ret, nil
+}
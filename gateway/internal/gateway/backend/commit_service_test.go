@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestCommitHistory(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("commit_history_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		tag := gw.RepositoryTag{Name: fmt.Sprintf("tag%v", i), Description: "a tag"}
+		oldHash := fmt.Sprintf("hash%v", i)
+		newHash := fmt.Sprintf("hash%v", i+1)
+		if _, err := backend.CommitLease(ctx, token, oldHash, newHash, tag); err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+	}
+
+	t.Run("first page", func(t *testing.T) {
+		history, err := backend.GetCommitHistory(ctx, "test2.repo.org", 0, 2)
+		if err != nil {
+			t.Fatalf("could not get commit history: %v", err)
+		}
+		if history.Total != 3 || len(history.Commits) != 2 {
+			t.Fatalf("invalid commit history: %+v", history)
+		}
+		latest := history.Commits[0]
+		if latest.OldRootHash != "hash2" || latest.NewRootHash != "hash3" ||
+			latest.TagName != "tag2" || latest.TagDescription != "a tag" ||
+			latest.KeyID != "keyid1" || latest.Hostname != "host1" ||
+			latest.LeasePath != "test2.repo.org/some/path" || latest.Revision != 1 ||
+			latest.Statistics == nil {
+			t.Errorf("invalid commit record: %+v", latest)
+		}
+		if history.Commits[1].TagName != "tag1" {
+			t.Errorf("invalid commit order: %+v", history.Commits)
+		}
+	})
+	t.Run("last page", func(t *testing.T) {
+		history, err := backend.GetCommitHistory(ctx, "test2.repo.org", 2, 2)
+		if err != nil {
+			t.Fatalf("could not get commit history: %v", err)
+		}
+		if history.Total != 3 || len(history.Commits) != 1 || history.Commits[0].TagName != "tag0" {
+			t.Errorf("invalid commit history: %+v", history)
+		}
+	})
+	t.Run("empty history", func(t *testing.T) {
+		history, err := backend.GetCommitHistory(ctx, "test1.repo.org", 0, 0)
+		if err != nil {
+			t.Fatalf("could not get commit history: %v", err)
+		}
+		if history.Total != 0 || len(history.Commits) != 0 || history.Limit != DefaultCommitHistoryLimit {
+			t.Errorf("invalid commit history: %+v", history)
+		}
+	})
+	t.Run("revision order", func(t *testing.T) {
+		// Records may be written out of revision order, since the commits
+		// are recorded after releasing the commit lock of the repository
+		for _, rev := range []uint64{5, 3, 7} {
+			withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+				return CreateCommitRecord(ctx, tx, CommitRecord{
+					Repository: "test1.repo.org", Revision: rev, Time: time.Now()})
+			})
+		}
+		history, err := backend.GetCommitHistory(ctx, "test1.repo.org", 0, 0)
+		if err != nil {
+			t.Fatalf("could not get commit history: %v", err)
+		}
+		revisions := make([]uint64, 0)
+		for _, c := range history.Commits {
+			revisions = append(revisions, c.Revision)
+		}
+		if fmt.Sprint(revisions) != "[7 5 3]" {
+			t.Errorf("commit history not ordered by revision: %v", revisions)
+		}
+	})
+	t.Run("record failure", func(t *testing.T) {
+		if _, err := backend.DB.SQL.Exec(`create trigger commit_history_fail before insert on CommitHistory
+begin
+	select raise(abort, 'failure');
+end;`); err != nil {
+			t.Fatalf("could not create trigger: %v", err)
+		}
+		defer backend.DB.SQL.Exec("drop trigger commit_history_fail;")
+
+		token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(ctx, token)
+		rev, err := backend.CommitLease(ctx, token, "hash3", "hash4", gw.RepositoryTag{})
+		if err == nil || rev == 0 {
+			t.Fatalf("commit should return the published revision and the error: %v, %v", rev, err)
+		}
+		// The lease is only released together with the record of the commit
+		if _, err := backend.GetLease(ctx, token); err != nil {
+			t.Fatalf("lease released without recording the commit: %v", err)
+		}
+	})
+	t.Run("invalid repository", func(t *testing.T) {
+		if _, err := backend.GetCommitHistory(ctx, "test9.repo.org", 0, 0); err == nil {
+			t.Errorf("commit history returned for unknown repository")
+		}
+	})
+}
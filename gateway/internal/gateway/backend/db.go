@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	_ "github.com/mattn/go-sqlite3"
@@ -13,12 +14,17 @@ import (
 const (
 	// latestSchemaVersion represents the most recent lease DB schema version
 	// known to the application
-	latestSchemaVersion = 3
+	latestSchemaVersion = 13
 )
 
+// dbBusyTimeout is the time in milliseconds for which a transaction waits for
+// the write lock of the database
+const dbBusyTimeout = 10000
+
 // DB stores active leases
 type DB struct {
 	SQL   *sql.DB
+	write *sql.DB    // Connections whose transactions take the write lock
 	Locks NamedLocks // Per-repository commit locks
 }
 
@@ -33,8 +39,17 @@ func OpenDB(config gw.Config) (*DB, error) {
 		createDB = true
 	}
 
-	sqlDB, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=rwc")
+	// In WAL mode the readers don't wait for the writer. The transactions
+	// begun on sqlDB only take the write lock when they first write, and
+	// those begun with BeginWriteTx take it when they begin
+	dsn := "file:" + dbFile + "?mode=rwc&_journal_mode=WAL&_busy_timeout=" + strconv.Itoa(dbBusyTimeout)
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open DB: %w", err)
+	}
+	writeDB, err := sql.Open("sqlite3", dsn+"&_txlock=immediate")
 	if err != nil {
+		sqlDB.Close()
 		return nil, fmt.Errorf("could not open DB: %w", err)
 	}
 
@@ -53,6 +68,7 @@ func OpenDB(config gw.Config) (*DB, error) {
 
 	return &DB{
 		SQL:   sqlDB,
+		write: writeDB,
 		Locks: NamedLocks{},
 	}, nil
 }
@@ -60,6 +76,7 @@ func OpenDB(config gw.Config) (*DB, error) {
 // Close the lease database
 func (db *DB) Close() error {
 	errs := make(map[string]error)
+	errs["write"] = db.write.Close()
 	err := db.SQL.Close()
 	for _, e := range errs {
 		if e != nil {
@@ -72,6 +89,14 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// BeginWriteTx begins a transaction which takes the write lock of the
+// database when it begins. Transactions which read and then write must be
+// begun with it: they wait for each other, instead of failing to upgrade
+// their lock. The read-only transactions are begun on SQL
+func (db *DB) BeginWriteTx(ctx context.Context) (*sql.Tx, error) {
+	return db.write.BeginTx(ctx, nil)
+}
+
 // WithLock runs the given task while holding a commit lock for the repository
 func (db *DB) WithLock(ctx context.Context, repository string, task func() error) error {
 	return db.Locks.WithLock(repository, task)
@@ -92,13 +117,92 @@ create table if not exists Lease (
 	KeyID string not null,
 	Expiration integer not null,
 	ProtocolVersion integer not null,
-	Hostname string
+	Hostname string,
+	Created integer not null default 0,
+	ChunksAdded integer not null default 0,
+	ChunksDuplicated integer not null default 0,
+	CatalogsAdded integer not null default 0,
+	UploadedBytes integer not null default 0,
+	UploadedCatalogBytes integer not null default 0,
+	StatisticsStartTime string not null default ''
 );
 create index lease_repository_path_idx ON Lease(Repository,Path);
 create table if not exists Repository (
 	Name string not null unique primary key,
 	Manifest string,
-	Enabled bool not null
+	Enabled bool not null,
+	DisabledReason string not null default '',
+	DisabledSince integer not null default 0,
+	LastGCRun integer not null default 0,
+	LastGCOutcome string not null default ''
+);
+create table if not exists GCJob (
+	ID string not null unique primary key,
+	Repository string not null,
+	Options string not null,
+	Status string not null,
+	Error string not null default '',
+	Created integer not null,
+	Started integer not null default 0,
+	Finished integer not null default 0
+);
+create table if not exists AuditLog (
+	ID integer primary key autoincrement,
+	Time integer not null,
+	Action string not null,
+	Repository string not null default '',
+	LeasePath string not null default '',
+	KeyID string not null default '',
+	Hostname string not null default '',
+	Outcome string not null,
+	FinalRevision integer not null default 0,
+	TagName string not null default '',
+	TagDescription string not null default '',
+	Statistics string not null default ''
+);
+create index audit_log_time_idx ON AuditLog(Time);
+create trigger audit_log_no_update before update on AuditLog
+begin
+	select raise(abort, 'the audit log is append-only');
+end;
+create trigger audit_log_no_delete before delete on AuditLog
+begin
+	select raise(abort, 'the audit log is append-only');
+end;
+create table if not exists CommitHistory (
+	ID integer primary key autoincrement,
+	Repository string not null,
+	Revision integer not null,
+	OldRootHash string not null,
+	NewRootHash string not null,
+	TagName string not null default '',
+	TagDescription string not null default '',
+	KeyID string not null default '',
+	Hostname string not null default '',
+	LeasePath string not null default '',
+	Time integer not null,
+	Duration integer not null,
+	Statistics string not null default ''
+);
+create index commit_history_repository_idx ON CommitHistory(Repository, ID);
+create table if not exists ManagedKey (
+	ID string not null unique primary key,
+	Secret string not null default '',
+	Admin bool not null default 0,
+	Enabled bool not null,
+	PreviousSecret string not null default '',
+	PreviousSecretExpiration integer not null default 0,
+	Created integer not null,
+	Updated integer not null,
+	ClientCertSubject string not null default '',
+	NotBefore integer not null default 0,
+	NotAfter integer not null default 0
+);
+create table if not exists ManagedKeyPath (
+	KeyID string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (KeyID, Repository)
 );
 `,
 		latestSchemaVersion)
@@ -133,5 +237,194 @@ update SchemaVersion set VersionNumber=3, ValidFrom=datetime('now');
 		version = 3
 	}
 
+	if version == 3 {
+		statement := `
+alter table Lease add column Created integer not null default 0;
+update SchemaVersion set VersionNumber=4, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 3, fmt.Errorf("could not migrate table schema (3->4): %w", err)
+		}
+
+		version = 4
+	}
+
+	if version == 4 {
+		statement := `
+alter table Repository add column DisabledReason string not null default '';
+alter table Repository add column DisabledSince integer not null default 0;
+update SchemaVersion set VersionNumber=5, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 4, fmt.Errorf("could not migrate table schema (4->5): %w", err)
+		}
+
+		version = 5
+	}
+
+	if version == 5 {
+		statement := `
+create table if not exists GCJob (
+	ID string not null unique primary key,
+	Repository string not null,
+	Options string not null,
+	Status string not null,
+	Error string not null default '',
+	Created integer not null,
+	Started integer not null default 0,
+	Finished integer not null default 0
+);
+update SchemaVersion set VersionNumber=6, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 5, fmt.Errorf("could not migrate table schema (5->6): %w", err)
+		}
+
+		version = 6
+	}
+
+	if version == 6 {
+		statement := `
+alter table Repository add column LastGCRun integer not null default 0;
+alter table Repository add column LastGCOutcome string not null default '';
+update SchemaVersion set VersionNumber=7, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 6, fmt.Errorf("could not migrate table schema (6->7): %w", err)
+		}
+
+		version = 7
+	}
+
+	if version == 7 {
+		statement := `
+create table if not exists AuditLog (
+	ID integer primary key autoincrement,
+	Time integer not null,
+	Action string not null,
+	Repository string not null default '',
+	LeasePath string not null default '',
+	KeyID string not null default '',
+	Hostname string not null default '',
+	Outcome string not null,
+	FinalRevision integer not null default 0,
+	TagName string not null default '',
+	TagDescription string not null default '',
+	Statistics string not null default ''
+);
+create index audit_log_time_idx ON AuditLog(Time);
+create trigger audit_log_no_update before update on AuditLog
+begin
+	select raise(abort, 'the audit log is append-only');
+end;
+create trigger audit_log_no_delete before delete on AuditLog
+begin
+	select raise(abort, 'the audit log is append-only');
+end;
+update SchemaVersion set VersionNumber=8, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 7, fmt.Errorf("could not migrate table schema (7->8): %w", err)
+		}
+
+		version = 8
+	}
+
+	if version == 8 {
+		statement := `
+create table if not exists CommitHistory (
+	ID integer primary key autoincrement,
+	Repository string not null,
+	Revision integer not null,
+	OldRootHash string not null,
+	NewRootHash string not null,
+	TagName string not null default '',
+	TagDescription string not null default '',
+	KeyID string not null default '',
+	Hostname string not null default '',
+	LeasePath string not null default '',
+	Time integer not null,
+	Duration integer not null,
+	Statistics string not null default ''
+);
+create index commit_history_repository_idx ON CommitHistory(Repository, ID);
+update SchemaVersion set VersionNumber=9, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 8, fmt.Errorf("could not migrate table schema (8->9): %w", err)
+		}
+
+		version = 9
+	}
+
+	if version == 9 {
+		statement := `
+alter table Lease add column ChunksAdded integer not null default 0;
+alter table Lease add column ChunksDuplicated integer not null default 0;
+alter table Lease add column CatalogsAdded integer not null default 0;
+alter table Lease add column UploadedBytes integer not null default 0;
+alter table Lease add column UploadedCatalogBytes integer not null default 0;
+alter table Lease add column StatisticsStartTime string not null default '';
+update SchemaVersion set VersionNumber=10, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 9, fmt.Errorf("could not migrate table schema (9->10): %w", err)
+		}
+
+		version = 10
+	}
+
+	if version == 10 {
+		statement := `
+create table if not exists ManagedKey (
+	ID string not null unique primary key,
+	Secret string not null default '',
+	Admin bool not null default 0,
+	Enabled bool not null,
+	PreviousSecret string not null default '',
+	PreviousSecretExpiration integer not null default 0,
+	Created integer not null,
+	Updated integer not null
+);
+create table if not exists ManagedKeyPath (
+	KeyID string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (KeyID, Repository)
+);
+update SchemaVersion set VersionNumber=11, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 10, fmt.Errorf("could not migrate table schema (10->11): %w", err)
+		}
+
+		version = 11
+	}
+
+	if version == 11 {
+		statement := `
+alter table ManagedKey add column ClientCertSubject string not null default '';
+update SchemaVersion set VersionNumber=12, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 11, fmt.Errorf("could not migrate table schema (11->12): %w", err)
+		}
+
+		version = 12
+	}
+
+	if version == 12 {
+		statement := `
+alter table ManagedKey add column NotBefore integer not null default 0;
+alter table ManagedKey add column NotAfter integer not null default 0;
+update SchemaVersion set VersionNumber=13, ValidFrom=datetime('now');
+`
+		if _, err := db.Exec(statement); err != nil {
+			return 12, fmt.Errorf("could not migrate table schema (12->13): %w", err)
+		}
+
+		version = 13
+	}
+
 	return version, nil
 }
@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// Status values of garbage collection jobs
+const (
+	GCJobQueued      = "queued"
+	GCJobRunning     = "running"
+	GCJobSucceeded   = "succeeded"
+	GCJobFailed      = "failed"
+	GCJobCancelled   = "cancelled"
+	GCJobInterrupted = "interrupted" // the gateway was stopped while the job was running
+	GCJobSkipped     = "skipped"     // a scheduled job did not run, see the job error for the reason
+)
+
+// GCJob is a garbage collection run of a repository, executed in the background
+type GCJob struct {
+	ID         string
+	Repository string
+	Options    GCOptions
+	Status     string
+	Error      string
+	Created    time.Time
+	Started    time.Time // zero if the job has not started
+	Finished   time.Time // zero if the job has not finished
+}
+
+// Done returns true if the job has finished running
+func (j GCJob) Done() bool {
+	return j.Status != GCJobQueued && j.Status != GCJobRunning
+}
+
+func CreateGCJob(ctx context.Context, tx *sql.Tx, job GCJob) error {
+	t0 := time.Now()
+
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return fmt.Errorf("could not serialize job options: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"insert into GCJob (ID, Repository, Options, Status, Error, Created, Started, Finished) values (?, ?, ?, ?, ?, ?, ?, ?);",
+		job.ID, job.Repository, string(options), job.Status, job.Error,
+		milliOrZero(job.Created), milliOrZero(job.Started), milliOrZero(job.Finished))
+	if err != nil {
+		return fmt.Errorf("could not insert new gc job: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("new gc job not inserted")
+	}
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, repo: %v", job.ID, job.Repository)
+
+	return nil
+}
+
+func UpdateGCJob(ctx context.Context, tx *sql.Tx, job GCJob) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"update GCJob set Status = ?, Error = ?, Started = ?, Finished = ? where ID = ?;",
+		job.Status, job.Error, milliOrZero(job.Started), milliOrZero(job.Finished), job.ID)
+	if err != nil {
+		return fmt.Errorf("could not update gc job: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates != 1 {
+		return fmt.Errorf("gc job not updated")
+	}
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "update").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, status: %v", job.ID, job.Status)
+
+	return nil
+}
+
+func FindAllGCJobs(ctx context.Context, tx *sql.Tx) ([]GCJob, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from GCJob order by Created;")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]GCJob, 0)
+	for rows.Next() {
+		var job GCJob
+		if err := scanGCJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "find_all").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v gc jobs", len(jobs))
+
+	return jobs, nil
+}
+
+func FindGCJobByID(ctx context.Context, tx *sql.Tx, id string) (*GCJob, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from GCJob where ID = ?;", id)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var job GCJob
+	if rows.Next() {
+		if err := scanGCJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "find_by_id").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("success")
+
+	return &job, nil
+}
+
+// InterruptUnfinishedGCJobs marks the jobs which were queued or running when
+// the gateway was stopped as interrupted
+func InterruptUnfinishedGCJobs(ctx context.Context, tx *sql.Tx) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"update GCJob set Status = ?, Finished = ? where Status in (?, ?);",
+		GCJobInterrupted, t0.UnixMilli(), GCJobQueued, GCJobRunning)
+	if err != nil {
+		return fmt.Errorf("update statement failed: %w", err)
+	}
+	numUpdated, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "interrupt_unfinished").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("interrupted %v gc jobs", numUpdated)
+
+	return nil
+}
+
+// DeleteGCJobsFinishedBefore deletes the jobs which finished before the
+// given time. Returns the IDs of the deleted jobs
+func DeleteGCJobsFinishedBefore(ctx context.Context, tx *sql.Tx, before time.Time) ([]string, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx,
+		"select ID from GCJob where Finished > 0 and Finished < ? and Status not in (?, ?);",
+		before.UnixMilli(), GCJobQueued, GCJobRunning)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, "delete from GCJob where ID = ?;", id); err != nil {
+			return nil, fmt.Errorf("delete statement failed: %w", err)
+		}
+	}
+
+	gw.LogC(ctx, "gc_job_entity", gw.LogDebug).
+		Str("operation", "delete_finished_before").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("deleted %v gc jobs", len(ids))
+
+	return ids, nil
+}
+
+func scanGCJob(rows *sql.Rows, job *GCJob) error {
+	var options string
+	var created, started, finished int64
+	if err := rows.Scan(
+		&job.ID,
+		&job.Repository,
+		&options,
+		&job.Status,
+		&job.Error,
+		&created,
+		&started,
+		&finished); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(options), &job.Options); err != nil {
+		return fmt.Errorf("could not deserialize job options: %w", err)
+	}
+	job.Created = timeOrZero(created)
+	job.Started = timeOrZero(started)
+	job.Finished = timeOrZero(finished)
+
+	return nil
+}
+
+// milliOrZero converts a time to milliseconds since the epoch, with the zero
+// time being stored as 0
+func milliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func timeOrZero(milli int64) time.Time {
+	if milli == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(milli)
+}
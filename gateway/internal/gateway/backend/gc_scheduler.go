@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// GCOutcome is the outcome of a scheduled garbage collection run of a
+// repository
+type GCOutcome struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	JobID  string    `json:"job_id"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// DefaultGCSchedulerInterval is the interval between the checks for
+// scheduled garbage collection runs which are due
+const DefaultGCSchedulerInterval = 1 * time.Minute
+
+// StartGCScheduler starts running the garbage collection policies of the
+// repositories in the background, checking every interval for runs which are due
+func (s *Services) StartGCScheduler(interval time.Duration) {
+	stop := make(chan struct{})
+	s.GCJobs.lock.Lock()
+	s.GCJobs.stopScheduler = stop
+	s.GCJobs.wg.Add(1)
+	s.GCJobs.lock.Unlock()
+
+	go func() {
+		defer s.GCJobs.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := s.RunScheduledGC(context.Background(), now); err != nil {
+					gw.Log("gc", gw.LogError).
+						Err(err).
+						Msg("could not run scheduled garbage collection")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// RunScheduledGC starts the garbage collection jobs of the enabled
+// repositories whose policy is due at the given time. The jobs are skipped
+// if the repository has active leases when the commit lock is acquired, and
+// are retried at the next check. A job which can't be started doesn't prevent
+// the jobs of the other repositories from starting
+func (s *Services) RunScheduledGC(ctx context.Context, now time.Time) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	repos, err := FindAllRepositories(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	configs := s.Access.GetRepos()
+	failed := make([]string, 0)
+	for _, repo := range repos {
+		policy := configs[repo.Name].GC
+		if policy == nil || !repo.Enabled {
+			continue
+		}
+		if now.Sub(repo.LastGCRun) < time.Duration(policy.Interval)*time.Second {
+			continue
+		}
+		if !s.GCJobs.markScheduled(repo.Name) {
+			// The previous run is still in progress
+			continue
+		}
+
+		name := repo.Name
+		id, err := s.startGCJob(ctx, policy.Options(name, now),
+			func() error {
+				return s.checkNoActiveLeases(ctx, name)
+			},
+			func(job GCJob) {
+				s.recordScheduledGC(job)
+				s.GCJobs.unmarkScheduled(name)
+			})
+		if err != nil {
+			// The other repositories are still checked
+			s.GCJobs.unmarkScheduled(name)
+			gw.Log("gc", gw.LogError).
+				Err(err).
+				Str("repository", name).
+				Msg("could not start scheduled garbage collection")
+			failed = append(failed, name)
+			continue
+		}
+
+		gw.Log("gc", gw.LogInfo).
+			Str("job_id", id).
+			Str("repository", name).
+			Msg("scheduled garbage collection started")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not start gc jobs for %v", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// checkNoActiveLeases returns an error if the repository has active leases
+func (s *Services) checkNoActiveLeases(ctx context.Context, repository string) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	count, err := CountActiveLeasesByRepository(ctx, tx, repository)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	if count > 0 {
+		return fmt.Errorf("active_leases")
+	}
+
+	return nil
+}
+
+// recordScheduledGC stores the outcome of a scheduled garbage collection job
+// in the repository record. Skipped runs do not count towards the schedule
+func (s *Services) recordScheduledGC(job GCJob) {
+	ctx := context.Background()
+	err := func() error {
+		tx, err := s.DB.BeginWriteTx(ctx)
+		if err != nil {
+			return fmt.Errorf("could not begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		repo, err := FindRepositoryByName(ctx, tx, job.Repository)
+		if err != nil || repo == nil {
+			return err
+		}
+
+		repo.LastGC = &GCOutcome{
+			Time:   job.Finished,
+			Status: job.Status,
+			JobID:  job.ID,
+			Error:  job.Error,
+		}
+		if job.Status != GCJobSkipped {
+			repo.LastGCRun = job.Created
+		}
+
+		if err := UpdateRepository(ctx, tx, *repo); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}()
+
+	if err != nil {
+		gw.Log("gc", gw.LogError).
+			Err(err).
+			Str("job_id", job.ID).
+			Str("repository", job.Repository).
+			Msg("could not record scheduled garbage collection outcome")
+	}
+}
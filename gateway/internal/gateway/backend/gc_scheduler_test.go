@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForScheduledGC waits until the scheduled garbage collection run of a
+// repository has finished and its outcome has been recorded
+func waitForScheduledGC(t *testing.T, backend *Services, repository string) *GCOutcome {
+	for i := 0; i < 100; i++ {
+		backend.GCJobs.lock.Lock()
+		scheduled := backend.GCJobs.scheduled[repository]
+		backend.GCJobs.lock.Unlock()
+		if !scheduled {
+			repo, err := backend.GetRepo(context.TODO(), repository)
+			if err != nil {
+				t.Fatalf("could not get repository: %v", err)
+			}
+			return repo.LastGC
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("scheduled gc did not finish in time")
+	return nil
+}
+
+func TestGCScheduler(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("gc_scheduler_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	backend.GCJobs.command = func(ctx context.Context, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "true")
+	}
+
+	backend.Access.lock.Lock()
+	rc := backend.Access.Repositories["test2.repo.org"]
+	rc.GC = &GCPolicy{Interval: 3600, NumRevisions: 5}
+	backend.Access.Repositories["test2.repo.org"] = rc
+	backend.Access.lock.Unlock()
+
+	numJobs := func() int {
+		jobs, err := backend.GetGCJobs(context.TODO())
+		if err != nil {
+			t.Fatalf("could not get gc jobs: %v", err)
+		}
+		return len(jobs)
+	}
+
+	now := time.Now()
+
+	t.Run("skipped with active leases", func(t *testing.T) {
+		token, err := backend.NewLease(
+			context.TODO(), "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		if err := backend.RunScheduledGC(context.TODO(), now); err != nil {
+			t.Fatalf("could not run scheduled gc: %v", err)
+		}
+		outcome := waitForScheduledGC(t, backend, "test2.repo.org")
+		if outcome == nil || outcome.Status != GCJobSkipped || outcome.Error != "active_leases" {
+			t.Fatalf("invalid gc outcome: %+v", outcome)
+		}
+		if err := backend.CancelLease(context.TODO(), token); err != nil {
+			t.Fatalf("could not cancel lease: %v", err)
+		}
+	})
+	t.Run("run when due", func(t *testing.T) {
+		if err := backend.RunScheduledGC(context.TODO(), now); err != nil {
+			t.Fatalf("could not run scheduled gc: %v", err)
+		}
+		outcome := waitForScheduledGC(t, backend, "test2.repo.org")
+		if outcome == nil || outcome.Status != GCJobSucceeded || outcome.JobID == "" {
+			t.Fatalf("invalid gc outcome: %+v", outcome)
+		}
+	})
+	t.Run("not run before interval", func(t *testing.T) {
+		before := numJobs()
+		if err := backend.RunScheduledGC(context.TODO(), now.Add(30*time.Minute)); err != nil {
+			t.Fatalf("could not run scheduled gc: %v", err)
+		}
+		if after := numJobs(); after != before {
+			t.Fatalf("gc job started before the end of the interval")
+		}
+	})
+	t.Run("run after interval", func(t *testing.T) {
+		before := numJobs()
+		if err := backend.RunScheduledGC(context.TODO(), now.Add(2*time.Hour)); err != nil {
+			t.Fatalf("could not run scheduled gc: %v", err)
+		}
+		waitForScheduledGC(t, backend, "test2.repo.org")
+		if after := numJobs(); after != before+1 {
+			t.Fatalf("gc job not started after the end of the interval")
+		}
+	})
+	t.Run("failure of another repository", func(t *testing.T) {
+		backend.Access.lock.Lock()
+		rc := backend.Access.Repositories["test1.repo.org"]
+		rc.GC = &GCPolicy{Interval: 3600, NumRevisions: 5}
+		backend.Access.Repositories["test1.repo.org"] = rc
+		backend.Access.lock.Unlock()
+
+		// The job of test1.repo.org, checked first, can't be created
+		if _, err := backend.DB.SQL.Exec(`create trigger gc_job_fail before insert on GCJob
+when new.Repository = 'test1.repo.org'
+begin
+	select raise(abort, 'failure');
+end;`); err != nil {
+			t.Fatalf("could not create trigger: %v", err)
+		}
+		defer backend.DB.SQL.Exec("drop trigger gc_job_fail;")
+
+		before := numJobs()
+		err := backend.RunScheduledGC(context.TODO(), now.Add(4*time.Hour))
+		if err == nil || !strings.Contains(err.Error(), "test1.repo.org") {
+			t.Fatalf("failure to start a gc job should be reported: %v", err)
+		}
+		waitForScheduledGC(t, backend, "test2.repo.org")
+		if after := numJobs(); after != before+1 {
+			t.Fatalf("gc job of test2.repo.org not started after a failure")
+		}
+	})
+}
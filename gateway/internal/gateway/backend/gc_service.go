@@ -2,9 +2,17 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/google/uuid"
 )
 
 // GCOptions represents the different options supplied for a garbace collection run
@@ -16,41 +24,516 @@ type GCOptions struct {
 	Verbose      bool      `json:"verbose"`
 }
 
-// RunGC triggers garbage collection on the specified repository
-func (s *Services) RunGC(ctx context.Context, options GCOptions) (string, error) {
+// GCJobDTO is the information about a garbage collection job returned to the
+// HTTP frontend
+type GCJobDTO struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repo"`
+	Options    GCOptions `json:"options"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Created    string    `json:"created"`
+	Started    string    `json:"started,omitempty"`
+	Finished   string    `json:"finished,omitempty"`
+}
+
+// ErrInvalidGCJob is returned when the requested garbage collection job does
+// not exist
+var ErrInvalidGCJob = fmt.Errorf("invalid_job")
+
+// ErrGCJobDone is returned when cancelling a garbage collection job which
+// has already finished
+var ErrGCJobDone = fmt.Errorf("job_finished")
+
+// GCJobRunner keeps track of the garbage collection jobs running in the
+// background. The output of each job is written to a log file in LogDir
+type GCJobRunner struct {
+	LogDir  string
+	running map[string]context.CancelFunc
+	stopped bool // set when the jobs are cancelled due to the gateway stopping
+	// scheduled is the set of repositories with a scheduled run in progress
+	scheduled map[string]bool
+	// stopScheduler is closed to stop the scheduler, if it was started
+	stopScheduler chan struct{}
+	lock          sync.Mutex
+	wg            sync.WaitGroup
+	// command creates the garbage collection command, it can be replaced in tests
+	command func(ctx context.Context, args ...string) *exec.Cmd
+}
+
+// NewGCJobRunner is a constructor function for the GCJobRunner type
+func NewGCJobRunner(workDir string) (*GCJobRunner, error) {
+	logDir := filepath.Join(workDir, "gc")
+	if err := os.MkdirAll(logDir, 0777); err != nil {
+		return nil, fmt.Errorf("could not create gc log directory: %w", err)
+	}
+
+	return &GCJobRunner{
+		LogDir:    logDir,
+		running:   make(map[string]context.CancelFunc),
+		scheduled: make(map[string]bool),
+		command: func(ctx context.Context, args ...string) *exec.Cmd {
+			return exec.CommandContext(ctx, "cvmfs_server", args...)
+		},
+	}, nil
+}
+
+func (r *GCJobRunner) logFile(id string) string {
+	return filepath.Join(r.LogDir, id+".log")
+}
+
+func (r *GCJobRunner) start(id string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.running[id] = cancel
+	r.wg.Add(1)
+	return ctx
+}
+
+func (r *GCJobRunner) finish(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if cancel, present := r.running[id]; present {
+		cancel()
+		delete(r.running, id)
+	}
+	r.wg.Done()
+}
+
+func (r *GCJobRunner) cancel(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cancel, present := r.running[id]
+	if present {
+		cancel()
+	}
+	return present
+}
+
+// markScheduled records that a scheduled run of the repository is in
+// progress. Returns false if there is already one
+func (r *GCJobRunner) markScheduled(repository string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.scheduled[repository] {
+		return false
+	}
+	r.scheduled[repository] = true
+	return true
+}
+
+func (r *GCJobRunner) unmarkScheduled(repository string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.scheduled, repository)
+}
+
+func (r *GCJobRunner) stopping() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stopped
+}
+
+// stop cancels all the running jobs and waits for them to finish
+func (r *GCJobRunner) stop() {
+	r.lock.Lock()
+	r.stopped = true
+	if r.stopScheduler != nil {
+		close(r.stopScheduler)
+		r.stopScheduler = nil
+	}
+	for _, cancel := range r.running {
+		cancel()
+	}
+	r.lock.Unlock()
+	r.wg.Wait()
+}
+
+// StartGC creates a garbage collection job for the specified repository and
+// runs it in the background. Returns the ID of the new job
+func (s *Services) StartGC(ctx context.Context, options GCOptions) (string, error) {
 	t0 := time.Now()
 
 	outcome := "success"
-	defer logAction(ctx, "garbage_collection", &outcome, t0)
+	defer logAction(ctx, "start_garbage_collection", &outcome, t0)
+
+	if s.Access.GetRepo(options.Repository) == nil {
+		outcome = "invalid_repo"
+		return "", fmt.Errorf("invalid_repo")
+	}
+
+	id, err := s.startGCJob(ctx, options, nil, nil)
+	if err != nil {
+		outcome = err.Error()
+		return "", err
+	}
+
+	outcome = fmt.Sprintf("success: %v", id)
+
+	return id, nil
+}
+
+// pruneGCJobs deletes the jobs which finished more than gc_job_retention ago,
+// together with their log files
+func (s *Services) pruneGCJobs(ctx context.Context, now time.Time) error {
+	if s.Config.GCJobRetention == 0 {
+		return nil
+	}
+
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids, err := DeleteGCJobsFinishedBefore(ctx, tx, now.Add(-s.Config.GCJobRetention))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	for _, id := range ids {
+		if err := os.Remove(s.GCJobs.logFile(id)); err != nil && !os.IsNotExist(err) {
+			gw.LogC(ctx, "gc", gw.LogWarn).
+				Err(err).
+				Str("job_id", id).
+				Msg("could not delete job log")
+		}
+	}
+
+	return nil
+}
+
+// startGCJob records a new garbage collection job and starts it in the
+// background. The optional precondition is checked once the repository lock
+// is acquired, and the job is skipped if it returns an error. The optional
+// onDone function is called with the final state of the job
+func (s *Services) startGCJob(
+	ctx context.Context, options GCOptions,
+	precondition func() error, onDone func(GCJob)) (string, error) {
+	// The old jobs are deleted as new ones are created, so their number stays
+	// bounded
+	if err := s.pruneGCJobs(ctx, time.Now()); err != nil {
+		gw.LogC(ctx, "gc", gw.LogError).
+			Err(err).
+			Msg("could not delete old jobs")
+	}
+
+	job := GCJob{
+		ID:         uuid.New().String(),
+		Repository: options.Repository,
+		Options:    options,
+		Status:     GCJobQueued,
+		Created:    time.Now(),
+	}
+
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := CreateGCJob(ctx, tx, job); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	keyID, _ := ctx.Value(gw.KeyIDKey).(string)
+	jobCtx := s.GCJobs.start(job.ID)
+	go func() {
+		job := s.runGCJob(jobCtx, job, precondition)
+		s.auditGCJob(keyID, job)
+		if onDone != nil {
+			onDone(job)
+		}
+		s.GCJobs.finish(job.ID)
+	}()
+
+	return job.ID, nil
+}
+
+// runGCJob runs the garbage collection command for the job, while holding
+// the commit lock of the repository, and records the outcome in the DB
+func (s *Services) runGCJob(ctx context.Context, job GCJob, precondition func() error) GCJob {
+	var skipReason error
+	err := s.DB.WithLock(ctx, job.Repository, func() error {
+		// The job may have been cancelled while waiting for the lock
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if precondition != nil {
+			if skipReason = precondition(); skipReason != nil {
+				return skipReason
+			}
+		}
+
+		job.Status = GCJobRunning
+		job.Started = time.Now()
+		s.saveGCJob(job)
+
+		f, err := os.Create(s.GCJobs.logFile(job.ID))
+		if err != nil {
+			return fmt.Errorf("could not create log file: %w", err)
+		}
+		defer f.Close()
+
+		cmd := s.GCJobs.command(ctx, gcArgs(job.Options)...)
+		cmd.Stdout = f
+		cmd.Stderr = f
+		return cmd.Run()
+	})
+
+	job.Finished = time.Now()
+	switch {
+	case ctx.Err() != nil && s.GCJobs.stopping():
+		job.Status = GCJobInterrupted
+	case ctx.Err() != nil:
+		job.Status = GCJobCancelled
+	case skipReason != nil:
+		job.Status = GCJobSkipped
+		job.Error = skipReason.Error()
+	case err != nil:
+		job.Status = GCJobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = GCJobSucceeded
+	}
+	s.saveGCJob(job)
+
+	gw.Log("gc", gw.LogInfo).
+		Str("job_id", job.ID).
+		Str("repository", job.Repository).
+		Str("status", job.Status).
+		Dur("task_dt", job.Finished.Sub(job.Created)).
+		Msg("garbage collection job finished")
+
+	return job
+}
 
-	baseArgs := []string{"gc", "-f"}
+// auditGCJob records a finished garbage collection job in the audit log. The
+// key ID is empty for the scheduled jobs
+func (s *Services) auditGCJob(keyID string, job GCJob) {
+	outcome := "success"
+	if job.Status != GCJobSucceeded {
+		outcome = job.Status
+		if job.Error != "" {
+			outcome += ": " + job.Error
+		}
+	}
+	s.audit(context.Background(), AuditRecord{
+		Action: "garbage_collection", Repository: job.Repository, KeyID: keyID}, outcome)
+}
+
+func (s *Services) saveGCJob(job GCJob) {
+	if err := s.updateGCJob(context.Background(), job); err != nil {
+		gw.Log("gc", gw.LogError).
+			Err(err).
+			Str("job_id", job.ID).
+			Msg("could not update job record")
+	}
+}
+
+func (s *Services) updateGCJob(ctx context.Context, job GCJob) error {
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := UpdateGCJob(ctx, tx, job); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func gcArgs(options GCOptions) []string {
+	args := []string{"gc", "-f"}
 	if options.NumRevisions != 0 {
-		baseArgs = append(baseArgs, "-r", strconv.Itoa(options.NumRevisions))
+		args = append(args, "-r", strconv.Itoa(options.NumRevisions))
 	}
 	if !options.Timestamp.IsZero() {
-		baseArgs = append(baseArgs, "-t", options.Timestamp.String())
+		args = append(args, "-t", options.Timestamp.String())
 	}
 	if options.DryRun {
-		baseArgs = append(baseArgs, "-d")
+		args = append(args, "-d")
 	}
 	if options.Verbose {
-		baseArgs = append(baseArgs, "-l")
+		args = append(args, "-l")
 	}
+	return append(args, options.Repository)
+}
 
-	var output string
-	args := append(baseArgs, options.Repository)
-	if err := s.DB.WithLock(ctx, options.Repository, func() error {
-		cmd := exec.Command("cvmfs_server", args...)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			return err
-		}
-		output = string(out)
-		return nil
-	}); err != nil {
+// GetGCJobs returns all the garbage collection jobs, oldest first
+func (s *Services) GetGCJobs(ctx context.Context) ([]GCJobDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_gc_jobs", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := FindAllGCJobs(ctx, tx)
+	if err != nil {
 		outcome = err.Error()
-		return "", err
+		return nil, err
 	}
 
-	return output, nil
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := make([]GCJobDTO, 0, len(jobs))
+	for _, job := range jobs {
+		ret = append(ret, newGCJobDTO(job))
+	}
+
+	return ret, nil
+}
+
+// GetGCJob returns the garbage collection job with the given ID
+func (s *Services) GetGCJob(ctx context.Context, id string) (*GCJobDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_gc_job", &outcome, t0)
+
+	job, err := s.findGCJob(ctx, id)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	dto := newGCJobDTO(*job)
+	return &dto, nil
+}
+
+// CancelGCJob stops a queued or running garbage collection job
+func (s *Services) CancelGCJob(ctx context.Context, id string) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "cancel_gc_job", &outcome, t0)
+
+	job, err := s.findGCJob(ctx, id)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if job.Done() || !s.GCJobs.cancel(id) {
+		outcome = ErrGCJobDone.Error()
+		return ErrGCJobDone
+	}
+
+	return nil
+}
+
+// ReadGCJobLog copies the output of a garbage collection job to w, starting
+// at the given offset. Returns the offset of the end of the output copied, and
+// whether the job has finished, in which case there is no more output to read
+func (s *Services) ReadGCJobLog(ctx context.Context, id string, offset int64, w io.Writer) (int64, bool, error) {
+	job, err := s.findGCJob(ctx, id)
+	if err != nil {
+		return offset, false, err
+	}
+
+	// The job status is checked before reading, so that the output of a
+	// finished job is always read in full
+	done := job.Done()
+
+	f, err := os.Open(s.GCJobs.logFile(id))
+	if os.IsNotExist(err) {
+		// The job has not started yet, or failed before producing any output
+		return offset, done, nil
+	} else if err != nil {
+		return offset, done, fmt.Errorf("could not open log file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, done, fmt.Errorf("could not seek in log file: %w", err)
+	}
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return offset + n, done, fmt.Errorf("could not read log file: %w", err)
+	}
+
+	return offset + n, done, nil
+}
+
+func (s *Services) findGCJob(ctx context.Context, id string) (*GCJob, error) {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	job, err := FindGCJobByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	if job == nil {
+		return nil, ErrInvalidGCJob
+	}
+	return job, nil
+}
+
+// interruptUnfinishedGCJobs updates the status of the jobs left unfinished by
+// a previous run of the gateway
+func (s *Services) interruptUnfinishedGCJobs(ctx context.Context) error {
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := InterruptUnfinishedGCJobs(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func newGCJobDTO(job GCJob) GCJobDTO {
+	dto := GCJobDTO{
+		ID:         job.ID,
+		Repository: job.Repository,
+		Options:    job.Options,
+		Status:     job.Status,
+		Error:      job.Error,
+		Created:    job.Created.String(),
+	}
+	if !job.Started.IsZero() {
+		dto.Started = job.Started.String()
+	}
+	if !job.Finished.IsZero() {
+		dto.Finished = job.Finished.String()
+	}
+	return dto
 }
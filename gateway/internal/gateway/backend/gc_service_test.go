@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// waitForGCJob polls the status of a garbage collection job until it finishes
+func waitForGCJob(t *testing.T, backend *Services, id string) *GCJobDTO {
+	for i := 0; i < 100; i++ {
+		job, err := backend.GetGCJob(context.TODO(), id)
+		if err != nil {
+			t.Fatalf("could not get gc job: %v", err)
+		}
+		if job.Status != GCJobQueued && job.Status != GCJobRunning {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("gc job did not finish in time")
+	return nil
+}
+
+func TestGCServiceJobs(t *testing.T) {
+	backend, tmp := StartTestBackend("gc_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	gcArguments := make(chan []string, 1)
+
+	t.Run("successful job", func(t *testing.T) {
+		backend.GCJobs.command = func(ctx context.Context, args ...string) *exec.Cmd {
+			gcArguments <- args
+			return exec.CommandContext(ctx, "echo", "collecting garbage")
+		}
+		id, err := backend.StartGC(context.TODO(), GCOptions{Repository: "test2.repo.org", NumRevisions: 5})
+		if err != nil {
+			t.Fatalf("could not start gc job: %v", err)
+		}
+		job := waitForGCJob(t, backend, id)
+		if job.Status != GCJobSucceeded || job.Started == "" || job.Finished == "" {
+			t.Fatalf("invalid gc job state: %+v", job)
+		}
+		expectedArgs := []string{"gc", "-f", "-r", "5", "test2.repo.org"}
+		args := <-gcArguments
+		if len(args) != len(expectedArgs) {
+			t.Fatalf("invalid gc arguments: %v", args)
+		}
+		for i := range expectedArgs {
+			if args[i] != expectedArgs[i] {
+				t.Fatalf("invalid gc arguments: %v", args)
+			}
+		}
+
+		var out bytes.Buffer
+		offset, done, err := backend.ReadGCJobLog(context.TODO(), id, 0, &out)
+		if err != nil || !done {
+			t.Fatalf("could not read gc job log: %v (done: %v)", err, done)
+		}
+		if out.String() != "collecting garbage\n" || offset != int64(out.Len()) {
+			t.Fatalf("invalid gc job log: %q, offset: %v", out.String(), offset)
+		}
+
+		out.Reset()
+		if _, _, err := backend.ReadGCJobLog(context.TODO(), id, 11, &out); err != nil || out.String() != "garbage\n" {
+			t.Fatalf("invalid gc job log from offset: %q (%v)", out.String(), err)
+		}
+	})
+	t.Run("failed job", func(t *testing.T) {
+		backend.GCJobs.command = func(ctx context.Context, args ...string) *exec.Cmd {
+			return exec.CommandContext(ctx, "false")
+		}
+		id, err := backend.StartGC(context.TODO(), GCOptions{Repository: "test2.repo.org"})
+		if err != nil {
+			t.Fatalf("could not start gc job: %v", err)
+		}
+		if job := waitForGCJob(t, backend, id); job.Status != GCJobFailed || job.Error == "" {
+			t.Fatalf("invalid gc job state: %+v", job)
+		}
+	})
+	t.Run("cancelled job", func(t *testing.T) {
+		backend.GCJobs.command = func(ctx context.Context, args ...string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sleep", "10")
+		}
+		id, err := backend.StartGC(context.TODO(), GCOptions{Repository: "test2.repo.org"})
+		if err != nil {
+			t.Fatalf("could not start gc job: %v", err)
+		}
+		if err := backend.CancelGCJob(context.TODO(), id); err != nil {
+			t.Fatalf("could not cancel gc job: %v", err)
+		}
+		if job := waitForGCJob(t, backend, id); job.Status != GCJobCancelled {
+			t.Fatalf("invalid gc job state: %+v", job)
+		}
+		if err := backend.CancelGCJob(context.TODO(), id); err != ErrGCJobDone {
+			t.Fatalf("cancelling a finished job should fail: %v", err)
+		}
+	})
+	t.Run("invalid repository", func(t *testing.T) {
+		if _, err := backend.StartGC(context.TODO(), GCOptions{Repository: "invalid.repo.org"}); err == nil {
+			t.Fatalf("gc job started for invalid repository")
+		}
+	})
+	t.Run("invalid job", func(t *testing.T) {
+		if _, err := backend.GetGCJob(context.TODO(), "invalid"); err != ErrInvalidGCJob {
+			t.Fatalf("expected invalid job error, got: %v", err)
+		}
+	})
+	t.Run("job list", func(t *testing.T) {
+		jobs, err := backend.GetGCJobs(context.TODO())
+		if err != nil {
+			t.Fatalf("could not get gc jobs: %v", err)
+		}
+		if len(jobs) != 3 {
+			t.Fatalf("invalid number of gc jobs: %v", len(jobs))
+		}
+	})
+}
+
+func TestGCServiceInterruptedJobs(t *testing.T) {
+	backend, tmp := StartTestBackend("gc_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+		return CreateGCJob(ctx, tx, GCJob{
+			ID:         "unfinished",
+			Repository: "test2.repo.org",
+			Status:     GCJobRunning,
+			Created:    time.Now(),
+			Started:    time.Now(),
+		})
+	})
+
+	// Simulate a gateway restart
+	if err := backend.interruptUnfinishedGCJobs(ctx); err != nil {
+		t.Fatalf("could not update unfinished jobs: %v", err)
+	}
+
+	job, err := backend.GetGCJob(ctx, "unfinished")
+	if err != nil {
+		t.Fatalf("could not get gc job: %v", err)
+	}
+	if job.Status != GCJobInterrupted || job.Finished == "" {
+		t.Fatalf("invalid gc job state: %+v", job)
+	}
+}
+
+func TestGCServicePruneJobs(t *testing.T) {
+	backend, tmp := StartTestBackend("gc_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.GCJobRetention = 24 * time.Hour
+
+	ctx := context.TODO()
+	now := time.Now()
+	for id, finished := range map[string]time.Time{
+		"old":     now.Add(-48 * time.Hour),
+		"recent":  now.Add(-time.Hour),
+		"running": {},
+	} {
+		status := GCJobSucceeded
+		if finished.IsZero() {
+			status = GCJobRunning
+		}
+		withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+			return CreateGCJob(ctx, tx, GCJob{
+				ID:         id,
+				Repository: "test2.repo.org",
+				Status:     status,
+				Created:    now.Add(-72 * time.Hour),
+				Finished:   finished,
+			})
+		})
+		if err := os.WriteFile(backend.GCJobs.logFile(id), []byte("log"), 0644); err != nil {
+			t.Fatalf("could not write job log: %v", err)
+		}
+	}
+
+	if err := backend.pruneGCJobs(ctx, now); err != nil {
+		t.Fatalf("could not delete old jobs: %v", err)
+	}
+
+	if _, err := backend.GetGCJob(ctx, "old"); err != ErrInvalidGCJob {
+		t.Fatalf("old job was not deleted: %v", err)
+	}
+	if _, err := os.Stat(backend.GCJobs.logFile("old")); !os.IsNotExist(err) {
+		t.Fatalf("log of the old job was not deleted: %v", err)
+	}
+	for _, id := range []string{"recent", "running"} {
+		if _, err := backend.GetGCJob(ctx, id); err != nil {
+			t.Fatalf("job %v should have been kept: %v", id, err)
+		}
+		if _, err := os.Stat(backend.GCJobs.logFile(id)); err != nil {
+			t.Fatalf("log of job %v should have been kept: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// Default settings of the hooks
+const (
+	DefaultHookTimeout    = 30 * time.Second
+	DefaultHookRetryDelay = 5 * time.Second
+	DefaultHookQueueSize  = 1000
+)
+
+// maxHookOutput is the number of bytes of the output of a hook which are logged
+const maxHookOutput = 1024
+
+// Types of hook events
+const (
+	HookPreCommit  = "pre_commit"
+	HookPostCommit = "post_commit"
+)
+
+// hookMode selects how a hook is run
+type hookMode int
+
+const (
+	// hookBackground hooks are run after the event, with bounded concurrency,
+	// and they are retried until they succeed
+	hookBackground hookMode = iota
+	// hookVeto hooks are run before the event by the request which triggers it,
+	// and they can reject the event
+	hookVeto
+)
+
+// CommitRejectedError is returned when a pre-commit hook rejects a commit
+type CommitRejectedError struct {
+	Hook   string
+	Reason string
+}
+
+func (e CommitRejectedError) Error() string {
+	return fmt.Sprintf("commit rejected by hook %v: %v", e.Hook, e.Reason)
+}
+
+// hookRejectedError is returned by a hook which ran, but did not accept the
+// event: an executable which exited with a non-zero status, or a webhook which
+// replied with a 4xx status. The output of the hook is the reason
+type hookRejectedError struct {
+	status string
+	reason string
+}
+
+func (e hookRejectedError) Error() string {
+	return e.status
+}
+
+// HookEvent is the JSON document passed to the hooks. The revision is only set
+// for post-commit events
+type HookEvent struct {
+	Type           string            `json:"type"`
+	Time           string            `json:"time"`
+	Repository     string            `json:"repository"`
+	LeasePath      string            `json:"lease_path"`
+	Revision       uint64            `json:"revision"`
+	OldRootHash    string            `json:"old_root_hash"`
+	NewRootHash    string            `json:"new_root_hash"`
+	TagName        string            `json:"tag_name"`
+	TagDescription string            `json:"tag_description"`
+	KeyID          string            `json:"key_id"`
+	Hostname       string            `json:"hostname"`
+	Statistics     *stats.Statistics `json:"statistics,omitempty"`
+}
+
+// HookRunner runs the hooks configured for the repository events. No more than
+// a fixed number of hook attempts run at the same time, and no more than a
+// fixed number of post-commit hooks are pending
+type HookRunner struct {
+	preCommit  []gw.HookConfig
+	postCommit []gw.HookConfig
+	slots      chan struct{}
+	client     *http.Client
+	ctx        context.Context // cancelled when the runner is stopped
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	// pending holds a token for each post-commit hook waiting or running
+	pending chan struct{}
+}
+
+// NewHookRunner is a constructor function for the HookRunner type
+func NewHookRunner(preCommit, postCommit []gw.HookConfig, concurrency, queueSize int) *HookRunner {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultHookQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HookRunner{
+		preCommit:  preCommit,
+		postCommit: postCommit,
+		slots:      make(chan struct{}, concurrency),
+		pending:    make(chan struct{}, queueSize),
+		client:     &http.Client{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// runPreCommit runs the pre-commit hooks one after the other, in the context
+// of the commit request. It returns a CommitRejectedError as soon as a hook
+// rejects the commit. A hook which fails to run, even after its retries, also
+// prevents the commit
+func (r *HookRunner) runPreCommit(ctx context.Context, event HookEvent) error {
+	if len(r.preCommit) == 0 {
+		return nil
+	}
+
+	event.Type = HookPreCommit
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not serialize hook event: %w", err)
+	}
+
+	for _, hook := range r.preCommit {
+		if err := r.run(ctx, hook, hookVeto, event.Repository, payload); err != nil {
+			if _, ok := err.(CommitRejectedError); ok {
+				return err
+			}
+			return fmt.Errorf("pre-commit hook %v failed: %w", hook.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// runPostCommit starts the post-commit hooks in the background. The hooks
+// outlive the request, whose ID is only kept for logging. When too many hooks
+// are pending, the new ones are dropped
+func (r *HookRunner) runPostCommit(ctx context.Context, event HookEvent) {
+	if len(r.postCommit) == 0 {
+		return
+	}
+
+	event.Type = HookPostCommit
+	payload, err := json.Marshal(event)
+	if err != nil {
+		gw.LogC(ctx, "hooks", gw.LogError).
+			Err(err).
+			Msg("could not serialize hook event")
+		return
+	}
+
+	hookCtx := context.WithValue(r.ctx, gw.IDKey, ctx.Value(gw.IDKey))
+	for _, hook := range r.postCommit {
+		select {
+		case r.pending <- struct{}{}:
+		default:
+			metrics.HookRuns.WithLabelValues(hook.Name, "dropped").Inc()
+			gw.LogC(ctx, "hooks", gw.LogError).
+				Str("hook", hook.Name).
+				Str("repository", event.Repository).
+				Uint64("revision", event.Revision).
+				Msg("hook queue full, hook dropped")
+			continue
+		}
+		r.wg.Add(1)
+		go func(hook gw.HookConfig) {
+			defer func() {
+				<-r.pending
+				r.wg.Done()
+			}()
+			r.run(hookCtx, hook, hookBackground, event.Repository, payload)
+		}(hook)
+	}
+}
+
+// run a hook until it succeeds or runs out of retries. Returns the error of
+// the last attempt, or a CommitRejectedError if a veto hook rejects the event
+func (r *HookRunner) run(ctx context.Context, hook gw.HookConfig, mode hookMode, repository string, payload []byte) error {
+	retryDelay := hook.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = DefaultHookRetryDelay
+	}
+
+	var err error
+	for attempt := 1; attempt <= hook.Retries+1; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		t0 := time.Now()
+		var output string
+		output, err = r.attempt(ctx, hook, mode, repository, payload)
+
+		logEvent := gw.LogC(ctx, "hooks", gw.LogInfo)
+		if err != nil {
+			logEvent = gw.LogC(ctx, "hooks", gw.LogError).Err(err)
+		}
+		logEvent.
+			Str("hook", hook.Name).
+			Str("repository", repository).
+			Int("attempt", attempt).
+			Dur("task_dt", time.Since(t0)).
+			Str("output", output).
+			Msg("hook finished")
+
+		if err == nil {
+			metrics.HookRuns.WithLabelValues(hook.Name, "success").Inc()
+			return nil
+		}
+		var rejected hookRejectedError
+		if mode == hookVeto && errors.As(err, &rejected) {
+			metrics.HookRuns.WithLabelValues(hook.Name, "rejected").Inc()
+			reason := rejected.reason
+			if reason == "" {
+				reason = rejected.status
+			}
+			return CommitRejectedError{Hook: hook.Name, Reason: reason}
+		}
+	}
+
+	metrics.HookRuns.WithLabelValues(hook.Name, "failure").Inc()
+	return err
+}
+
+// attempt runs a hook once. Background hooks wait for a free slot first.
+// Returns the (truncated) output of the hook
+func (r *HookRunner) attempt(ctx context.Context, hook gw.HookConfig, mode hookMode, repository string, payload []byte) (string, error) {
+	if mode == hookBackground {
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		defer func() { <-r.slots }()
+	}
+
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if hook.Command != "" {
+		cmd := exec.CommandContext(ctx, hook.Command, repository)
+		cmd.Stdin = bytes.NewReader(payload)
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			err = hookRejectedError{status: exitErr.Error(), reason: hookReason(output)}
+		}
+		return truncateHookOutput(output), err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	output, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput+1))
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+		status := fmt.Sprintf("webhook returned status %v", resp.StatusCode)
+		return truncateHookOutput(output), hookRejectedError{status: status, reason: hookReason(output)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return truncateHookOutput(output), fmt.Errorf("webhook returned status %v", resp.StatusCode)
+	}
+	return truncateHookOutput(output), nil
+}
+
+// wait for the running hooks to finish
+func (r *HookRunner) wait() {
+	r.wg.Wait()
+}
+
+// stop interrupts the running hooks and waits for them to return
+func (r *HookRunner) stop() {
+	r.cancel()
+	r.wait()
+}
+
+func truncateHookOutput(output []byte) string {
+	if len(output) > maxHookOutput {
+		return string(output[:maxHookOutput]) + "..."
+	}
+	return string(output)
+}
+
+// hookReason returns the reason for a rejection given in the output of a hook
+func hookReason(output []byte) string {
+	return strings.TrimSpace(truncateHookOutput(output))
+}
@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestHookRunner(t *testing.T) {
+	payload := []byte(`{"type":"post_commit"}`)
+
+	t.Run("executable", func(t *testing.T) {
+		tmp := t.TempDir()
+		script := path.Join(tmp, "hook.sh")
+		out := path.Join(tmp, "out")
+		content := "#!/bin/sh\necho \"$1\" > " + out + "\ncat >> " + out + "\n"
+		if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+			t.Fatalf("could not write hook script: %v", err)
+		}
+
+		runner := NewHookRunner(nil, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		hook := gw.HookConfig{Name: "script", Command: script}
+		if err := runner.run(context.TODO(), hook, hookBackground, "test.repo.org", payload); err != nil {
+			t.Fatalf("hook failed: %v", err)
+		}
+		written, _ := os.ReadFile(out)
+		if string(written) != "test.repo.org\n"+string(payload) {
+			t.Errorf("invalid hook input: %q", written)
+		}
+	})
+	t.Run("executable timeout", func(t *testing.T) {
+		runner := NewHookRunner(nil, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		hook := gw.HookConfig{Name: "sleep", Command: "/bin/sleep", Timeout: 50 * time.Millisecond}
+		t0 := time.Now()
+		if err := runner.run(context.TODO(), hook, hookBackground, "10", payload); err == nil {
+			t.Errorf("hook did not time out")
+		}
+		if time.Since(t0) > 5*time.Second {
+			t.Errorf("hook was not interrupted")
+		}
+	})
+	t.Run("webhook retries", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != string(payload) || req.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		runner := NewHookRunner(nil, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		hook := gw.HookConfig{Name: "webhook", URL: server.URL, Retries: 1, RetryDelay: time.Millisecond}
+		if err := runner.run(context.TODO(), hook, hookBackground, "test.repo.org", payload); err == nil {
+			t.Errorf("hook succeeded without enough retries")
+		}
+		hook.Retries = 2
+		atomic.StoreInt32(&calls, 0)
+		if err := runner.run(context.TODO(), hook, hookBackground, "test.repo.org", payload); err != nil {
+			t.Errorf("hook failed: %v", err)
+		}
+		if c := atomic.LoadInt32(&calls); c != 3 {
+			t.Errorf("invalid number of webhook calls: %v", c)
+		}
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		var running, maxRunning int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		hooks := make([]gw.HookConfig, 6)
+		for i := range hooks {
+			hooks[i] = gw.HookConfig{Name: "webhook", URL: server.URL}
+		}
+		runner := NewHookRunner(nil, hooks, 2, DefaultHookQueueSize)
+		runner.runPostCommit(context.TODO(), HookEvent{Repository: "test.repo.org"})
+		runner.wait()
+
+		if m := atomic.LoadInt32(&maxRunning); m != 2 {
+			t.Errorf("invalid maximum number of concurrent hooks: %v", m)
+		}
+	})
+	t.Run("queue full", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+		}))
+		defer server.Close()
+
+		hooks := make([]gw.HookConfig, 3)
+		for i := range hooks {
+			hooks[i] = gw.HookConfig{Name: "webhook", URL: server.URL}
+		}
+		runner := NewHookRunner(nil, hooks, 1, 2)
+		runner.runPostCommit(context.TODO(), HookEvent{Repository: "test.repo.org"})
+		close(release)
+		runner.wait()
+
+		if c := atomic.LoadInt32(&calls); c != 2 {
+			t.Errorf("hooks beyond the queue size should be dropped, got %v calls", c)
+		}
+	})
+}
+
+func TestPostCommitHook(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("post_commit_hook_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	var lock sync.Mutex
+	events := make([]HookEvent, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event HookEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	backend.Hooks = NewHookRunner(nil, []gw.HookConfig{{Name: "webhook", URL: server.URL}}, 1, DefaultHookQueueSize)
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	tag := gw.RepositoryTag{Name: "mytag", Description: "this is a tag"}
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", tag); err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+	backend.Hooks.wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("invalid number of hook events: %v", len(events))
+	}
+	e := events[0]
+	if e.Type != HookPostCommit || e.Repository != "test2.repo.org" || e.Revision != 1 ||
+		e.NewRootHash != "new_hash" || e.TagName != "mytag" || e.KeyID != "keyid1" ||
+		e.Statistics == nil {
+		t.Errorf("invalid hook event: %+v", e)
+	}
+}
+
+func TestPreCommitHooks(t *testing.T) {
+	t.Run("executable rejection", func(t *testing.T) {
+		script := path.Join(t.TempDir(), "hook.sh")
+		content := "#!/bin/sh\necho 'repository is frozen'\nexit 1\n"
+		if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+			t.Fatalf("could not write hook script: %v", err)
+		}
+
+		runner := NewHookRunner([]gw.HookConfig{{Name: "freeze", Command: script}}, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		err := runner.runPreCommit(context.TODO(), HookEvent{Repository: "test.repo.org"})
+		rejected, ok := err.(CommitRejectedError)
+		if !ok || rejected.Hook != "freeze" || rejected.Reason != "repository is frozen" {
+			t.Errorf("invalid pre-commit result: %v", err)
+		}
+	})
+	t.Run("webhook rejection", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("invalid tag name\n"))
+		}))
+		defer server.Close()
+
+		hook := gw.HookConfig{Name: "tags", URL: server.URL, Retries: 2, RetryDelay: time.Millisecond}
+		runner := NewHookRunner([]gw.HookConfig{hook}, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		err := runner.runPreCommit(context.TODO(), HookEvent{Repository: "test.repo.org"})
+		rejected, ok := err.(CommitRejectedError)
+		if !ok || rejected.Reason != "invalid tag name" {
+			t.Errorf("invalid pre-commit result: %v", err)
+		}
+		if c := atomic.LoadInt32(&calls); c != 1 {
+			t.Errorf("rejecting webhook called %v times", c)
+		}
+	})
+	t.Run("webhook failure", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		hook := gw.HookConfig{Name: "broken", URL: server.URL, Retries: 1, RetryDelay: time.Millisecond}
+		runner := NewHookRunner([]gw.HookConfig{hook}, nil, 1, DefaultHookQueueSize)
+		defer runner.stop()
+		err := runner.runPreCommit(context.TODO(), HookEvent{Repository: "test.repo.org"})
+		if _, ok := err.(CommitRejectedError); err == nil || ok {
+			t.Errorf("invalid pre-commit result: %v", err)
+		}
+		if c := atomic.LoadInt32(&calls); c != 2 {
+			t.Errorf("failing webhook called %v times", c)
+		}
+	})
+}
+
+func TestPreCommitHookVeto(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("pre_commit_hook_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event HookEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil || event.Type != HookPreCommit {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(event.TagName, "v") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("tag names must start with 'v'"))
+		}
+	}))
+	defer server.Close()
+
+	backend.Hooks = NewHookRunner([]gw.HookConfig{{Name: "tags", URL: server.URL}}, nil, 1, DefaultHookQueueSize)
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host1", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+
+	_, err = backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{Name: "release"})
+	if rejected, ok := err.(CommitRejectedError); !ok || rejected.Reason != "tag names must start with 'v'" {
+		t.Fatalf("commit not rejected: %v", err)
+	}
+	history, err := backend.GetCommitHistory(ctx, "test2.repo.org", 0, 0)
+	if err != nil || history.Total != 0 {
+		t.Fatalf("rejected commit recorded: %+v, %v", history, err)
+	}
+
+	// The lease is still valid after a rejection
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{Name: "v1"}); err != nil {
+		t.Errorf("could not commit lease: %v", err)
+	}
+}
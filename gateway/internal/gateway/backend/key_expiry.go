@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// DefaultKeyExpiryCheckInterval is the interval between the checks for keys
+// which are about to expire
+const DefaultKeyExpiryCheckInterval = 1 * time.Hour
+
+// keyExpiryWarningRepeat is the minimum time between two warnings about the
+// expiry of the same key
+const keyExpiryWarningRepeat = 24 * time.Hour
+
+// KeyExpiryMonitor periodically logs warnings about the keys which are about
+// to expire, or which have expired
+type KeyExpiryMonitor struct {
+	// warned holds the time of the last warning, by key ID
+	warned map[string]time.Time
+	// stopMonitor is closed to stop the monitor, if it was started
+	stopMonitor chan struct{}
+	wg          sync.WaitGroup
+	lock        sync.Mutex
+}
+
+// expiringKey is a key which expires before the end of a time window
+type expiringKey struct {
+	ID       string
+	NotAfter time.Time
+}
+
+// NewKeyExpiryMonitor creates a key expiry monitor, which is not started
+func NewKeyExpiryMonitor() *KeyExpiryMonitor {
+	return &KeyExpiryMonitor{warned: make(map[string]time.Time)}
+}
+
+// stop stops the monitor and waits for it to finish
+func (m *KeyExpiryMonitor) stop() {
+	m.lock.Lock()
+	if m.stopMonitor != nil {
+		close(m.stopMonitor)
+		m.stopMonitor = nil
+	}
+	m.lock.Unlock()
+	m.wg.Wait()
+}
+
+// StartKeyExpiryMonitor starts checking every interval, in the background,
+// for the keys which expire within the key_expiry_warning time
+func (s *Services) StartKeyExpiryMonitor(interval time.Duration) {
+	stop := make(chan struct{})
+	s.KeyExpiry.lock.Lock()
+	s.KeyExpiry.stopMonitor = stop
+	s.KeyExpiry.wg.Add(1)
+	s.KeyExpiry.lock.Unlock()
+
+	go func() {
+		defer s.KeyExpiry.wg.Done()
+		s.WarnExpiringKeys(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.WarnExpiringKeys(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// WarnExpiringKeys logs a warning for each key which expires within the
+// key_expiry_warning time, or has expired. The same key is warned about at
+// most once per day. Returns the IDs of the keys which were warned about
+func (s *Services) WarnExpiringKeys(now time.Time) []string {
+	keys := s.expiringKeys(now, s.Config.KeyExpiryWarning)
+
+	m := s.KeyExpiry
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	warned := make([]string, 0)
+	current := make(map[string]time.Time, len(keys))
+	for _, k := range keys {
+		last, present := m.warned[k.ID]
+		if present && now.Sub(last) < keyExpiryWarningRepeat {
+			current[k.ID] = last
+			continue
+		}
+		current[k.ID] = now
+
+		msg := "key expires soon"
+		if !now.Before(k.NotAfter) {
+			msg = "key has expired"
+		}
+		gw.Log("keys", gw.LogWarn).
+			Str("key_id", k.ID).
+			Time("not_after", k.NotAfter).
+			Msg(msg)
+		warned = append(warned, k.ID)
+	}
+	// Keys which no longer expire soon, after a configuration change, are
+	// forgotten
+	m.warned = current
+
+	return warned
+}
+
+// GetExpiringKeys returns the enabled keys which expire within the given
+// time, including the keys which have expired, sorted by expiry. With a zero
+// time, the key_expiry_warning time is used
+func (s *Services) GetExpiringKeys(ctx context.Context, within time.Duration) ([]KeyDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_expiring_keys", &outcome, t0)
+
+	if within == 0 {
+		within = s.Config.KeyExpiryWarning
+	}
+
+	repos := s.Access.GetRepos()
+	now := time.Now()
+
+	ret := make([]KeyDTO, 0)
+	for _, k := range s.expiringKeys(now, within) {
+		ret = append(ret, s.newKeyDTO(k.ID, repos, now))
+	}
+
+	return ret, nil
+}
+
+// expiringKeys returns the enabled keys which expire before now + within,
+// sorted by expiry
+func (s *Services) expiringKeys(now time.Time, within time.Duration) []expiringKey {
+	keys := make([]expiringKey, 0)
+	for _, id := range s.Access.getKeyIDs() {
+		cfg := s.Access.GetKeyConfig(id)
+		if cfg == nil || cfg.NotAfter.IsZero() {
+			continue
+		}
+		if cfg.NotAfter.Before(now.Add(within)) {
+			keys = append(keys, expiringKey{ID: id, NotAfter: cfg.NotAfter})
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].NotAfter.Before(keys[j].NotAfter)
+	})
+	return keys
+}
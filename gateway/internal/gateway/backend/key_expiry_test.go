@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyExpiry(t *testing.T) {
+	backend, tmp := StartTestBackend("key_expiry_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.KeyExpiryWarning = 7 * 24 * time.Hour
+
+	now := time.Now()
+	cfg := fmt.Sprintf(`
+{
+	"version": 2,
+	"repos" : [
+		{"domain": "test.repo.org", "keys": [{"id": "keyid1", "path": "/"}, {"id": "keyid2", "path": "/"}]}
+	],
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1", "not_after": %q},
+		{"type": "plain_text", "id": "keyid2", "secret": "secret2", "not_after": %q},
+		{"type": "plain_text", "id": "keyid3", "secret": "secret3", "not_after": %q},
+		{"type": "plain_text", "id": "keyid4", "secret": "secret4"}
+	]
+}
+`,
+		now.Add(24*time.Hour).Format(time.RFC3339),
+		now.Add(30*24*time.Hour).Format(time.RFC3339),
+		now.Add(-time.Hour).Format(time.RFC3339))
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(cfg), mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	backend.Access.Swap(ac)
+
+	ctx := context.TODO()
+	listExpiring := func(within time.Duration) []string {
+		keys, err := backend.GetExpiringKeys(ctx, within)
+		if err != nil {
+			t.Fatalf("could not list expiring keys: %v", err)
+		}
+		ids := make([]string, 0)
+		for _, k := range keys {
+			if k.NotAfter == "" {
+				t.Fatalf("expiry of key %v missing", k.ID)
+			}
+			ids = append(ids, k.ID)
+		}
+		return ids
+	}
+
+	t.Run("list", func(t *testing.T) {
+		if ids := listExpiring(0); strings.Join(ids, ",") != "keyid3,keyid1" {
+			t.Errorf("invalid expiring keys: %v", ids)
+		}
+		if ids := listExpiring(60 * 24 * time.Hour); strings.Join(ids, ",") != "keyid3,keyid1,keyid2" {
+			t.Errorf("invalid expiring keys: %v", ids)
+		}
+	})
+
+	t.Run("warnings", func(t *testing.T) {
+		if warned := backend.WarnExpiringKeys(now); strings.Join(warned, ",") != "keyid3,keyid1" {
+			t.Errorf("invalid warnings: %v", warned)
+		}
+		if warned := backend.WarnExpiringKeys(now.Add(time.Hour)); len(warned) != 0 {
+			t.Errorf("warnings repeated too early: %v", warned)
+		}
+		if warned := backend.WarnExpiringKeys(now.Add(25 * time.Hour)); strings.Join(warned, ",") != "keyid3,keyid1" {
+			t.Errorf("invalid repeated warnings: %v", warned)
+		}
+	})
+
+	t.Run("disabled key", func(t *testing.T) {
+		if _, err := backend.SetKeyEnabled(ctx, "keyid1", false); err != nil {
+			t.Fatalf("could not disable key: %v", err)
+		}
+		if ids := listExpiring(0); strings.Join(ids, ",") != "keyid3" {
+			t.Errorf("invalid expiring keys: %v", ids)
+		}
+	})
+}
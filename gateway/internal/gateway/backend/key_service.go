@@ -0,0 +1,458 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultKeyRotationOverlap is the time during which the previous secret of a
+// rotated key is still accepted, if no overlap is given
+const DefaultKeyRotationOverlap = time.Hour
+
+// validKeyID matches the IDs of the keys which can be created at runtime
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// KeyDTO is the key information returned to the HTTP frontend. Secrets are
+// never included
+type KeyDTO struct {
+	ID      string `json:"id"`
+	Admin   bool   `json:"admin"`
+	Enabled bool   `json:"enabled"`
+	// InConfigFile is true for the keys of the access configuration file, and
+	// Managed for the keys created or changed with the admin API
+	InConfigFile bool `json:"in_config_file"`
+	Managed      bool `json:"managed"`
+	// PreviousSecretExpires is set while the previous secret of a rotated key
+	// is still accepted
+	PreviousSecretExpires string `json:"previous_secret_expires,omitempty"`
+	// NotBefore and NotAfter delimit the validity window of the key, if any
+	NotBefore string `json:"not_before,omitempty"`
+	NotAfter  string `json:"not_after,omitempty"`
+	// Repositories maps the repositories of the key to its subpaths
+	Repositories KeyPaths `json:"repositories"`
+}
+
+// KeyOptions are the optional properties of a key created at runtime
+type KeyOptions struct {
+	Admin bool `json:"admin"`
+	// ClientCertSubject is the subject of the TLS client certificates which
+	// authenticate as the key, in RFC 2253 format
+	ClientCertSubject string `json:"client_cert_subject,omitempty"`
+	// NotBefore and NotAfter delimit the validity window of the key, if not
+	// zero
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+// KeySecretDTO is returned when a key is created or rotated. It is the only
+// time the secret of the key is shown
+type KeySecretDTO struct {
+	KeyID                 string `json:"key_id"`
+	Secret                string `json:"secret"`
+	PreviousSecretExpires string `json:"previous_secret_expires,omitempty"`
+}
+
+// GetKeys returns the keys of the access configuration file and the managed
+// keys, sorted by ID
+func (s *Services) GetKeys(ctx context.Context) ([]KeyDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_keys", &outcome, t0)
+
+	repos := s.Access.GetRepos()
+	now := time.Now()
+
+	ret := make([]KeyDTO, 0)
+	for _, id := range s.Access.getKeyIDs() {
+		ret = append(ret, s.newKeyDTO(id, repos, now))
+	}
+
+	return ret, nil
+}
+
+// newKeyDTO describes a key of the access configuration file or a managed key
+func (s *Services) newKeyDTO(id string, repos map[string]RepositoryConfig, now time.Time) KeyDTO {
+	fileCfg := s.Access.getFileKeyConfig(id)
+	managed := s.Access.getManagedKey(id)
+	dto := KeyDTO{
+		ID:           id,
+		Enabled:      managed == nil || managed.Enabled,
+		InConfigFile: fileCfg != nil,
+		Managed:      managed != nil,
+		Repositories: make(KeyPaths),
+	}
+	var notBefore, notAfter time.Time
+	if fileCfg != nil {
+		dto.Admin = fileCfg.Admin
+		notBefore, notAfter = fileCfg.NotBefore, fileCfg.NotAfter
+	} else {
+		dto.Admin = managed.Admin
+	}
+	if managed != nil {
+		if !managed.NotBefore.IsZero() {
+			notBefore = managed.NotBefore
+		}
+		if !managed.NotAfter.IsZero() {
+			notAfter = managed.NotAfter
+		}
+	}
+	if !notBefore.IsZero() {
+		dto.NotBefore = notBefore.Format(time.RFC3339)
+	}
+	if !notAfter.IsZero() {
+		dto.NotAfter = notAfter.Format(time.RFC3339)
+	}
+	if managed != nil && now.Before(managed.PreviousSecretExpiration) {
+		dto.PreviousSecretExpires = managed.PreviousSecretExpiration.Format(time.RFC3339)
+	}
+	for name, cfg := range repos {
+		if path, present := cfg.Keys[id]; present {
+			dto.Repositories[name] = path
+		}
+	}
+	return dto
+}
+
+// CreateKey creates a new managed key with a random secret, which is returned
+func (s *Services) CreateKey(ctx context.Context, keyID string, opts KeyOptions) (*KeySecretDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "create_key", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "create_key", KeyID: keyID}, outcome)
+	}()
+
+	if !validKeyID.MatchString(keyID) {
+		outcome = "invalid_key_id"
+		return nil, fmt.Errorf(outcome)
+	}
+	if s.Access.getFileKeyConfig(keyID) != nil {
+		outcome = "key_exists"
+		return nil, fmt.Errorf(outcome)
+	}
+	if opts.ClientCertSubject != "" && s.Access.GetKeyIDByCertSubject(opts.ClientCertSubject) != "" {
+		outcome = "client_cert_subject_exists"
+		return nil, fmt.Errorf(outcome)
+	}
+	if !opts.NotBefore.IsZero() && !opts.NotAfter.IsZero() && !opts.NotAfter.After(opts.NotBefore) {
+		outcome = "invalid_validity"
+		return nil, fmt.Errorf(outcome)
+	}
+
+	secret, err := newKeySecret()
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		existing, err := FindManagedKeyByID(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("key_exists")
+		}
+		now := time.Now()
+		return CreateManagedKey(ctx, tx, ManagedKey{
+			ID:                keyID,
+			Secret:            secret,
+			Admin:             opts.Admin,
+			Enabled:           true,
+			Created:           now,
+			Updated:           now,
+			ClientCertSubject: opts.ClientCertSubject,
+			NotBefore:         opts.NotBefore,
+			NotAfter:          opts.NotAfter,
+		})
+	}); err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	return &KeySecretDTO{KeyID: keyID, Secret: secret}, nil
+}
+
+// SetKeyEnabled enables or disables a key. Keys from the access configuration
+// file are disabled by a managed key which overrides them. The leases of a
+// disabled key are revoked
+func (s *Services) SetKeyEnabled(ctx context.Context, keyID string, enable bool) (*RevocationReport, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "set_key_enabled", &outcome, t0)
+	action := "disable_key"
+	if enable {
+		action = "enable_key"
+	}
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: action, KeyID: keyID}, outcome)
+	}()
+
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		key, err := s.findOrOverrideManagedKey(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		key.Enabled = enable
+		key.Updated = time.Now()
+		return s.saveManagedKey(ctx, tx, key)
+	}); err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	report, err := s.revokeLeases(ctx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// RotateKey replaces the secret of a key with a new random secret, which is
+// returned. The previous secret is still accepted during the overlap
+func (s *Services) RotateKey(ctx context.Context, keyID string, overlap time.Duration) (*KeySecretDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "rotate_key", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "rotate_key", KeyID: keyID}, outcome)
+	}()
+
+	if overlap < 0 {
+		outcome = "invalid_overlap"
+		return nil, fmt.Errorf(outcome)
+	}
+
+	secret, err := newKeySecret()
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	ret := &KeySecretDTO{KeyID: keyID, Secret: secret}
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		key, err := s.findOrOverrideManagedKey(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		previous := key.Secret
+		if previous == "" {
+			if fileCfg := s.Access.getFileKeyConfig(keyID); fileCfg != nil {
+				previous = fileCfg.Secret
+			}
+		}
+		now := time.Now()
+		key.Secret = secret
+		key.PreviousSecret = ""
+		key.PreviousSecretExpiration = time.Time{}
+		if overlap > 0 && previous != "" {
+			key.PreviousSecret = previous
+			key.PreviousSecretExpiration = now.Add(overlap)
+			ret.PreviousSecretExpires = key.PreviousSecretExpiration.Format(time.RFC3339)
+		}
+		key.Updated = now
+		return s.saveManagedKey(ctx, tx, key)
+	}); err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// DeleteKey deletes a managed key and its repository subpaths. A key of the
+// access configuration file is restored to its configuration, it can only be
+// removed from the file. The revoked leases are reported
+func (s *Services) DeleteKey(ctx context.Context, keyID string) (*RevocationReport, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "delete_key", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "delete_key", KeyID: keyID}, outcome)
+	}()
+
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		key, err := FindManagedKeyByID(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			if s.Access.getFileKeyConfig(keyID) != nil {
+				return fmt.Errorf("key_not_managed")
+			}
+			return fmt.Errorf("invalid_key")
+		}
+		return DeleteManagedKeyByID(ctx, tx, keyID)
+	}); err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	report, err := s.revokeLeases(ctx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// AttachKey allows a key to publish to a subpath of a repository. It replaces
+// the subpath of the key in the repository from the access configuration file
+func (s *Services) AttachKey(ctx context.Context, keyID, repository, path string) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "attach_key", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "attach_key", KeyID: keyID, Repository: repository}, outcome)
+	}()
+
+	if s.Access.GetRepo(repository) == nil {
+		outcome = "invalid_repo"
+		return fmt.Errorf(outcome)
+	}
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		outcome = "invalid_path"
+		return fmt.Errorf(outcome)
+	}
+
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		if _, err := s.findOrOverrideManagedKey(ctx, tx, keyID); err != nil {
+			return err
+		}
+		return SetManagedKeyPath(ctx, tx, ManagedKeyPath{KeyID: keyID, Repository: repository, Path: path})
+	}); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// DetachKey removes the subpath of a repository attached to a key with the
+// admin API. The revoked leases are reported
+func (s *Services) DetachKey(ctx context.Context, keyID, repository string) (*RevocationReport, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "detach_key", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "detach_key", KeyID: keyID, Repository: repository}, outcome)
+	}()
+
+	if err := s.changeManagedKeys(ctx, func(tx *sql.Tx) error {
+		deleted, err := DeleteManagedKeyPath(ctx, tx, keyID, repository)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("invalid_key_path")
+		}
+		return nil
+	}); err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	report, err := s.revokeLeases(ctx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// LoadManagedKeys installs the managed keys from the DB in the access
+// configuration
+func (s *Services) LoadManagedKeys(ctx context.Context) error {
+	return s.changeManagedKeys(ctx, func(tx *sql.Tx) error { return nil })
+}
+
+// changeManagedKeys applies a change to the managed keys in a transaction,
+// and then installs all the managed keys from the DB in the access
+// configuration
+func (s *Services) changeManagedKeys(ctx context.Context, change func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := change(tx); err != nil {
+		return err
+	}
+
+	keys, err := FindAllManagedKeys(ctx, tx)
+	if err != nil {
+		return err
+	}
+	paths, err := FindAllManagedKeyPaths(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	s.Access.SetManagedKeys(keys, paths)
+
+	return nil
+}
+
+// findOrOverrideManagedKey returns the managed key with the given ID. For a
+// key of the access configuration file which is not managed yet, a new
+// managed key overriding it is returned, with no secret of its own
+func (s *Services) findOrOverrideManagedKey(ctx context.Context, tx *sql.Tx, keyID string) (*ManagedKey, error) {
+	key, err := FindManagedKeyByID(ctx, tx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return key, nil
+	}
+
+	fileCfg := s.Access.getFileKeyConfig(keyID)
+	if fileCfg == nil {
+		return nil, fmt.Errorf("invalid_key")
+	}
+	// A zero creation time marks the key as not stored yet
+	return &ManagedKey{ID: keyID, Admin: fileCfg.Admin, Enabled: true}, nil
+}
+
+// saveManagedKey creates or updates a managed key returned by
+// findOrOverrideManagedKey
+func (s *Services) saveManagedKey(ctx context.Context, tx *sql.Tx, key *ManagedKey) error {
+	if key.Created.IsZero() {
+		key.Created = key.Updated
+		return CreateManagedKey(ctx, tx, *key)
+	}
+	return UpdateManagedKey(ctx, tx, *key)
+}
+
+// newKeySecret returns a random key secret
+func newKeySecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("could not generate key secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
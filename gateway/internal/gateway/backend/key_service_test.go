@@ -0,0 +1,258 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyServiceManagedKeys(t *testing.T) {
+	backend, tmp := StartTestBackend("key_service_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.RevokedLeasePolicy = "cancel"
+
+	ctx := context.TODO()
+	lastProtocolVersion := 3
+
+	var secret string
+	t.Run("create", func(t *testing.T) {
+		created, err := backend.CreateKey(ctx, "keyid3", KeyOptions{})
+		if err != nil {
+			t.Fatalf("could not create key: %v", err)
+		}
+		secret = created.Secret
+		cfg := backend.GetKey(ctx, "keyid3")
+		if cfg == nil || cfg.Secret != secret || secret == "" {
+			t.Fatalf("invalid configuration of the new key: %v", cfg)
+		}
+
+		for keyID, reason := range map[string]string{
+			"keyid3":  "key_exists",
+			"keyid2":  "key_exists",
+			"bad key": "invalid_key_id",
+			"":        "invalid_key_id",
+		} {
+			if _, err := backend.CreateKey(ctx, keyID, KeyOptions{}); err == nil || err.Error() != reason {
+				t.Fatalf("creating key %q should fail with %v, got: %v", keyID, reason, err)
+			}
+		}
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		subject := "CN=publisher4,O=Test"
+		if _, err := backend.CreateKey(ctx, "keyid4", KeyOptions{ClientCertSubject: subject}); err != nil {
+			t.Fatalf("could not create key: %v", err)
+		}
+		if id := backend.GetKeyIDByCertSubject(ctx, subject); id != "keyid4" {
+			t.Fatalf("invalid key for the certificate subject of the new key: %q", id)
+		}
+		if _, err := backend.CreateKey(ctx, "keyid5", KeyOptions{ClientCertSubject: subject}); err == nil ||
+			err.Error() != "client_cert_subject_exists" {
+			t.Fatalf("creating a key with the same certificate subject should fail, got: %v", err)
+		}
+
+		if _, err := backend.SetKeyEnabled(ctx, "keyid4", false); err != nil {
+			t.Fatalf("could not disable key: %v", err)
+		}
+		if id := backend.GetKeyIDByCertSubject(ctx, subject); id != "" {
+			t.Fatalf("disabled key found for its certificate subject: %q", id)
+		}
+		if _, err := backend.DeleteKey(ctx, "keyid4"); err != nil {
+			t.Fatalf("could not delete key: %v", err)
+		}
+	})
+
+	t.Run("validity", func(t *testing.T) {
+		notBefore := time.Now().Add(-time.Hour).Truncate(time.Second)
+		notAfter := time.Now().Add(time.Minute).Truncate(time.Second)
+		if _, err := backend.CreateKey(ctx, "keyid5", KeyOptions{NotBefore: notBefore, NotAfter: notAfter}); err != nil {
+			t.Fatalf("could not create key: %v", err)
+		}
+		cfg := backend.GetKey(ctx, "keyid5")
+		if cfg == nil || !cfg.NotBefore.Equal(notBefore) || !cfg.NotAfter.Equal(notAfter) {
+			t.Fatalf("invalid validity window of the new key: %v", cfg)
+		}
+		if err := cfg.CheckValidity(notAfter); err == nil || err.Error() != "key_expired" {
+			t.Fatalf("key should have expired: %v", err)
+		}
+		if keys := backend.expiringKeys(time.Now(), time.Hour); len(keys) != 1 || keys[0].ID != "keyid5" {
+			t.Fatalf("invalid expiring keys: %v", keys)
+		}
+
+		keys, err := backend.GetKeys(ctx)
+		if err != nil {
+			t.Fatalf("could not get keys: %v", err)
+		}
+		for _, k := range keys {
+			if k.ID == "keyid5" && k.NotAfter != notAfter.Format(time.RFC3339) {
+				t.Fatalf("invalid validity window of the key: %+v", k)
+			}
+		}
+
+		if _, err := backend.CreateKey(ctx, "keyid6", KeyOptions{NotBefore: notAfter, NotAfter: notBefore}); err == nil ||
+			err.Error() != "invalid_validity" {
+			t.Fatalf("creating a key which expires before it becomes valid should fail, got: %v", err)
+		}
+		if _, err := backend.DeleteKey(ctx, "keyid5"); err != nil {
+			t.Fatalf("could not delete key: %v", err)
+		}
+	})
+
+	t.Run("attach", func(t *testing.T) {
+		if err := backend.Access.Check("keyid3", "/sub/path", "test2.repo.org"); err == nil {
+			t.Fatalf("key should not be attached to the repository yet")
+		}
+		if err := backend.AttachKey(ctx, "keyid3", "test2.repo.org", "/sub"); err != nil {
+			t.Fatalf("could not attach key: %v", err)
+		}
+		if err := backend.Access.Check("keyid3", "/sub/path", "test2.repo.org"); err != nil {
+			t.Fatalf("attached key was refused: %v", err)
+		}
+		if err := backend.Access.Check("keyid3", "/other", "test2.repo.org"); err == nil {
+			t.Fatalf("attached key should be restricted to its subpath")
+		}
+
+		if err := backend.AttachKey(ctx, "keyid3", "unknown.repo.org", "/"); err == nil || err.Error() != "invalid_repo" {
+			t.Fatalf("attaching to an unknown repository should fail: %v", err)
+		}
+		if err := backend.AttachKey(ctx, "keyid3", "test2.repo.org", "sub"); err == nil || err.Error() != "invalid_path" {
+			t.Fatalf("attaching to a relative path should fail: %v", err)
+		}
+		if err := backend.AttachKey(ctx, "unknown", "test2.repo.org", "/"); err == nil || err.Error() != "invalid_key" {
+			t.Fatalf("attaching an unknown key should fail: %v", err)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		if _, err := backend.NewLease(ctx, "keyid3", "test2.repo.org/sub/path", "host", lastProtocolVersion); err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+
+		report, err := backend.SetKeyEnabled(ctx, "keyid3", false)
+		if err != nil {
+			t.Fatalf("could not disable key: %v", err)
+		}
+		if len(report.RevokedLeases) != 1 || report.RevokedLeases[0].KeyID != "keyid3" {
+			t.Fatalf("invalid revoked leases: %v", report.RevokedLeases)
+		}
+		if backend.GetKey(ctx, "keyid3") != nil {
+			t.Fatalf("disabled key is still valid")
+		}
+		if err := backend.Access.Check("keyid3", "/sub/path", "test2.repo.org"); err == nil {
+			t.Fatalf("disabled key was accepted")
+		}
+
+		if _, err := backend.SetKeyEnabled(ctx, "keyid2", false); err != nil {
+			t.Fatalf("could not disable key from the configuration file: %v", err)
+		}
+		if backend.GetKey(ctx, "keyid2") != nil {
+			t.Fatalf("disabled key from the configuration file is still valid")
+		}
+
+		for _, keyID := range []string{"keyid2", "keyid3"} {
+			if _, err := backend.SetKeyEnabled(ctx, keyID, true); err != nil {
+				t.Fatalf("could not enable key: %v", err)
+			}
+		}
+		if cfg := backend.GetKey(ctx, "keyid2"); cfg == nil || cfg.Secret != "secret2" {
+			t.Fatalf("invalid configuration of the enabled key: %v", cfg)
+		}
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		rotated, err := backend.RotateKey(ctx, "keyid2", time.Hour)
+		if err != nil {
+			t.Fatalf("could not rotate key: %v", err)
+		}
+		if rotated.PreviousSecretExpires == "" {
+			t.Fatalf("expiration of the previous secret missing")
+		}
+		cfg := backend.GetKey(ctx, "keyid2")
+		if cfg.Secret != rotated.Secret || cfg.PreviousSecret != "secret2" {
+			t.Fatalf("invalid configuration of the rotated key: %v", cfg)
+		}
+
+		rotated, err = backend.RotateKey(ctx, "keyid3", 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("could not rotate key: %v", err)
+		}
+		if cfg := backend.GetKey(ctx, "keyid3"); cfg.PreviousSecret != secret {
+			t.Fatalf("previous secret not accepted during the overlap: %v", cfg)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if cfg := backend.GetKey(ctx, "keyid3"); cfg.Secret != rotated.Secret || cfg.PreviousSecret != "" {
+			t.Fatalf("previous secret still accepted after the overlap: %v", cfg)
+		}
+
+		if _, err := backend.RotateKey(ctx, "keyid3", -time.Second); err == nil {
+			t.Fatalf("rotation with negative overlap should fail")
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		backend.Access.SetManagedKeys(nil, nil)
+		if backend.GetKey(ctx, "keyid3") != nil {
+			t.Fatalf("managed keys not cleared")
+		}
+		if err := backend.LoadManagedKeys(ctx); err != nil {
+			t.Fatalf("could not load managed keys: %v", err)
+		}
+		if backend.GetKey(ctx, "keyid3") == nil {
+			t.Fatalf("managed key not loaded from the database")
+		}
+		if err := backend.Access.Check("keyid3", "/sub/path", "test2.repo.org"); err != nil {
+			t.Fatalf("subpath of managed key not loaded from the database: %v", err)
+		}
+
+		keys, err := backend.GetKeys(ctx)
+		if err != nil {
+			t.Fatalf("could not list keys: %v", err)
+		}
+		found := map[string]KeyDTO{}
+		for _, k := range keys {
+			found[k.ID] = k
+		}
+		if k := found["keyid2"]; !k.InConfigFile || !k.Managed || k.PreviousSecretExpires == "" {
+			t.Fatalf("invalid listing of key from the configuration file: %+v", k)
+		}
+		if k := found["keyid3"]; k.InConfigFile || !k.Managed || k.Repositories["test2.repo.org"] != "/sub" {
+			t.Fatalf("invalid listing of managed key: %+v", k)
+		}
+	})
+
+	t.Run("detach and delete", func(t *testing.T) {
+		if _, err := backend.DetachKey(ctx, "keyid3", "test2.repo.org"); err != nil {
+			t.Fatalf("could not detach key: %v", err)
+		}
+		if err := backend.Access.Check("keyid3", "/sub/path", "test2.repo.org"); err == nil {
+			t.Fatalf("detached key was accepted")
+		}
+		if _, err := backend.DetachKey(ctx, "keyid3", "test2.repo.org"); err == nil {
+			t.Fatalf("detaching twice should fail")
+		}
+
+		if _, err := backend.DeleteKey(ctx, "keyid3"); err != nil {
+			t.Fatalf("could not delete key: %v", err)
+		}
+		if backend.GetKey(ctx, "keyid3") != nil {
+			t.Fatalf("deleted key is still valid")
+		}
+
+		if _, err := backend.DeleteKey(ctx, "keyid2"); err != nil {
+			t.Fatalf("could not delete managed state of key: %v", err)
+		}
+		if cfg := backend.GetKey(ctx, "keyid2"); cfg == nil || cfg.Secret != "secret2" || cfg.PreviousSecret != "" {
+			t.Fatalf("key from the configuration file not restored: %v", cfg)
+		}
+		if _, err := backend.DeleteKey(ctx, "keyid2"); err == nil || err.Error() != "key_not_managed" {
+			t.Fatalf("deleting a key from the configuration file should fail: %v", err)
+		}
+		if _, err := backend.DeleteKey(ctx, "unknown"); err == nil || err.Error() != "invalid_key" {
+			t.Fatalf("deleting an unknown key should fail: %v", err)
+		}
+	})
+}
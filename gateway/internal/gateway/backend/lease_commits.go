@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// ErrCommitInProgress is returned when committing or cancelling a lease whose
+// commit is in progress
+var ErrCommitInProgress = fmt.Errorf("commit_in_progress")
+
+// LeaseCommits keeps the leases whose commit is in progress. Such a lease
+// can't be committed again or cancelled, and its path can't be leased again,
+// until the commit has finished, even if the lease expires meanwhile
+type LeaseCommits struct {
+	leases map[string]Lease // by token
+	lock   sync.Mutex
+}
+
+// NewLeaseCommits is a constructor function for the LeaseCommits type
+func NewLeaseCommits() *LeaseCommits {
+	return &LeaseCommits{leases: make(map[string]Lease)}
+}
+
+// begin marks the lease as being committed. Returns false if its commit is
+// already in progress
+func (c *LeaseCommits) begin(lease Lease) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, present := c.leases[lease.Token]; present {
+		return false
+	}
+	c.leases[lease.Token] = lease
+	return true
+}
+
+// end marks the commit of a lease as finished
+func (c *LeaseCommits) end(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.leases, token)
+}
+
+// inProgress returns true if the commit of a lease is in progress
+func (c *LeaseCommits) inProgress(token string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, present := c.leases[token]
+	return present
+}
+
+// tokens returns the tokens of the leases of a repository being committed
+func (c *LeaseCommits) tokens(repository string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tokens := make([]string, 0)
+	for token, l := range c.leases {
+		if l.Repository == repository {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// overlaps returns true if a lease being committed overlaps with the given
+// repository path
+func (c *LeaseCommits) overlaps(repository, path string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, l := range c.leases {
+		if l.Repository == repository && gw.CheckPathOverlap(l.Path, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
 )
 
 // PathBusyError is returned as error value for new lease requests on
@@ -34,6 +35,10 @@ func (e InvalidLeaseError) Error() string {
 	return "invalid lease"
 }
 
+// ErrLeaseLifetimeExceeded is returned when a lease cannot be renewed since it
+// has reached the maximum lifetime allowed for the repository
+var ErrLeaseLifetimeExceeded = fmt.Errorf("lease_lifetime_exceeded")
+
 // Lease describes an exclusive lease to a subpath inside the repository:
 // keyID and token ()
 type Lease struct {
@@ -44,6 +49,8 @@ type Lease struct {
 	Expiration      time.Time
 	ProtocolVersion int
 	Hostname        string
+	Created         time.Time // zero for leases created before schema version 4
+	Statistics      stats.Statistics
 }
 
 func (l Lease) CombinedLeasePath() string {
@@ -54,8 +61,13 @@ func CreateLease(ctx context.Context, tx *sql.Tx, lease Lease) error {
 	t0 := time.Now()
 
 	res, err := tx.ExecContext(ctx,
-		"insert into Lease (Token, Repository, Path, KeyID, Expiration, ProtocolVersion, Hostname) values (?, ?, ?, ?, ?, ?, ?);",
-		lease.Token, lease.Repository, lease.Path, lease.KeyID, lease.Expiration.UnixMilli(), lease.ProtocolVersion, lease.Hostname)
+		`insert into Lease
+		(Token, Repository, Path, KeyID, Expiration, ProtocolVersion, Hostname, Created,
+		ChunksAdded, ChunksDuplicated, CatalogsAdded, UploadedBytes, UploadedCatalogBytes, StatisticsStartTime)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		lease.Token, lease.Repository, lease.Path, lease.KeyID, lease.Expiration.UnixMilli(), lease.ProtocolVersion, lease.Hostname, createdMilli(lease),
+		lease.Statistics.Publish.ChunksAdded, lease.Statistics.Publish.ChunksDuplicated, lease.Statistics.Publish.CatalogsAdded,
+		lease.Statistics.Publish.UploadedBytes, lease.Statistics.Publish.UploadedCatalogBytes, lease.Statistics.StartTime)
 	if err != nil {
 		return fmt.Errorf("could not insert new lease: %w", err)
 	}
@@ -125,6 +137,24 @@ func FindAllActiveLeases(ctx context.Context, tx *sql.Tx) ([]Lease, error) {
 	return leases, nil
 }
 
+func CountActiveLeasesByRepository(ctx context.Context, tx *sql.Tx, repository string) (int, error) {
+	t0 := time.Now()
+
+	var count int
+	if err := tx.QueryRowContext(ctx,
+		"select count(*) from Lease where Repository = ? and Expiration >= ?;",
+		repository, t0.UnixMilli()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("query failed: %w", err)
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "count_active_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repo: %v, count: %v", repository, count)
+
+	return count, nil
+}
+
 func FindAllLeasesByRepositoryAndOverlappingPath(ctx context.Context, tx *sql.Tx, repository, path string) ([]Lease, error) {
 	t0 := time.Now()
 
@@ -181,17 +211,76 @@ func FindLeaseByToken(ctx context.Context, tx *sql.Tx, token string) (*Lease, er
 	return &lease, nil
 }
 
-func DeleteAllExpiredLeases(ctx context.Context, tx *sql.Tx) error {
+func FindLeaseByRepositoryAndPath(ctx context.Context, tx *sql.Tx, repository, path string) (*Lease, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"select * from Lease where Repository = ? and Path = ?;", repository, path)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var lease Lease
+	if rows.Next() {
+		if err := scanLease(rows, &lease); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "find_by_repository_and_path").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("success")
+
+	return &lease, nil
+}
+
+func UpdateLeaseExpiration(ctx context.Context, tx *sql.Tx, token string, expiration time.Time) error {
 	t0 := time.Now()
 
-	res, err := tx.ExecContext(ctx, "delete from Lease where Expiration < ?", t0.UnixMilli())
+	res, err := tx.ExecContext(ctx, "update Lease set Expiration = ? where Token = ?", expiration.UnixMilli(), token)
+	if err != nil {
+		return fmt.Errorf("update statement failed: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates != 1 {
+		return fmt.Errorf("lease expiration not updated")
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "update_expiration").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("new expiration: %v", expiration)
+
+	return nil
+}
+
+// DeleteExpiredLeasesByRepository deletes the leases of a repository which
+// expired before the given time, except the leases with the given tokens
+func DeleteExpiredLeasesByRepository(ctx context.Context, tx *sql.Tx, repository string, before time.Time, keep []string) error {
+	t0 := time.Now()
+
+	query := "delete from Lease where Repository = ? and Expiration < ?"
+	args := []interface{}{repository, before.UnixMilli()}
+	if len(keep) > 0 {
+		query += " and Token not in (?" + strings.Repeat(", ?", len(keep)-1) + ")"
+		for _, token := range keep {
+			args = append(args, token)
+		}
+	}
+	res, err := tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("delete statement failed: %w", err)
 	}
 	numDeleted, _ := res.RowsAffected()
 
 	gw.LogC(ctx, "lease_entity", gw.LogDebug).
-		Str("operation", "delete_all_expired").
+		Str("operation", "delete_expired_by_repository").
 		Dur("task_dt", time.Since(t0)).
 		Msgf("deleted %v leases", numDeleted)
 
@@ -249,8 +338,41 @@ func DeleteLeaseByToken(ctx context.Context, tx *sql.Tx, token string) error {
 	return nil
 }
 
+// MergeLeaseStatistics adds the counters of other to the publish statistics
+// of the lease for the given repository and path
+func MergeLeaseStatistics(ctx context.Context, tx *sql.Tx, repository, path string, other *stats.Statistics) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		`update Lease set
+		ChunksAdded = ChunksAdded + ?,
+		ChunksDuplicated = ChunksDuplicated + ?,
+		CatalogsAdded = CatalogsAdded + ?,
+		UploadedBytes = UploadedBytes + ?,
+		UploadedCatalogBytes = UploadedCatalogBytes + ?
+		where Repository = ? and Path = ?;`,
+		other.Publish.ChunksAdded, other.Publish.ChunksDuplicated, other.Publish.CatalogsAdded,
+		other.Publish.UploadedBytes, other.Publish.UploadedCatalogBytes, repository, path)
+	if err != nil {
+		return fmt.Errorf("could not update lease statistics: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates == 0 {
+		return fmt.Errorf("lease not found")
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "merge_statistics").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repo: %v, path: %v", repository, path)
+
+	return nil
+}
+
 func scanLease(rows *sql.Rows, lease *Lease) error {
 	var expMilli int64
+	var createdMilli int64
 	if err := rows.Scan(
 		&lease.Token,
 		&lease.Repository,
@@ -258,11 +380,28 @@ func scanLease(rows *sql.Rows, lease *Lease) error {
 		&lease.KeyID,
 		&expMilli,
 		&lease.ProtocolVersion,
-		&lease.Hostname); err != nil {
+		&lease.Hostname,
+		&createdMilli,
+		&lease.Statistics.Publish.ChunksAdded,
+		&lease.Statistics.Publish.ChunksDuplicated,
+		&lease.Statistics.Publish.CatalogsAdded,
+		&lease.Statistics.Publish.UploadedBytes,
+		&lease.Statistics.Publish.UploadedCatalogBytes,
+		&lease.Statistics.StartTime); err != nil {
 		return err
 	}
 
 	lease.Expiration = time.UnixMilli(expMilli)
+	if createdMilli != 0 {
+		lease.Created = time.UnixMilli(createdMilli)
+	}
 
 	return nil
 }
+
+func createdMilli(lease Lease) int64 {
+	if lease.Created.IsZero() {
+		return 0
+	}
+	return lease.Created.UnixMilli()
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// leaseWaiter is a new lease request waiting in the LeaseQueue
+type leaseWaiter struct {
+	repository string
+	path       string
+	keyID      string
+	hostname   string
+	since      time.Time
+	// wake is signalled when a lease or a waiter in the same repository goes
+	// away, and the request should be retried
+	wake chan struct{}
+}
+
+func (w *leaseWaiter) leasePath() string {
+	return w.repository + "/" + strings.TrimPrefix(w.path, "/")
+}
+
+func (w *leaseWaiter) overlaps(repository, path string) bool {
+	return w.repository == repository && gw.CheckPathOverlap(w.path, path)
+}
+
+// LeaseQueue keeps the new lease requests which are waiting for a busy path
+// to be released, in arrival order. A request is only granted once all the
+// requests for overlapping paths which arrived before it have left the queue
+type LeaseQueue struct {
+	waiters []*leaseWaiter
+	lock    sync.Mutex
+}
+
+// NewLeaseQueue is a constructor function for the LeaseQueue type
+func NewLeaseQueue() *LeaseQueue {
+	return &LeaseQueue{waiters: make([]*leaseWaiter, 0)}
+}
+
+// enqueue adds a new waiter at the end of the queue
+func (q *LeaseQueue) enqueue(repository, path, keyID, hostname string) *leaseWaiter {
+	w := &leaseWaiter{
+		repository: repository,
+		path:       path,
+		keyID:      keyID,
+		hostname:   hostname,
+		since:      time.Now(),
+		wake:       make(chan struct{}, 1),
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.waiters = append(q.waiters, w)
+
+	return w
+}
+
+// remove a waiter from the queue, waking up the other waiters of the
+// repository
+func (q *LeaseQueue) remove(w *leaseWaiter) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, other := range q.waiters {
+		if other == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+	q.notifyLocked(w.repository)
+}
+
+// hasPrecedingOverlap returns true if a waiter for a path overlapping with the
+// given one is in the queue ahead of w. With w == nil, all the waiters are
+// considered to be ahead
+func (q *LeaseQueue) hasPrecedingOverlap(repository, path string, w *leaseWaiter) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, other := range q.waiters {
+		if other == w {
+			return false
+		}
+		if other.overlaps(repository, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes up all the waiters of a repository, signalling that a lease
+// has been released
+func (q *LeaseQueue) notify(repository string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.notifyLocked(repository)
+}
+
+func (q *LeaseQueue) notifyLocked(repository string) {
+	for _, w := range q.waiters {
+		if w.repository == repository {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// snapshot returns a copy of the queue, in arrival order
+func (q *LeaseQueue) snapshot() []leaseWaiter {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ret := make([]leaseWaiter, 0, len(q.waiters))
+	for _, w := range q.waiters {
+		ret = append(ret, *w)
+	}
+	return ret
+}
@@ -3,31 +3,76 @@ package backend
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
 )
 
-var leaseMutex sync.Mutex
-
 // LeaseDTO is the lease information returned to the HTTP frontend
 type LeaseDTO struct {
 	KeyID     string `json:"key_id,omitempty"`
 	LeasePath string `json:"path,omitempty"`
 	Expires   string `json:"expires,omitempty"`
 	Hostname  string `json:"hostname,omitempty"`
+	// ProtocolVersion is the API protocol version negotiated with the client
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+	// Statistics are the publish statistics collected so far, only returned
+	// for single leases
+	Statistics *stats.Statistics `json:"statistics,omitempty"`
+}
+
+// LeaseWaiterDTO is the information about a queued lease request returned to
+// the HTTP frontend
+type LeaseWaiterDTO struct {
+	KeyID        string `json:"key_id"`
+	LeasePath    string `json:"path"`
+	Hostname     string `json:"hostname,omitempty"`
+	WaitingSince string `json:"waiting_since"`
+	// BlockedBy lists the paths of the active leases and of the requests ahead
+	// in the queue which prevent this request from being granted
+	BlockedBy []string `json:"blocked_by"`
 }
 
 // NewLease for the specified path, using keyID
 func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int) (string, error) {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
+	token, err := s.newLease(ctx, keyID, leasePath, hostname, protocolVersion, nil)
+
+	outcome := "success"
+	if err != nil {
+		outcome = err.Error()
+	}
+	s.audit(ctx, AuditRecord{
+		Action: "new_lease", LeasePath: leasePath, KeyID: keyID, Hostname: hostname}, outcome)
+
+	return token, err
+}
 
+// busyPathRetryTime is the time after which a new lease request should be
+// retried, when the path is not blocked by an active lease but by requests
+// waiting in the lease queue or by a commit in progress
+const busyPathRetryTime = 1 * time.Second
+
+// maxLeaseWaitFraction is the fraction of the maximum lease time, which is also
+// the write timeout of the HTTP frontend, a request can wait in the lease
+// queue. The rest is left for sending the reply with the granted lease
+const maxLeaseWaitFraction = 0.5
+
+// WaitForLease requests a new lease for the specified path, using keyID. If
+// the path is busy, the request joins the lease queue and waits until the
+// conflicting leases are released and the requests ahead of it in the queue are
+// served, or until maxWait (capped at half the maximum lease time) has passed.
+// A PathBusyError is returned if the lease could not be obtained in time
+func (s *Services) WaitForLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int, maxWait time.Duration) (string, error) {
 	t0 := time.Now()
 
 	outcome := "success"
-	defer logAction(ctx, "new_lease", &outcome, t0)
+	defer logAction(ctx, "wait_for_lease", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{
+			Action: "new_lease", LeasePath: leasePath, KeyID: keyID, Hostname: hostname}, outcome)
+	}()
 
 	repo, path, err := gw.SplitLeasePath(leasePath)
 	if err != nil {
@@ -35,12 +80,124 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		return "", err
 	}
 
+	if limit := time.Duration(float64(s.Config.MaxLeaseTime) * maxLeaseWaitFraction); maxWait > limit {
+		maxWait = limit
+	}
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+
+	waiter := s.LeaseQueue.enqueue(repo, path, keyID, hostname)
+	defer s.LeaseQueue.remove(waiter)
+
+	for {
+		token, err := s.newLease(ctx, keyID, leasePath, hostname, protocolVersion, waiter)
+		busyError, busy := err.(PathBusyError)
+		if !busy {
+			if err != nil {
+				outcome = err.Error()
+			} else {
+				outcome = fmt.Sprintf("success: %v", token)
+			}
+			return token, err
+		}
+
+		if err := waitForRetry(ctx, waiter, busyError.Remaining(), deadline.C); err != nil {
+			if err == errWaitTimeout {
+				err = busyError
+			}
+			outcome = err.Error()
+			return "", err
+		}
+	}
+}
+
+var errWaitTimeout = fmt.Errorf("wait_timeout")
+
+// waitForRetry blocks until a queued lease request should be retried. When
+// blocked by an active lease (remaining > 0), the request is also retried once
+// the lease expires. When blocked by other requests in the queue, it waits for
+// them to leave
+func waitForRetry(ctx context.Context, waiter *leaseWaiter, remaining time.Duration, deadline <-chan time.Time) error {
+	var expired <-chan time.Time
+	if remaining > 0 {
+		expiry := time.NewTimer(remaining)
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	select {
+	case <-waiter.wake:
+	case <-expired:
+	case <-deadline:
+		return errWaitTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// GetLeaseQueue returns the queued lease requests, in arrival order
+func (s *Services) GetLeaseQueue(ctx context.Context) ([]LeaseWaiterDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_lease_queue", &outcome, t0)
+
 	tx, err := s.DB.SQL.BeginTx(ctx, nil)
 	if err != nil {
-		return "", fmt.Errorf("could not begin transaction: %w", err)
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	leases, err := FindAllActiveLeases(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	waiters := s.LeaseQueue.snapshot()
+	ret := make([]LeaseWaiterDTO, 0, len(waiters))
+	for i, w := range waiters {
+		blockedBy := make([]string, 0)
+		for _, l := range leases {
+			if w.overlaps(l.Repository, l.Path) {
+				blockedBy = append(blockedBy, l.CombinedLeasePath())
+			}
+		}
+		for _, other := range waiters[:i] {
+			if w.overlaps(other.repository, other.path) {
+				blockedBy = append(blockedBy, other.leasePath())
+			}
+		}
+		ret = append(ret, LeaseWaiterDTO{
+			KeyID:        w.keyID,
+			LeasePath:    w.leasePath(),
+			Hostname:     w.hostname,
+			WaitingSince: w.since.String(),
+			BlockedBy:    blockedBy,
+		})
+	}
+
+	return ret, nil
+}
+
+func (s *Services) newLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int, waiter *leaseWaiter) (string, error) {
+
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "new_lease", &outcome, t0)
+
+	repo, path, err := gw.SplitLeasePath(leasePath)
+	if err != nil {
+		outcome = err.Error()
+		return "", err
+	}
+
 	repoConfig, err := s.GetRepo(ctx, repo)
 	if err != nil {
 		return "", fmt.Errorf("could not retrieve repository information: %w", err)
@@ -52,6 +209,16 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		return "", ErrRepoDisabled
 	}
 
+	// The key is checked and the overlapping leases are looked up in the same
+	// write transaction which creates the lease, so concurrent requests for
+	// the lease path, and access configuration reloads, are serialized by the
+	// database
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Check if keyID is allowed to request a lease in the repository
 	// at the specified subpath
 	if err := s.Access.Check(keyID, path, repo); err != nil {
@@ -73,21 +240,43 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		}
 	}
 
-	// Delete expired leases
-	if err := DeleteAllExpiredLeases(ctx, tx); err != nil {
+	// The lease of a commit in progress is kept until the commit has finished,
+	// even if it has expired
+	if s.LeaseCommits.overlaps(repo, path) {
+		err := PathBusyError{busyPathRetryTime}
+		outcome = err.Error()
+		return "", err
+	}
+
+	// Requests waiting in the lease queue for an overlapping path are served first
+	if s.LeaseQueue.hasPrecedingOverlap(repo, path, waiter) {
+		err := PathBusyError{busyPathRetryTime}
+		outcome = err.Error()
+		return "", err
+	}
+
+	// Delete the expired leases of the repository, except those whose commit
+	// is in progress. A commit starting after the lookup of the commits in
+	// progress finds its lease expired before the purge time, and fails
+	purgeTime := time.Now()
+	if err := DeleteExpiredLeasesByRepository(
+		ctx, tx, repo, purgeTime, s.LeaseCommits.tokens(repo)); err != nil {
 		outcome = err.Error()
 		return "", err
 	}
 
 	// Generate a new token for the lease
+	now := time.Now()
 	lease := Lease{
 		Token:           NewLeaseToken(),
 		Repository:      repo,
 		Path:            path,
 		KeyID:           keyID,
-		Expiration:      time.Now().Add(s.Config.MaxLeaseTime),
+		Expiration:      s.leaseExpiration(repoConfig, keyID, now, now),
 		ProtocolVersion: protocolVersion,
 		Hostname:        hostname,
+		Created:         now,
+		Statistics:      stats.NewStatistics(),
 	}
 
 	if err := CreateLease(ctx, tx, lease); err != nil {
@@ -95,19 +284,6 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		return "", err
 	}
 
-	// the StatsMgr does not handle the case in which a lease expires.
-	// However, if a lease expires, we should not upload it's statistics.
-	// If the LeaseMgr successfully create a new lease,
-	// then, the lease path must be free.
-	// We remove it, no matter what.
-	// We don't check the error because it return an error if the lease does not exist, the standard case.
-	s.StatsMgr.PopLease(lease.CombinedLeasePath())
-
-	if err := s.StatsMgr.CreateLease(lease.CombinedLeasePath()); err != nil {
-		outcome = err.Error()
-		return "", err
-	}
-
 	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("could not commit transaction: %w", err)
 	}
@@ -118,8 +294,6 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 
 // GetLeases returns all active and valid leases
 func (s *Services) GetLeases(ctx context.Context) (map[string]LeaseDTO, error) {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
 	t0 := time.Now()
 
 	outcome := "success"
@@ -139,7 +313,13 @@ func (s *Services) GetLeases(ctx context.Context) (map[string]LeaseDTO, error) {
 	ret := make(map[string]LeaseDTO)
 	for _, l := range leases {
 		leasePath := l.Repository + l.Path
-		ret[leasePath] = LeaseDTO{KeyID: l.KeyID, LeasePath: leasePath, Expires: l.Expiration.String(), Hostname: l.Hostname}
+		ret[leasePath] = LeaseDTO{
+			KeyID:           l.KeyID,
+			LeasePath:       leasePath,
+			Expires:         l.Expiration.String(),
+			Hostname:        l.Hostname,
+			ProtocolVersion: l.ProtocolVersion,
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -151,8 +331,6 @@ func (s *Services) GetLeases(ctx context.Context) (map[string]LeaseDTO, error) {
 
 // GetLease returns the lease associated with a token
 func (s *Services) GetLease(ctx context.Context, token string) (*LeaseDTO, error) {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
 	t0 := time.Now()
 
 	outcome := "success"
@@ -181,24 +359,96 @@ func (s *Services) GetLease(ctx context.Context, token string) (*LeaseDTO, error
 	}
 
 	ret := &LeaseDTO{
-		KeyID:     lease.KeyID,
-		LeasePath: lease.CombinedLeasePath(),
-		Expires:   lease.Expiration.String(),
-		Hostname:  lease.Hostname,
+		KeyID:           lease.KeyID,
+		LeasePath:       lease.CombinedLeasePath(),
+		Expires:         lease.Expiration.String(),
+		Hostname:        lease.Hostname,
+		ProtocolVersion: lease.ProtocolVersion,
+		Statistics:      &lease.Statistics,
 	}
 	return ret, nil
 }
 
+// RenewLease extends the expiration of a valid lease by the lease time of
+// the key, without exceeding the maximum lease lifetime of the key in the
+// repository. Returns the new expiration time
+func (s *Services) RenewLease(ctx context.Context, token string) (time.Time, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "renew_lease", &outcome, t0)
+
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lease, err := FindLeaseByToken(ctx, tx, token)
+	if err != nil {
+		outcome = err.Error()
+		return time.Time{}, err
+	}
+
+	if lease == nil || lease.Expiration.Before(time.Now()) {
+		err := InvalidLeaseError{}
+		outcome = err.Error()
+		return time.Time{}, err
+	}
+
+	repoConfig := s.Access.GetRepo(lease.Repository)
+	if repoConfig == nil {
+		repoConfig = &RepositoryConfig{}
+	}
+	created := lease.Created
+	if created.IsZero() {
+		// Leases created before the creation time was recorded
+		created = lease.Expiration.Add(-s.Config.MaxLeaseTime)
+	}
+	expiration := s.leaseExpiration(repoConfig, lease.KeyID, created, time.Now())
+
+	if !expiration.After(lease.Expiration) {
+		err := ErrLeaseLifetimeExceeded
+		outcome = err.Error()
+		return time.Time{}, err
+	}
+
+	if err := UpdateLeaseExpiration(ctx, tx, token, expiration); err != nil {
+		outcome = err.Error()
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	outcome = fmt.Sprintf("success: %v", expiration)
+	return expiration, nil
+}
+
+// leaseExpiration returns the expiration of a lease created at the given time,
+// when it is granted or renewed at now: the default lease time of the key in
+// the repository from now, capped at the maximum lease lifetime from creation
+func (s *Services) leaseExpiration(repoConfig *RepositoryConfig, keyID string, created, now time.Time) time.Time {
+	_, leaseTime := repoConfig.LeaseTime(keyID, s.Config.MaxLeaseTime)
+	expiration := now.Add(leaseTime)
+	if ceiling := created.Add(repoConfig.LeaseLifetime(keyID, s.Config.MaxLeaseTime)); expiration.After(ceiling) {
+		expiration = ceiling
+	}
+	return expiration
+}
+
 // CancelLeases cancels all the active leases below a repository path
 func (s *Services) CancelLeases(ctx context.Context, repoPath string) error {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "cancel_leases", &outcome, t0)
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: "cancel_leases", LeasePath: repoPath}, outcome)
+	}()
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginWriteTx(ctx)
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
@@ -219,19 +469,23 @@ func (s *Services) CancelLeases(ctx context.Context, repoPath string) error {
 		return fmt.Errorf("could not commit transaction: %w", err)
 	}
 
+	s.LeaseQueue.notify(repo)
+
 	return nil
 }
 
 // CancelLease associated with the token
 func (s *Services) CancelLease(ctx context.Context, token string) error {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "cancel_lease", &outcome, t0)
+	rec := AuditRecord{Action: "cancel_lease"}
+	defer func() {
+		s.audit(ctx, rec, outcome)
+	}()
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginWriteTx(ctx)
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
@@ -248,76 +502,188 @@ func (s *Services) CancelLease(ctx context.Context, token string) error {
 		outcome = err.Error()
 		return err
 	}
+	rec.LeasePath = lease.CombinedLeasePath()
+	rec.Hostname = lease.Hostname
+
+	if s.LeaseCommits.inProgress(token) {
+		outcome = ErrCommitInProgress.Error()
+		return ErrCommitInProgress
+	}
 
 	if err := DeleteLeaseByToken(ctx, tx, token); err != nil {
 		outcome = err.Error()
 		return err
 	}
 
-	// We don't check the error - if the statistics are missing, the lease
-	// should still be cancelable
-	s.StatsMgr.PopLease(lease.CombinedLeasePath());
-
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("could not commit transaction: %w", err)
 	}
 
+	s.LeaseQueue.notify(lease.Repository)
+
 	return nil
 }
 
-// CommitLease associated with the token (transaction commit)
+// CommitLease associated with the token (transaction commit). The commit is
+// run by the receiver while holding the commit lock of the repository, but
+// outside of any database transaction, so commits and lease operations on
+// other repositories are not blocked by it. Until the commit has finished, the
+// lease is marked as being committed, so it can't be committed again or
+// cancelled, and its path can't be leased again
 func (s *Services) CommitLease(ctx context.Context, token, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
-	leaseMutex.Lock()
-	defer leaseMutex.Unlock()
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "commit_lease", &outcome, t0)
+	rec := AuditRecord{Action: "commit_lease", Tag: tag}
+	defer func() {
+		s.audit(ctx, rec, outcome)
+	}()
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	lease, err := FindLeaseByToken(ctx, tx, token)
+	lease, err := s.findValidLease(ctx, token)
 	if err != nil {
 		outcome = err.Error()
 		return 0, err
 	}
-
-	if lease == nil || lease.Expiration.Before(time.Now()) {
-		err := InvalidLeaseError{}
+	if s.LeaseCommits.inProgress(token) {
+		outcome = ErrCommitInProgress.Error()
+		return 0, ErrCommitInProgress
+	}
+	rec.LeasePath = lease.CombinedLeasePath()
+	rec.KeyID = lease.KeyID
+	rec.Hostname = lease.Hostname
+	rec.Statistics = &lease.Statistics
+
+	event := HookEvent{
+		Time:           time.Now().Format(time.RFC3339Nano),
+		Repository:     lease.Repository,
+		LeasePath:      lease.CombinedLeasePath(),
+		OldRootHash:    oldRootHash,
+		NewRootHash:    newRootHash,
+		TagName:        tag.Name,
+		TagDescription: tag.Description,
+		KeyID:          lease.KeyID,
+		Hostname:       lease.Hostname,
+		Statistics:     &lease.Statistics,
+	}
+	if err := s.Hooks.runPreCommit(ctx, event); err != nil {
 		outcome = err.Error()
 		return 0, err
 	}
 
 	var finalRev uint64
+	var commitTime time.Time
+	var commitDuration time.Duration
+	committing := false
+	defer func() {
+		if committing {
+			s.LeaseCommits.end(token)
+		}
+	}()
 	if err := s.DB.WithLock(ctx, lease.Repository, func() error {
+		if !s.LeaseCommits.begin(*lease) {
+			return ErrCommitInProgress
+		}
+		committing = true
+
+		// The lease may have been committed, cancelled or replaced while
+		// waiting for the lock. A lease cancelled from now on is refused, so
+		// it is checked again once marked as being committed
+		if _, err := s.findValidLease(ctx, token); err != nil {
+			return err
+		}
+
+		metrics.CommitsInProgress.WithLabelValues(lease.Repository).Inc()
+		defer metrics.CommitsInProgress.WithLabelValues(lease.Repository).Dec()
+
 		var err error
+		commitTime = time.Now()
 		leasePath := lease.CombinedLeasePath()
 		finalRev, err = s.Pool.CommitLease(ctx, leasePath, oldRootHash, newRootHash, tag)
+		commitDuration = time.Since(commitTime)
 		return err
 	}); err != nil {
 		outcome = err.Error()
 		return 0, err
 	}
+	rec.FinalRevision = finalRev
+
+	// The lease is released and the commit is added to the history in the
+	// same transaction, so the history has no gaps. The revision is
+	// published, so it is returned even if that fails
+	if err := s.releaseCommittedLease(ctx, token, CommitRecord{
+		Repository:  lease.Repository,
+		Revision:    finalRev,
+		OldRootHash: oldRootHash,
+		NewRootHash: newRootHash,
+		Tag:         tag,
+		KeyID:       lease.KeyID,
+		Hostname:    lease.Hostname,
+		LeasePath:   lease.CombinedLeasePath(),
+		Time:        commitTime,
+		Duration:    commitDuration,
+		Statistics:  rec.Statistics,
+	}); err != nil {
+		outcome = err.Error()
+		return finalRev, err
+	}
 
-	go func() {
-		plotsErr := s.StatsMgr.UploadStatsPlots(lease.Repository)
-		if plotsErr != nil {
-			gw.LogC(ctx, "actions", gw.LogError).Msgf(plotsErr.Error())
-		}
-	}()
+	s.LeaseQueue.notify(lease.Repository)
+
+	event.Time = commitTime.Format(time.RFC3339Nano)
+	event.Revision = finalRev
+	s.Hooks.runPostCommit(ctx, event)
+
+	return finalRev, nil
+}
+
+// releaseCommittedLease deletes the lease associated with a token and adds
+// its commit to the commit history. The revision is published, so this is
+// done even if the request has been cancelled
+func (s *Services) releaseCommittedLease(ctx context.Context, token string, commit CommitRecord) error {
+	ctx = context.Background()
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
 	if err := DeleteLeaseByToken(ctx, tx, token); err != nil {
-		outcome = err.Error()
-		return finalRev, err
+		return err
+	}
+
+	if err := CreateCommitRecord(ctx, tx, commit); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("could not commit transaction: %w", err)
+		return fmt.Errorf("could not commit transaction: %w", err)
 	}
 
-	return finalRev, nil
+	return nil
+}
+
+// findValidLease returns the lease associated with a token, or an
+// InvalidLeaseError if there is no such lease or if it has expired
+func (s *Services) findValidLease(ctx context.Context, token string) (*Lease, error) {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lease, err := FindLeaseByToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if lease == nil || lease.Expiration.Before(time.Now()) {
+		return nil, InvalidLeaseError{}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return lease, nil
 }
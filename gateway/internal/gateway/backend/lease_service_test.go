@@ -2,13 +2,18 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
 )
 
 func TestLeaseServiceNewLease(t *testing.T) {
@@ -63,6 +68,36 @@ func TestLeaseServiceNewLease(t *testing.T) {
 			t.Fatalf("new lease should not have been granted for conflicting path")
 		}
 	})
+	t.Run("new lease repository limits", func(t *testing.T) {
+		repoName := "test2.repo.org"
+		rc := backend.Access.Repositories[repoName]
+		rc.DefaultLeaseTime = 60
+		backend.Access.Repositories[repoName] = rc
+		defer func() {
+			rc.DefaultLeaseTime = 0
+			backend.Access.Repositories[repoName] = rc
+		}()
+
+		backend.Config.MaxLeaseTime = 1 * time.Hour
+		keyID := "keyid1"
+		leasePath := "test2.repo.org/some/path"
+		token, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token)
+		leases, err := backend.GetLeases(context.TODO())
+		if err != nil {
+			t.Fatalf("could not query leases: %v", err)
+		}
+		expires, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", leases[leasePath].Expires)
+		if err != nil {
+			t.Fatalf("could not parse lease expiration: %v", err)
+		}
+		if time.Until(expires) > 1*time.Minute {
+			t.Fatalf("repository default lease time was not applied: %v", expires)
+		}
+	})
 	t.Run("new lease invalid key", func(t *testing.T) {
 		backend.Config.MaxLeaseTime = 1 * time.Second
 		keyID := "keyidNO"
@@ -222,6 +257,29 @@ func TestLeaseServiceGetLease(t *testing.T) {
 			t.Fatalf("query should have returned an InvalidLeaseError. Instead: %v", err)
 		}
 	})
+	t.Run("get lease during a write transaction", func(t *testing.T) {
+		backend.Config.MaxLeaseTime = 1 * time.Second
+		token1, err := backend.NewLease(context.TODO(), "keyid1", "test2.repo.org/other/path", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token1)
+
+		tx, err := backend.DB.BeginWriteTx(context.TODO())
+		if err != nil {
+			t.Fatalf("could not begin write transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		// Read-only queries don't wait for the write lock
+		t0 := time.Now()
+		if _, err := backend.GetLease(context.TODO(), token1); err != nil {
+			t.Fatalf("could not query existing lease: %v", err)
+		}
+		if dt := time.Since(t0); dt >= 1*time.Second {
+			t.Fatalf("query waited for the write transaction: %v", dt)
+		}
+	})
 }
 
 func TestLeaseServiceCommitLease(t *testing.T) {
@@ -281,3 +339,495 @@ func TestLeaseServiceCommitLease(t *testing.T) {
 		}
 	})
 }
+
+func TestLeaseServiceRenewLease(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_actions_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	t.Run("renew valid lease", func(t *testing.T) {
+		backend.Config.MaxLeaseTime = 1 * time.Second
+		keyID := "keyid1"
+		leasePath := "test2.repo.org/some/path"
+		token, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token)
+		backend.Config.MaxLeaseTime = 1 * time.Hour
+		expiration, err := backend.RenewLease(context.TODO(), token)
+		if err != nil {
+			t.Fatalf("could not renew lease: %v", err)
+		}
+		if time.Until(expiration) < 59*time.Minute {
+			t.Fatalf("lease was not extended: %v", expiration)
+		}
+	})
+	t.Run("renew expired lease", func(t *testing.T) {
+		backend.Config.MaxLeaseTime = 1 * time.Millisecond
+		keyID := "keyid1"
+		leasePath := "test2.repo.org/some/path"
+		token, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		time.Sleep(2 * backend.Config.MaxLeaseTime)
+		if _, err := backend.RenewLease(context.TODO(), token); !errors.As(err, &InvalidLeaseError{}) {
+			t.Fatalf("renewal should have returned an InvalidLeaseError. Instead: %v", err)
+		}
+	})
+	t.Run("renew beyond maximum lifetime", func(t *testing.T) {
+		repoName := "test2.repo.org"
+		rc := backend.Access.Repositories[repoName]
+		rc.MaxLeaseLifetime = 1
+		backend.Access.Repositories[repoName] = rc
+		defer func() {
+			rc.MaxLeaseLifetime = 0
+			backend.Access.Repositories[repoName] = rc
+		}()
+
+		backend.Config.MaxLeaseTime = 1 * time.Hour
+		keyID := "keyid1"
+		leasePath := "test2.repo.org/some/path"
+		token, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token)
+		if _, err := backend.RenewLease(context.TODO(), token); !errors.Is(err, ErrLeaseLifetimeExceeded) {
+			t.Fatalf("renewal should have been refused. Instead: %v", err)
+		}
+	})
+}
+
+func TestLeaseServiceRenewLeaseRepositoryLimits(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_actions_test", 2*time.Hour)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(accessConfigV2LeaseLimits), mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	backend.Access.Swap(ac)
+	if err := backend.ReconcileRepositories(context.TODO()); err != nil {
+		t.Fatalf("could not reconcile repositories: %v", err)
+	}
+
+	token, err := backend.NewLease(context.TODO(), "keyid1", "test.repo.org/some/path", "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	lease, err := backend.GetLease(context.TODO(), token)
+	if err != nil {
+		t.Fatalf("could not query lease: %v", err)
+	}
+	created := time.Now()
+
+	// Renewals extend the lease by the default lease time of the repository,
+	// until the maximum lease time from its creation
+	expiration, err := backend.RenewLease(context.TODO(), token)
+	if err != nil {
+		t.Fatalf("could not renew lease: %v", err)
+	}
+	if expiration.After(created.Add(10 * time.Minute)) {
+		t.Fatalf("lease renewed beyond the maximum lease time: %v (was %v)", expiration, lease.Expires)
+	}
+	tx, err := backend.DB.SQL.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("could not begin transaction: %v", err)
+	}
+	if err := UpdateLeaseExpiration(context.TODO(), tx, token, created.Add(10*time.Minute)); err != nil {
+		t.Fatalf("could not update lease: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("could not commit transaction: %v", err)
+	}
+	if _, err := backend.RenewLease(context.TODO(), token); !errors.Is(err, ErrLeaseLifetimeExceeded) {
+		t.Fatalf("renewal beyond the maximum lease time should have been refused. Instead: %v", err)
+	}
+}
+
+func TestLeaseServiceWaitForLease(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_actions_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	keyID := "keyid1"
+	leasePath := "test2.repo.org/some/path"
+
+	t.Run("wait granted after cancel", func(t *testing.T) {
+		token1, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			backend.CancelLease(context.TODO(), token1)
+		}()
+		token2, err := backend.WaitForLease(
+			context.TODO(), keyID, leasePath+"/below", "host", lastProtocolVersion, 5*time.Second)
+		if err != nil {
+			t.Fatalf("could not obtain new lease after waiting: %v", err)
+		}
+		backend.CancelLease(context.TODO(), token2)
+	})
+	t.Run("wait timeout", func(t *testing.T) {
+		token1, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token1)
+		_, err = backend.WaitForLease(
+			context.TODO(), keyID, leasePath, "host", lastProtocolVersion, 100*time.Millisecond)
+		if _, ok := err.(PathBusyError); !ok {
+			t.Fatalf("expected a path busy error, got: %v", err)
+		}
+		queue, err := backend.GetLeaseQueue(context.TODO())
+		if err != nil {
+			t.Fatalf("could not get lease queue: %v", err)
+		}
+		if len(queue) != 0 {
+			t.Fatalf("lease queue should be empty after timeout: %v", queue)
+		}
+	})
+	t.Run("queue order", func(t *testing.T) {
+		token1, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+
+		granted := make(chan string, 2)
+		wait := func(hostname string) {
+			token, err := backend.WaitForLease(
+				context.TODO(), keyID, leasePath, hostname, lastProtocolVersion, 5*time.Second)
+			if err != nil {
+				granted <- "error: " + err.Error()
+				return
+			}
+			granted <- hostname
+			time.Sleep(100 * time.Millisecond)
+			backend.CancelLease(context.TODO(), token)
+		}
+		go wait("first")
+		time.Sleep(50 * time.Millisecond)
+		go wait("second")
+		time.Sleep(50 * time.Millisecond)
+
+		queue, err := backend.GetLeaseQueue(context.TODO())
+		if err != nil {
+			t.Fatalf("could not get lease queue: %v", err)
+		}
+		if len(queue) != 2 || queue[0].Hostname != "first" || queue[1].Hostname != "second" {
+			t.Fatalf("invalid lease queue: %v", queue)
+		}
+		if len(queue[1].BlockedBy) != 2 {
+			t.Fatalf("second request should be blocked by the lease and the first request: %v",
+				queue[1].BlockedBy)
+		}
+
+		// A new request for the path must not jump the queue
+		if _, err := backend.NewLease(
+			context.TODO(), keyID, leasePath, "host", lastProtocolVersion); err == nil {
+			t.Fatalf("new lease should not have been granted ahead of the queue")
+		}
+
+		backend.CancelLease(context.TODO(), token1)
+		for _, expected := range []string{"first", "second"} {
+			if got := <-granted; got != expected {
+				t.Fatalf("expected lease to be granted to %v, got %v", expected, got)
+			}
+		}
+	})
+	t.Run("blocked by queue", func(t *testing.T) {
+		waiter := backend.LeaseQueue.enqueue("test2.repo.org", "/some/path", keyID, "queued")
+		defer backend.LeaseQueue.remove(waiter)
+
+		_, err := backend.NewLease(context.TODO(), keyID, leasePath, "host", lastProtocolVersion)
+		busyError, ok := err.(PathBusyError)
+		if !ok {
+			t.Fatalf("expected a path busy error, got: %v", err)
+		}
+		if busyError.Remaining() <= 0 {
+			t.Fatalf("request blocked by the lease queue should get a retry time")
+		}
+	})
+}
+
+func TestLeaseServiceWaitForLeaseLimit(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_actions_test", 200*time.Millisecond)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	waiter := backend.LeaseQueue.enqueue("test2.repo.org", "/some/path", "keyid1", "queued")
+	defer backend.LeaseQueue.remove(waiter)
+
+	// The wait is capped below the maximum lease time, which is the write
+	// timeout of the frontend
+	t0 := time.Now()
+	_, err := backend.WaitForLease(
+		context.TODO(), "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion, 10*time.Second)
+	if _, ok := err.(PathBusyError); !ok {
+		t.Fatalf("expected a path busy error, got: %v", err)
+	}
+	if waited := time.Since(t0); waited >= 200*time.Millisecond {
+		t.Fatalf("wait was not capped below the maximum lease time: %v", waited)
+	}
+}
+
+func TestLeaseServiceConcurrentNewLease(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("concurrent_lease_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	const numRequests = 8
+	var wg sync.WaitGroup
+	tokens := make(chan string, numRequests)
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := backend.NewLease(
+				context.TODO(), "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+			if err == nil {
+				tokens <- token
+			} else if _, busy := err.(PathBusyError); !busy {
+				t.Errorf("unexpected new lease error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	if len(tokens) != 1 {
+		t.Fatalf("expected a single lease to be granted, got %v", len(tokens))
+	}
+}
+
+// accessConfigBenchmark is an access configuration with several repositories
+const accessConfigBenchmark = `
+{
+	"version": 2,
+	"repos" : [
+		{"domain": "bench0.repo.org", "keys": [{"id": "keyid2", "path": "/"}]},
+		{"domain": "bench1.repo.org", "keys": [{"id": "keyid2", "path": "/"}]},
+		{"domain": "bench2.repo.org", "keys": [{"id": "keyid2", "path": "/"}]},
+		{"domain": "bench3.repo.org", "keys": [{"id": "keyid2", "path": "/"}]}
+	],
+	"keys": [{"type": "plain_text", "id": "keyid2", "secret": "secret2"}]
+}
+`
+
+// startConcurrentCommitBackend starts a test backend with a receiver worker
+// for each of numCommits concurrent commits
+func startConcurrentCommitBackend(tb testing.TB, numCommits int) (*Services, string) {
+	backend, tmp := StartTestBackend("lease_benchmark", 1*time.Minute)
+
+	backend.Pool.Stop()
+	pool, err := receiver.StartPool("", numCommits, true, backend.StatsMgr, receiver.PoolOptions{})
+	if err != nil {
+		tb.Fatalf("could not start receiver pool: %v", err)
+	}
+	backend.Pool = pool
+
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(accessConfigBenchmark), mockKeyImporter); err != nil {
+		tb.Fatalf("could not load access config: %v", err)
+	}
+	if _, err := backend.swapAccessConfig(context.TODO(), ac); err != nil {
+		tb.Fatalf("could not swap access config: %v", err)
+	}
+
+	return backend, tmp
+}
+
+// commitConcurrently obtains a lease and commits it for each lease path, in
+// parallel. Returns the time taken by the slowest commit
+func commitConcurrently(tb testing.TB, backend *Services, leasePaths []string) time.Duration {
+	lastProtocolVersion := 3
+	t0 := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(leasePaths))
+	for _, leasePath := range leasePaths {
+		wg.Add(1)
+		go func(leasePath string) {
+			defer wg.Done()
+			token, err := backend.NewLease(
+				context.TODO(), "keyid2", leasePath, "host", lastProtocolVersion)
+			if err != nil {
+				errs <- fmt.Errorf("could not obtain new lease: %w", err)
+				return
+			}
+			if _, err := backend.CommitLease(
+				context.TODO(), token, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+				errs <- fmt.Errorf("could not commit lease: %w", err)
+			}
+		}(leasePath)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		tb.Fatal(err)
+	}
+	return time.Since(t0)
+}
+
+// concurrentCommitPaths returns numCommits lease paths, either on disjoint
+// paths of a single repository or on independent repositories
+func concurrentCommitPaths(numCommits int, independent bool) []string {
+	paths := make([]string, 0, numCommits)
+	for i := 0; i < numCommits; i++ {
+		if independent {
+			paths = append(paths, fmt.Sprintf("bench%v.repo.org/path", i))
+		} else {
+			paths = append(paths, fmt.Sprintf("bench0.repo.org/path%v", i))
+		}
+	}
+	return paths
+}
+
+func TestLeaseServiceConcurrentCommits(t *testing.T) {
+	const numCommits = 4
+	const commitLatency = 100 * time.Millisecond
+
+	receiver.MockCommitLatency = commitLatency
+	defer func() {
+		receiver.MockCommitLatency = 0
+	}()
+
+	backend, tmp := startConcurrentCommitBackend(t, numCommits)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	t.Run("same repository", func(t *testing.T) {
+		if dt := commitConcurrently(t, backend, concurrentCommitPaths(numCommits, false)); dt < numCommits*commitLatency {
+			t.Fatalf("commits on the same repository were not serialized: %v", dt)
+		}
+	})
+	t.Run("independent repositories", func(t *testing.T) {
+		if dt := commitConcurrently(t, backend, concurrentCommitPaths(numCommits, true)); dt >= 2*commitLatency {
+			t.Fatalf("commits on independent repositories were serialized: %v", dt)
+		}
+	})
+}
+
+// BenchmarkLeaseServiceConcurrentCommits measures a round of concurrent lease
+// and commit requests, on disjoint paths of a single repository and on
+// independent repositories. Commits are only serialized per repository, so a
+// round on independent repositories takes about as long as a single commit
+func BenchmarkLeaseServiceConcurrentCommits(b *testing.B) {
+	const numCommits = 4
+	const commitLatency = 20 * time.Millisecond
+
+	receiver.MockCommitLatency = commitLatency
+	defer func() {
+		receiver.MockCommitLatency = 0
+	}()
+
+	backend, tmp := startConcurrentCommitBackend(b, numCommits)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	b.Run("same repository", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			commitConcurrently(b, backend, concurrentCommitPaths(numCommits, false))
+		}
+	})
+	b.Run("independent repositories", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if dt := commitConcurrently(b, backend, concurrentCommitPaths(numCommits, true)); dt >= numCommits*commitLatency {
+				b.Fatalf("commits on independent repositories were serialized: %v", dt)
+			}
+		}
+	})
+}
+
+func TestLeaseServiceCommitInProgress(t *testing.T) {
+	lastProtocolVersion := 3
+	receiver.MockCommitLatency = 200 * time.Millisecond
+	defer func() {
+		receiver.MockCommitLatency = 0
+	}()
+
+	backend, tmp := StartTestBackend("lease_commit_test", 100*time.Millisecond)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	leasePath := "test2.repo.org/some/path"
+	token, err := backend.NewLease(ctx, "keyid1", leasePath, "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+
+	committed := make(chan error, 1)
+	go func() {
+		_, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{})
+		committed <- err
+	}()
+	for !backend.LeaseCommits.inProgress(token) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{}); err != ErrCommitInProgress {
+		t.Fatalf("lease being committed should not be committed again: %v", err)
+	}
+	if err := backend.CancelLease(ctx, token); err != ErrCommitInProgress {
+		t.Fatalf("lease being committed should not be cancelled: %v", err)
+	}
+	// The lease expires during the commit, its path stays busy
+	time.Sleep(150 * time.Millisecond)
+	if _, err := backend.NewLease(ctx, "keyid1", leasePath, "host", lastProtocolVersion); err == nil {
+		t.Fatalf("new lease granted during the commit of an overlapping lease")
+	}
+	// Expired leases are purged when granting a lease in the repository,
+	// except the lease being committed
+	otherToken, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/other/path", "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease on a disjoint path: %v", err)
+	}
+	backend.CancelLease(ctx, otherToken)
+	withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+		lease, err := FindLeaseByToken(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+		if lease == nil {
+			return fmt.Errorf("lease being committed was purged")
+		}
+		return nil
+	})
+
+	if err := <-committed; err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+	if backend.LeaseCommits.inProgress(token) {
+		t.Fatalf("commit still marked as in progress")
+	}
+	newToken, err := backend.NewLease(ctx, "keyid1", leasePath, "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease after the commit: %v", err)
+	}
+	backend.CancelLease(ctx, newToken)
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// leaseStatisticsStore keeps the publish statistics of the leases in their
+// rows of the Lease table, so that they survive a restart of the gateway and
+// are deleted together with the lease
+type leaseStatisticsStore struct {
+	db *DB
+}
+
+func (st leaseStatisticsStore) GetLeaseStatistics(leasePath string) (stats.Statistics, error) {
+	repository, path, err := gw.SplitLeasePath(leasePath)
+	if err != nil {
+		return stats.Statistics{}, err
+	}
+
+	ctx := context.Background()
+	tx, err := st.db.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return stats.Statistics{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lease, err := FindLeaseByRepositoryAndPath(ctx, tx, repository, path)
+	if err != nil {
+		return stats.Statistics{}, err
+	}
+	if lease == nil {
+		return stats.Statistics{}, fmt.Errorf("lease not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats.Statistics{}, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return lease.Statistics, nil
+}
+
+func (st leaseStatisticsStore) MergeLeaseStatistics(leasePath string, other *stats.Statistics) error {
+	repository, path, err := gw.SplitLeasePath(leasePath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := st.db.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := MergeLeaseStatistics(ctx, tx, repository, path, other); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
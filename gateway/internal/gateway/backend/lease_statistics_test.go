@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+func TestLeaseStatisticsPersistence(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_statistics_test", 1*time.Minute)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	leasePath := "test2.repo.org/some/path"
+	token, err := backend.NewLease(ctx, "keyid1", leasePath, "host1", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+
+	delta := stats.Statistics{Publish: stats.PublishCounters{
+		ChunksAdded: 2, ChunksDuplicated: 1, CatalogsAdded: 1, UploadedBytes: 100, UploadedCatalogBytes: 10,
+	}}
+	for i := 0; i < 2; i++ {
+		if err := backend.StatsMgr.MergeIntoLeaseStatistics(leasePath, &delta); err != nil {
+			t.Fatalf("could not merge statistics: %v", err)
+		}
+	}
+
+	expected := stats.PublishCounters{
+		ChunksAdded: 4, ChunksDuplicated: 2, CatalogsAdded: 2, UploadedBytes: 200, UploadedCatalogBytes: 20,
+	}
+	t.Run("get lease", func(t *testing.T) {
+		lease, err := backend.GetLease(ctx, token)
+		if err != nil {
+			t.Fatalf("could not get lease: %v", err)
+		}
+		if lease.Statistics == nil || lease.Statistics.Publish != expected || lease.Statistics.StartTime == "" {
+			t.Errorf("invalid lease statistics: %+v", lease.Statistics)
+		}
+	})
+	t.Run("new statistics manager", func(t *testing.T) {
+		// Nothing is kept in memory, so a restarted gateway finds the counters
+		smgr := stats.NewStatisticsMgr(leaseStatisticsStore{db: backend.DB})
+		statistics, err := smgr.GetLease(leasePath)
+		if err != nil {
+			t.Fatalf("could not get lease statistics: %v", err)
+		}
+		if statistics.Publish != expected {
+			t.Errorf("invalid lease statistics: %+v", statistics)
+		}
+	})
+	t.Run("commit", func(t *testing.T) {
+		if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+		history, err := backend.GetCommitHistory(ctx, "test2.repo.org", 0, 1)
+		if err != nil {
+			t.Fatalf("could not get commit history: %v", err)
+		}
+		if len(history.Commits) != 1 || history.Commits[0].Statistics == nil ||
+			history.Commits[0].Statistics.Publish != expected {
+			t.Errorf("invalid commit history: %+v", history)
+		}
+		if err := backend.StatsMgr.MergeIntoLeaseStatistics(leasePath, &delta); err == nil {
+			t.Errorf("statistics merged into a committed lease")
+		}
+		if _, err := backend.StatsMgr.GetLease(leasePath); err == nil {
+			t.Errorf("statistics found for a committed lease")
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// ManagedKey is a key created or changed at runtime through the admin API. A
+// managed key with the ID of a key from the access configuration file
+// overrides it: the file key can be disabled, or rotated to a new secret
+type ManagedKey struct {
+	ID string
+	// Secret is empty for file keys which have not been rotated
+	Secret  string
+	Admin   bool
+	Enabled bool
+	// PreviousSecret is still accepted until PreviousSecretExpiration, after a
+	// rotation of the key
+	PreviousSecret           string
+	PreviousSecretExpiration time.Time
+	Created                  time.Time
+	Updated                  time.Time
+	// ClientCertSubject replaces the subject of the TLS client certificates
+	// which authenticate as the key, if not empty
+	ClientCertSubject string
+	// NotBefore and NotAfter replace the limits of the validity window of the
+	// key, if not zero
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ManagedKeyPath associates a managed key with a subpath of a repository
+type ManagedKeyPath struct {
+	KeyID      string
+	Repository string
+	Path       string
+}
+
+// CreateManagedKey inserts a new managed key
+func CreateManagedKey(ctx context.Context, tx *sql.Tx, key ManagedKey) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		`insert into ManagedKey
+		(ID, Secret, Admin, Enabled, PreviousSecret, PreviousSecretExpiration, Created, Updated, ClientCertSubject,
+		NotBefore, NotAfter)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		key.ID, key.Secret, key.Admin, key.Enabled, key.PreviousSecret,
+		milliOrZero(key.PreviousSecretExpiration), milliOrZero(key.Created), milliOrZero(key.Updated),
+		key.ClientCertSubject, milliOrZero(key.NotBefore), milliOrZero(key.NotAfter))
+	if err != nil {
+		return fmt.Errorf("could not insert managed key: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("managed key not inserted")
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, enabled: %v", key.ID, key.Enabled)
+
+	return nil
+}
+
+// UpdateManagedKey replaces the secrets, the status, the client certificate
+// subject and the validity window of a managed key
+func UpdateManagedKey(ctx context.Context, tx *sql.Tx, key ManagedKey) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		`update ManagedKey set Secret = ?, Admin = ?, Enabled = ?, PreviousSecret = ?,
+		PreviousSecretExpiration = ?, Updated = ?, ClientCertSubject = ?, NotBefore = ?,
+		NotAfter = ? where ID = ?;`,
+		key.Secret, key.Admin, key.Enabled, key.PreviousSecret,
+		milliOrZero(key.PreviousSecretExpiration), milliOrZero(key.Updated), key.ClientCertSubject,
+		milliOrZero(key.NotBefore), milliOrZero(key.NotAfter), key.ID)
+	if err != nil {
+		return fmt.Errorf("could not update managed key: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates != 1 {
+		return fmt.Errorf("managed key not updated")
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "update").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, enabled: %v", key.ID, key.Enabled)
+
+	return nil
+}
+
+// FindAllManagedKeys returns all the managed keys
+func FindAllManagedKeys(ctx context.Context, tx *sql.Tx) ([]ManagedKey, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from ManagedKey order by ID;")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]ManagedKey, 0)
+	for rows.Next() {
+		var key ManagedKey
+		if err := scanManagedKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "find_all").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("num keys: %v", len(keys))
+
+	return keys, nil
+}
+
+// FindManagedKeyByID returns the managed key with the given ID, or nil
+func FindManagedKeyByID(ctx context.Context, tx *sql.Tx, keyID string) (*ManagedKey, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from ManagedKey where ID = ?;", keyID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var key ManagedKey
+	if rows.Next() {
+		if err := scanManagedKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "find_by_id").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("success")
+
+	return &key, nil
+}
+
+// DeleteManagedKeyByID deletes a managed key and its repository subpaths
+func DeleteManagedKeyByID(ctx context.Context, tx *sql.Tx, keyID string) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx, "delete from ManagedKeyPath where KeyID = ?;", keyID); err != nil {
+		return fmt.Errorf("could not delete managed key paths: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, "delete from ManagedKey where ID = ?;", keyID)
+	if err != nil {
+		return fmt.Errorf("could not delete managed key: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "delete_by_id").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("deleted %v keys", numDeleted)
+
+	return nil
+}
+
+// SetManagedKeyPath associates a managed key with a subpath of a repository,
+// replacing the previous subpath of the key in the repository
+func SetManagedKeyPath(ctx context.Context, tx *sql.Tx, kp ManagedKeyPath) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx,
+		"insert or replace into ManagedKeyPath (KeyID, Repository, Path) values (?, ?, ?);",
+		kp.KeyID, kp.Repository, kp.Path); err != nil {
+		return fmt.Errorf("could not insert managed key path: %w", err)
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "set_path").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, repo: %v, path: %v", kp.KeyID, kp.Repository, kp.Path)
+
+	return nil
+}
+
+// DeleteManagedKeyPath removes the association of a managed key with a
+// repository. Returns false if there was no such association
+func DeleteManagedKeyPath(ctx context.Context, tx *sql.Tx, keyID, repository string) (bool, error) {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"delete from ManagedKeyPath where KeyID = ? and Repository = ?;", keyID, repository)
+	if err != nil {
+		return false, fmt.Errorf("could not delete managed key path: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "delete_path").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, repo: %v, deleted %v paths", keyID, repository, numDeleted)
+
+	return numDeleted > 0, nil
+}
+
+// FindAllManagedKeyPaths returns the repository subpaths of all the managed keys
+func FindAllManagedKeyPaths(ctx context.Context, tx *sql.Tx) ([]ManagedKeyPath, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx,
+		"select KeyID, Repository, Path from ManagedKeyPath order by KeyID, Repository;")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make([]ManagedKeyPath, 0)
+	for rows.Next() {
+		var kp ManagedKeyPath
+		if err := rows.Scan(&kp.KeyID, &kp.Repository, &kp.Path); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		paths = append(paths, kp)
+	}
+
+	gw.LogC(ctx, "managed_key_entity", gw.LogDebug).
+		Str("operation", "find_all_paths").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("num paths: %v", len(paths))
+
+	return paths, nil
+}
+
+func scanManagedKey(rows *sql.Rows, key *ManagedKey) error {
+	var expiration, created, updated, notBefore, notAfter int64
+	if err := rows.Scan(
+		&key.ID, &key.Secret, &key.Admin, &key.Enabled, &key.PreviousSecret,
+		&expiration, &created, &updated, &key.ClientCertSubject, &notBefore, &notAfter); err != nil {
+		return err
+	}
+	key.PreviousSecretExpiration = timeOrZero(expiration)
+	key.Created = timeOrZero(created)
+	key.Updated = timeOrZero(updated)
+	key.NotBefore = timeOrZero(notBefore)
+	key.NotAfter = timeOrZero(notAfter)
+	return nil
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+)
+
+// WriteMetrics updates the gauges which are sampled at scrape time (lease
+// counts, receiver pool utilization) and writes all the gateway metrics to
+// the output, in the Prometheus text exposition format
+func (s *Services) WriteMetrics(ctx context.Context, w io.Writer) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	leases, err := FindAllActiveLeases(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for name := range s.Access.GetRepos() {
+		counts[name] = 0
+	}
+	for _, l := range leases {
+		counts[l.Repository]++
+	}
+	metrics.Leases.Reset()
+	for name, n := range counts {
+		metrics.Leases.WithLabelValues(name).Set(float64(n))
+	}
+
+	poolStats := s.Pool.Stats()
+	metrics.ReceiverWorkers.Set(float64(poolStats.NumWorkers))
+	metrics.ReceiverBusyWorkers.Set(float64(poolStats.BusyWorkers))
+	metrics.ReceiverHealthyWorkers.Set(float64(poolStats.HealthyWorkers))
+	metrics.ReceiverQueuedTasks.Set(float64(poolStats.QueuedTasks))
+
+	return metrics.Write(w)
+}
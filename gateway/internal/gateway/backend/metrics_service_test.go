@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsServiceLeaseCounts(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("metrics_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	var buf bytes.Buffer
+	if err := backend.WriteMetrics(ctx, &buf); err != nil {
+		t.Fatalf("could not write metrics: %v", err)
+	}
+
+	out := buf.String()
+	for _, line := range []string{
+		"cvmfs_gateway_leases{repository=\"test1.repo.org\"} 0\n",
+		"cvmfs_gateway_leases{repository=\"test2.repo.org\"} 1\n",
+		"cvmfs_gateway_receiver_workers 1\n",
+		"cvmfs_gateway_action_duration_seconds_count{action=\"new_lease\",status=\"success\"}",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("metrics output is missing %q:\n%v", line, out)
+		}
+	}
+}
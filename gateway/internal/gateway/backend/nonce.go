@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultRequestTimestampSkew is the maximum difference between the timestamp
+// of a signed request and the time of the gateway, if it is not configured
+const DefaultRequestTimestampSkew = 5 * time.Minute
+
+// Errors returned for signed requests which can not be accepted
+var (
+	ErrStaleRequest    = fmt.Errorf("stale_request")
+	ErrReplayedRequest = fmt.Errorf("replayed_request")
+)
+
+// MaxNonceLength is the maximum length of the nonce of a signed request
+const MaxNonceLength = 128
+
+// NonceCache remembers the nonces of the signed requests of each key, until
+// the timestamp of the request leaves the accepted window. Later requests
+// with the same nonce are rejected as replays
+type NonceCache struct {
+	skew      time.Duration
+	nonces    map[string]time.Time // expiration by key ID and nonce
+	nextPrune time.Time
+	lock      sync.Mutex
+}
+
+// NewNonceCache is a constructor function for the NonceCache type. Requests
+// are accepted if their timestamp differs from the current time by no more
+// than skew
+func NewNonceCache(skew time.Duration) *NonceCache {
+	if skew <= 0 {
+		skew = DefaultRequestTimestampSkew
+	}
+	return &NonceCache{skew: skew, nonces: make(map[string]time.Time)}
+}
+
+// check accepts a signed request of a key with the given timestamp and nonce,
+// at the time now. Returns ErrStaleRequest if the timestamp is outside of the
+// window and ErrReplayedRequest if the nonce has already been used
+func (c *NonceCache) check(keyID, nonce string, timestamp, now time.Time) error {
+	if timestamp.Before(now.Add(-c.skew)) || timestamp.After(now.Add(c.skew)) {
+		return ErrStaleRequest
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.After(c.nextPrune) {
+		for k, expiration := range c.nonces {
+			if now.After(expiration) {
+				delete(c.nonces, k)
+			}
+		}
+		c.nextPrune = now.Add(c.skew)
+	}
+
+	k := keyID + " " + nonce
+	if _, present := c.nonces[k]; present {
+		return ErrReplayedRequest
+	}
+	// Once the timestamp leaves the window, the request is refused as stale
+	c.nonces[k] = timestamp.Add(c.skew)
+
+	return nil
+}
+
+// size returns the number of nonces in the cache
+func (c *NonceCache) size() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.nonces)
+}
+
+// CheckRequestNonce accepts a signed request with a timestamp and a nonce, if
+// the timestamp is recent and the nonce has not been used before by the key
+func (s *Services) CheckRequestNonce(ctx context.Context, keyID, nonce string, timestamp time.Time) error {
+	if nonce == "" || len(nonce) > MaxNonceLength {
+		return fmt.Errorf("invalid_nonce")
+	}
+	return s.Nonces.check(keyID, nonce, timestamp, time.Now())
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCache(t *testing.T) {
+	skew := time.Minute
+	cache := NewNonceCache(skew)
+	now := time.Now()
+
+	t.Run("timestamp window", func(t *testing.T) {
+		if err := cache.check("keyid1", "n1", now.Add(-2*skew), now); err != ErrStaleRequest {
+			t.Errorf("old request accepted: %v", err)
+		}
+		if err := cache.check("keyid1", "n1", now.Add(2*skew), now); err != ErrStaleRequest {
+			t.Errorf("request from the future accepted: %v", err)
+		}
+		if err := cache.check("keyid1", "n1", now.Add(-skew/2), now); err != nil {
+			t.Errorf("request refused: %v", err)
+		}
+	})
+	t.Run("replays", func(t *testing.T) {
+		if err := cache.check("keyid1", "n2", now, now); err != nil {
+			t.Errorf("request refused: %v", err)
+		}
+		if err := cache.check("keyid1", "n2", now, now.Add(time.Second)); err != ErrReplayedRequest {
+			t.Errorf("replayed request accepted: %v", err)
+		}
+		// Nonces are per key
+		if err := cache.check("keyid2", "n2", now, now); err != nil {
+			t.Errorf("request refused: %v", err)
+		}
+	})
+	t.Run("expiration", func(t *testing.T) {
+		if n := cache.size(); n != 3 {
+			t.Errorf("invalid number of nonces: %v", n)
+		}
+		// Once the nonces expire, their requests are refused as stale and
+		// they are removed from the cache
+		later := now.Add(3 * skew)
+		if err := cache.check("keyid1", "n2", now, later); err != ErrStaleRequest {
+			t.Errorf("expired request accepted: %v", err)
+		}
+		if err := cache.check("keyid1", "n3", later, later); err != nil {
+			t.Errorf("request refused: %v", err)
+		}
+		if n := cache.size(); n != 1 {
+			t.Errorf("expired nonces not removed: %v", n)
+		}
+	})
+}
@@ -2,29 +2,52 @@ package backend
 
 import (
 	"context"
+	"strconv"
 	"time"
 )
 
-// PublishManifest publishes a repository manifest to the notification system
-func (s *Services) PublishManifest(ctx context.Context, repository string, message NotificationMessage) {
+// PublishManifest publishes a repository manifest to the notification system,
+// if the key is allowed to publish notifications for the repository
+func (s *Services) PublishManifest(
+	ctx context.Context, keyID, repository string, message NotificationMessage) error {
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "publish_manifest", &outcome, t0)
 
+	if err := s.Access.CheckNotificationPublisher(keyID, repository); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
 	s.Notifications.Publish(ctx, repository, message)
+
+	return nil
 }
 
-// SubscribeToNotifications for a repository
-func (s *Services) SubscribeToNotifications(ctx context.Context, repository string) SubscriberHandle {
+// SubscribeToNotifications for a repository. If lastEventID is the revision of
+// a previously received manifest, the subscription resumes after it, and the
+// second return value is true if manifests following it may have been missed
+func (s *Services) SubscribeToNotifications(
+	ctx context.Context, repository, lastEventID string) (SubscriberHandle, bool) {
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "subscribe_to_notifications", &outcome, t0)
 
-	source := make(chan NotificationMessage, 1000)
-	s.Notifications.Subscribe(ctx, repository, source)
-	return source
+	// Messages are queued by the notification system, the handle itself is
+	// unbuffered
+	source := make(chan NotificationMessage)
+	missed := false
+	if lastRevision, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		missed = s.Notifications.SubscribeFrom(ctx, repository, source, lastRevision)
+	} else {
+		s.Notifications.Subscribe(ctx, repository, source)
+	}
+	if missed {
+		outcome = "success: history gap"
+	}
+	return source, missed
 }
 
 // UnsubscribeFromNotifications for a repository
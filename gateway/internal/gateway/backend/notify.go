@@ -3,9 +3,13 @@ package backend
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
 )
 
 // NotificationMessage is an alias for a UTF-8 string
@@ -14,31 +18,79 @@ type NotificationMessage string
 // SubscriberHandle is the writable end of a channel of notification messages
 type SubscriberHandle chan NotificationMessage
 
-// SubscriberSet is a set of subscriber handles (implemented as a map handler -> void)
-type SubscriberSet map[SubscriberHandle]struct{}
+// SubscriberSet is a set of subscriber handles, each with its message queue
+type SubscriberSet map[SubscriberHandle]*subscriber
 
 // SubscriberMap holds a set of subscriber handles for each repository
 type SubscriberMap map[string]SubscriberSet
 
+// NotificationStore holds the last manifest published for each repository
 type NotificationStore map[string]NotificationMessage
 
+// NotificationHistory holds the most recent messages published for each
+// repository, oldest first
+type NotificationHistory map[string][]NotificationMessage
+
+// notificationHistorySize is the number of messages kept for each repository,
+// for replaying to reconnecting subscribers
+const notificationHistorySize = 16
+
 // NotificationSystem encapsulates the functionality of the repository
 // activity notification system
 type NotificationSystem struct {
 	Subscribers    SubscriberMap
 	SubscriberLock sync.RWMutex
 	Store          NotificationStore
+	History        NotificationHistory
+	StoreLock      sync.RWMutex
+	// StoreDir is where the last published manifest of each repository is
+	// persisted, so that it can be replayed to new subscribers after a restart
+	StoreDir string
+	// QueueSize is the maximum number of messages queued for each subscriber
+	QueueSize int
+	// Policy is the action taken when the queue of a subscriber is full
+	Policy SlowConsumerPolicy
 }
 
 // NewNotificationSystem is a constructor function for the NotificationSystem type
-func NewNotificationSystem(workDir string) (*NotificationSystem, error) {
+func NewNotificationSystem(
+	workDir string, queueSize int, policy SlowConsumerPolicy) (*NotificationSystem, error) {
+	if queueSize <= 0 {
+		queueSize = DefaultNotificationQueueSize
+	}
+	if policy != DropOldest && policy != Disconnect {
+		return nil, fmt.Errorf("invalid slow consumer policy: %v", policy)
+	}
+
+	storeDir := filepath.Join(workDir, "notifications")
+	if err := os.MkdirAll(storeDir, 0777); err != nil {
+		return nil, fmt.Errorf("could not create notification store directory: %w", err)
+	}
+
+	store, err := loadNotificationStore(storeDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not load notification store: %w", err)
+	}
+
+	// The history is not persisted, it starts with the last published message
+	history := make(NotificationHistory)
+	for repository, message := range store {
+		history[repository] = []NotificationMessage{message}
+	}
+
 	gw.Log("notify", gw.LogInfo).
-		Msgf("database opened (work dir: %v)", workDir)
+		Msgf("notification system started (store dir: %v, repositories: %v)",
+			storeDir, len(store))
 
 	ns := &NotificationSystem{
 		Subscribers:    make(SubscriberMap),
 		SubscriberLock: sync.RWMutex{},
-		Store:          make(NotificationStore),
+		Store:          store,
+		History:        history,
+		StoreLock:      sync.RWMutex{},
+		StoreDir:       storeDir,
+		QueueSize:      queueSize,
+		Policy:         policy,
 	}
 
 	return ns, nil
@@ -51,6 +103,12 @@ func (ns *NotificationSystem) Publish(
 	existing := ns.getMessage(repository)
 	if existing == "" || message != existing {
 		ns.setMessage(repository, message)
+		if err := ns.persistMessage(repository, message); err != nil {
+			gw.LogC(ctx, "notify", gw.LogError).
+				Err(err).
+				Str("repository", repository).
+				Msg("could not persist manifest")
+		}
 		ns.notify(repository, message)
 	}
 
@@ -59,43 +117,65 @@ func (ns *NotificationSystem) Publish(
 		Msg("manifest published")
 }
 
-// Subscribe the handle to messages for the given repository
+// Subscribe the handle to messages for the given repository. The last message
+// published for the repository, if any, is delivered first. Messages are
+// queued for each subscriber and delivered from a separate goroutine
 func (ns *NotificationSystem) Subscribe(
 	ctx context.Context, repository string, handle SubscriberHandle) {
-
-	added := false
-	func() {
-		ns.SubscriberLock.Lock()
-		defer ns.SubscriberLock.Unlock()
-		subsForRepo, present := ns.Subscribers[repository]
-		if present {
-			_, pres := subsForRepo[handle]
-			if !pres {
-				subsForRepo[handle] = struct{}{}
-				added = true
-			}
-		} else {
-			subsForRepo = make(SubscriberSet)
-			subsForRepo[handle] = struct{}{}
-			added = true
-			ns.Subscribers[repository] = subsForRepo
+	ns.subscribe(ctx, repository, handle, func() []NotificationMessage {
+		if message := ns.getMessage(repository); message != "" {
+			return []NotificationMessage{message}
 		}
-	}()
+		return nil
+	})
+}
 
-	if added {
-		message := ns.getMessage(repository)
+// SubscribeFrom subscribes the handle to messages for the given repository,
+// resuming after the message with the given revision. The messages in the
+// history with a newer revision are delivered first. Returns true if messages
+// following the given revision may have been missed, because they are no
+// longer in the history
+func (ns *NotificationSystem) SubscribeFrom(
+	ctx context.Context, repository string, handle SubscriberHandle, lastRevision uint64) bool {
+	missed := false
+	ns.subscribe(ctx, repository, handle, func() []NotificationMessage {
+		var messages []NotificationMessage
+		messages, missed = ns.getHistory(repository, lastRevision)
+		return messages
+	})
+	return missed
+}
 
-		if message != "" {
-			ns.notify(repository, message)
-		}
+func (ns *NotificationSystem) subscribe(
+	ctx context.Context, repository string, handle SubscriberHandle,
+	replay func() []NotificationMessage) {
 
-		gw.LogC(ctx, "notify", gw.LogDebug).
-			Str("repository", repository).
-			Msg("subscription added")
+	ns.SubscriberLock.Lock()
+	defer ns.SubscriberLock.Unlock()
+
+	subsForRepo, present := ns.Subscribers[repository]
+	if !present {
+		subsForRepo = make(SubscriberSet)
+		ns.Subscribers[repository] = subsForRepo
 	}
+	if _, pres := subsForRepo[handle]; pres {
+		return
+	}
+
+	sub := newSubscriber(repository, handle, ns.QueueSize)
+	for _, message := range replay() {
+		sub.push(message, ns.Policy)
+	}
+	subsForRepo[handle] = sub
+	go sub.deliver()
+
+	gw.LogC(ctx, "notify", gw.LogDebug).
+		Str("repository", repository).
+		Msg("subscription added")
 }
 
-// Unsubscribe from messages for the given repository. Closes the subscriber handle (chan)
+// Unsubscribe from messages for the given repository. The subscriber handle
+// (chan) is closed once the delivery of the queued messages is stopped
 func (ns *NotificationSystem) Unsubscribe(
 	ctx context.Context, repository string, handle SubscriberHandle) error {
 
@@ -106,13 +186,13 @@ func (ns *NotificationSystem) Unsubscribe(
 		return fmt.Errorf("no_subscriptions_for_repository")
 	}
 
-	_, found := subsForRepo[handle]
+	sub, found := subsForRepo[handle]
 	if !found {
 		return fmt.Errorf("invalid_handle")
 	}
 
 	delete(subsForRepo, handle)
-	close(handle)
+	close(sub.done)
 
 	gw.LogC(ctx, "notify", gw.LogDebug).
 		Str("repository", repository).
@@ -121,21 +201,155 @@ func (ns *NotificationSystem) Unsubscribe(
 	return nil
 }
 
+// notify queues the message for all the subscribers of the repository. With
+// the Disconnect policy, the subscribers whose queue is full are removed
 func (ns *NotificationSystem) notify(repository string, message NotificationMessage) {
-	ns.SubscriberLock.RLock()
-	defer ns.SubscriberLock.RUnlock()
-	subsForRepo, present := ns.Subscribers[repository]
-	if present {
-		for s := range subsForRepo {
-			s <- message
+	slow := make([]*subscriber, 0)
+	func() {
+		ns.SubscriberLock.RLock()
+		defer ns.SubscriberLock.RUnlock()
+		for _, sub := range ns.Subscribers[repository] {
+			if !sub.push(message, ns.Policy) {
+				slow = append(slow, sub)
+			}
 		}
+	}()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	ns.SubscriberLock.Lock()
+	defer ns.SubscriberLock.Unlock()
+	subsForRepo := ns.Subscribers[repository]
+	for _, sub := range slow {
+		// The subscription may have been removed in the meantime
+		if _, present := subsForRepo[sub.handle]; !present {
+			continue
+		}
+		delete(subsForRepo, sub.handle)
+		close(sub.done)
+		metrics.NotificationSubscribersDisconnected.WithLabelValues(repository).Inc()
+
+		gw.Log("notify", gw.LogWarn).
+			Str("repository", repository).
+			Msg("slow subscriber disconnected")
 	}
 }
 
 func (ns *NotificationSystem) getMessage(repository string) NotificationMessage {
+	ns.StoreLock.RLock()
+	defer ns.StoreLock.RUnlock()
 	return ns.Store[repository]
 }
 
 func (ns *NotificationSystem) setMessage(repository string, message NotificationMessage) {
+	ns.StoreLock.Lock()
+	defer ns.StoreLock.Unlock()
 	ns.Store[repository] = message
+
+	history := append(ns.History[repository], message)
+	if len(history) > notificationHistorySize {
+		history = history[len(history)-notificationHistorySize:]
+	}
+	ns.History[repository] = history
+}
+
+// getHistory returns the messages in the history of the repository with a
+// revision newer than lastRevision. The second return value is true if
+// neither the message with lastRevision nor the one following it is in the
+// history anymore, so messages may have been missed
+func (ns *NotificationSystem) getHistory(
+	repository string, lastRevision uint64) ([]NotificationMessage, bool) {
+	ns.StoreLock.RLock()
+	defer ns.StoreLock.RUnlock()
+
+	history := ns.History[repository]
+	ret := make([]NotificationMessage, 0, len(history))
+	contiguous := false
+	for _, message := range history {
+		revision, ok := message.Revision()
+		if !ok {
+			continue
+		}
+		if revision == lastRevision || (len(ret) == 0 && revision == lastRevision+1) {
+			contiguous = true
+		}
+		if revision > lastRevision {
+			ret = append(ret, message)
+		}
+	}
+
+	return ret, len(ret) > 0 && !contiguous
+}
+
+// storeTempPrefix is the prefix of the temporary files in the store
+// directory. Repository names never start with a dot, so the temporary files
+// can't be mistaken for stored messages
+const storeTempPrefix = ".tmp-"
+
+// persistMessage writes the message to the store directory, replacing the
+// previous message of the repository. The file is written under a temporary
+// name and renamed, so that a crash never leaves a truncated message behind
+func (ns *NotificationSystem) persistMessage(repository string, message NotificationMessage) error {
+	if !validStoreName(repository) {
+		return fmt.Errorf("invalid repository name: %v", repository)
+	}
+
+	ns.StoreLock.Lock()
+	defer ns.StoreLock.Unlock()
+
+	tmp, err := os.CreateTemp(ns.StoreDir, storeTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("could not create message file: %w", err)
+	}
+	tmpName := tmp.Name()
+	_, err = tmp.Write([]byte(message))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("could not write message: %w", err)
+	}
+	if err := os.Rename(tmpName, filepath.Join(ns.StoreDir, repository)); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("could not replace message: %w", err)
+	}
+
+	return nil
+}
+
+// loadNotificationStore reads the last published message of each repository
+// from the store directory
+func loadNotificationStore(storeDir string) (NotificationStore, error) {
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	store := make(NotificationStore)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), storeTempPrefix) {
+			continue
+		}
+		message, err := os.ReadFile(filepath.Join(storeDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		store[entry.Name()] = NotificationMessage(message)
+	}
+
+	return store, nil
+}
+
+// validStoreName returns true if the repository name can be used as a file
+// name in the store directory
+func validStoreName(repository string) bool {
+	return repository != "" &&
+		!strings.HasPrefix(repository, ".") &&
+		filepath.Base(repository) == repository
 }
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Revision returns the repository revision of the manifest contained in the
+// notification message, which is used as the event ID for the subscribers.
+// False is returned if the message does not contain a valid manifest
+func (m NotificationMessage) Revision() (uint64, bool) {
+	var msg struct {
+		Manifest string `json:"manifest"`
+	}
+	if err := json.Unmarshal([]byte(m), &msg); err != nil {
+		return 0, false
+	}
+
+	if revision, ok := manifestRevision(msg.Manifest); ok {
+		return revision, true
+	}
+
+	// The manifest may be sent base64 encoded
+	decoded, err := base64.StdEncoding.DecodeString(msg.Manifest)
+	if err != nil {
+		return 0, false
+	}
+	return manifestRevision(string(decoded))
+}
+
+// manifestRevision extracts the revision ("S" line) from the text of a
+// repository manifest (.cvmfspublished)
+func manifestRevision(manifest string) (uint64, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(manifest))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// The signature follows the "--" separator
+		if line == "--" {
+			break
+		}
+		if strings.HasPrefix(line, "S") {
+			revision, err := strconv.ParseUint(line[1:], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return revision, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"sync"
+
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+)
+
+// SlowConsumerPolicy is the action taken when a new message is published and
+// the queue of a subscriber is full
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// Disconnect closes the subscription of the slow consumer
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// DefaultNotificationQueueSize is the number of messages queued for each
+// subscriber when no queue size is configured
+const DefaultNotificationQueueSize = 1000
+
+// subscriber holds the queue of messages waiting to be delivered to a
+// subscriber handle. Each subscriber has its own delivery goroutine, so
+// that a stalled consumer never blocks the publishers
+type subscriber struct {
+	repository string
+	handle     SubscriberHandle
+	queue      []NotificationMessage
+	queueSize  int
+	lock       sync.Mutex
+	// ready is signalled when a message is added to the empty queue
+	ready chan struct{}
+	// done is closed when the subscription is removed
+	done chan struct{}
+}
+
+func newSubscriber(repository string, handle SubscriberHandle, queueSize int) *subscriber {
+	return &subscriber{
+		repository: repository,
+		handle:     handle,
+		queue:      make([]NotificationMessage, 0),
+		queueSize:  queueSize,
+		ready:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// push adds a message to the queue of the subscriber. When the queue is full,
+// the oldest message is dropped, unless the policy is Disconnect, in which case
+// the message is not added and false is returned
+func (s *subscriber) push(message NotificationMessage, policy SlowConsumerPolicy) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) >= s.queueSize {
+		metrics.NotificationsDropped.WithLabelValues(s.repository).Inc()
+		if policy == Disconnect {
+			return false
+		}
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, message)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func (s *subscriber) pop() (NotificationMessage, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) == 0 {
+		return "", false
+	}
+	message := s.queue[0]
+	s.queue = s.queue[1:]
+
+	return message, true
+}
+
+// deliver forwards the queued messages to the subscriber handle, until the
+// subscription is removed. The handle is closed on return
+func (s *subscriber) deliver() {
+	defer close(s.handle)
+
+	for {
+		message, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.ready:
+				continue
+			case <-s.done:
+				s.flush()
+				return
+			}
+		}
+
+		select {
+		case s.handle <- message:
+		case <-s.done:
+			s.flush(message)
+			return
+		}
+	}
+}
+
+// flush hands over the pending messages which fit in the buffer of the
+// subscriber handle, without blocking. The rest are discarded
+func (s *subscriber) flush(pending ...NotificationMessage) {
+	s.lock.Lock()
+	pending = append(pending, s.queue...)
+	s.queue = nil
+	s.lock.Unlock()
+
+	for _, message := range pending {
+		select {
+		case s.handle <- message:
+		default:
+			return
+		}
+	}
+}
@@ -2,9 +2,11 @@ package backend
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestNotificationSystem(t *testing.T) {
@@ -14,7 +16,7 @@ func TestNotificationSystem(t *testing.T) {
 	}
 	defer os.RemoveAll(tmp)
 
-	ns, err := NewNotificationSystem(tmp)
+	ns, err := NewNotificationSystem(tmp, 0, DropOldest)
 	if err != nil {
 		t.Fatalf("could not create notification system")
 	}
@@ -50,7 +52,7 @@ func TestNotificationSystemLateSubscription(t *testing.T) {
 	}
 	defer os.RemoveAll(tmp)
 
-	ns, err := NewNotificationSystem(tmp)
+	ns, err := NewNotificationSystem(tmp, 0, DropOldest)
 	if err != nil {
 		t.Fatalf("could not create notification system")
 	}
@@ -75,3 +77,202 @@ func TestNotificationSystemLateSubscription(t *testing.T) {
 		t.Fatalf("Unexpected received message pattern: %v", messages)
 	}
 }
+
+func TestNotificationSystemRestart(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp, 0, DropOldest)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+
+	ctx := context.TODO()
+	repo := "test.repo.org"
+
+	ns.Publish(ctx, repo, NotificationMessage("msg1"))
+	ns.Publish(ctx, repo, NotificationMessage("msg2"))
+	ns.Publish(ctx, "../invalid", NotificationMessage("msg3"))
+	ns.Publish(ctx, "other.repo.org.tmp", NotificationMessage("msg4"))
+
+	// Simulate a gateway restart
+	ns, err = NewNotificationSystem(tmp, 0, DropOldest)
+	if err != nil {
+		t.Fatalf("could not recreate notification system")
+	}
+
+	hd := make(chan NotificationMessage, 1000)
+	ns.Subscribe(ctx, repo, hd)
+	ns.Unsubscribe(ctx, repo, hd)
+
+	messages := make([]NotificationMessage, 0)
+	for m := range hd {
+		messages = append(messages, m)
+	}
+
+	if len(messages) != 1 || messages[0] != "msg2" {
+		t.Fatalf("Unexpected received message pattern: %v", messages)
+	}
+	if len(ns.Store) != 2 || ns.Store["other.repo.org.tmp"] != "msg4" {
+		t.Fatalf("Unexpected notification store contents: %v", ns.Store)
+	}
+}
+
+func TestNotificationSystemSlowConsumerDropOldest(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp, 2, DropOldest)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+
+	// The handle is unbuffered and nobody is reading from it yet
+	hd := make(chan NotificationMessage)
+
+	ctx := context.TODO()
+	repo := "test.repo.org"
+
+	ns.Subscribe(ctx, repo, hd)
+
+	published := make(chan struct{})
+	go func() {
+		for _, m := range []string{"msg1", "msg2", "msg3", "msg4", "msg5"} {
+			ns.Publish(ctx, repo, NotificationMessage(m))
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publishing was blocked by a slow subscriber")
+	}
+
+	messages := make([]NotificationMessage, 0)
+	for len(messages) == 0 || messages[len(messages)-1] != "msg5" {
+		select {
+		case m := <-hd:
+			messages = append(messages, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for messages, received: %v", messages)
+		}
+	}
+	ns.Unsubscribe(ctx, repo, hd)
+
+	// Only the message being delivered and the two newest messages are kept
+	n := len(messages)
+	if n < 2 || n > 3 || messages[n-2] != "msg4" || messages[n-1] != "msg5" {
+		t.Fatalf("Unexpected received message pattern: %v", messages)
+	}
+}
+
+func TestNotificationSystemSlowConsumerDisconnect(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp, 1, Disconnect)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+
+	hd := make(chan NotificationMessage)
+
+	ctx := context.TODO()
+	repo := "test.repo.org"
+
+	ns.Subscribe(ctx, repo, hd)
+
+	for _, m := range []string{"msg1", "msg2", "msg3"} {
+		ns.Publish(ctx, repo, NotificationMessage(m))
+	}
+
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-hd:
+			closed = !ok
+		case <-timeout:
+			t.Fatalf("slow subscriber was not disconnected")
+		}
+	}
+
+	if err := ns.Unsubscribe(ctx, repo, hd); err == nil {
+		t.Fatalf("disconnected subscriber should have been removed")
+	}
+}
+
+func TestNotificationSystemResume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp, 0, DropOldest)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+
+	ctx := context.TODO()
+	repo := "test.repo.org"
+
+	message := func(revision int) NotificationMessage {
+		return NotificationMessage(fmt.Sprintf(
+			`{"repository":"%v","manifest":"C0123\nS%v\n--\nsig"}`, repo, revision))
+	}
+	for i := 1; i <= notificationHistorySize+2; i++ {
+		ns.Publish(ctx, repo, message(i))
+	}
+
+	receive := func(lastRevision uint64) ([]uint64, bool) {
+		hd := make(chan NotificationMessage, 1000)
+		missed := ns.SubscribeFrom(ctx, repo, hd, lastRevision)
+		ns.Unsubscribe(ctx, repo, hd)
+		revisions := make([]uint64, 0)
+		for m := range hd {
+			revision, _ := m.Revision()
+			revisions = append(revisions, revision)
+		}
+		return revisions, missed
+	}
+
+	last := uint64(notificationHistorySize + 2)
+	if revisions, missed := receive(last - 2); len(revisions) != 2 || revisions[1] != last || missed {
+		t.Fatalf("Unexpected replayed revisions: %v, %v", revisions, missed)
+	}
+	if revisions, missed := receive(last); len(revisions) != 0 || missed {
+		t.Fatalf("Unexpected replayed revisions: %v, %v", revisions, missed)
+	}
+	// The history starts right after revision 2
+	if revisions, missed := receive(2); len(revisions) != notificationHistorySize || revisions[0] != 3 || missed {
+		t.Fatalf("Unexpected replayed revisions: %v, %v", revisions, missed)
+	}
+	// Resuming from a revision older than the history replays the whole
+	// history, and reports that revisions have been missed
+	if revisions, missed := receive(1); len(revisions) != notificationHistorySize || revisions[0] != 3 || !missed {
+		t.Fatalf("Unexpected replayed revisions: %v, %v", revisions, missed)
+	}
+}
+
+func TestNotificationMessageRevision(t *testing.T) {
+	plain := NotificationMessage(`{"manifest":"C0123\nR0123\nS17\n--\nsig"}`)
+	if revision, ok := plain.Revision(); !ok || revision != 17 {
+		t.Fatalf("Unexpected revision: %v (%v)", revision, ok)
+	}
+	encoded := NotificationMessage(`{"manifest":"QzAxMjMKUzE4Ci0tCnNpZw=="}`)
+	if revision, ok := encoded.Revision(); !ok || revision != 18 {
+		t.Fatalf("Unexpected revision: %v (%v)", revision, ok)
+	}
+	if _, ok := NotificationMessage("msg1").Revision(); ok {
+		t.Fatalf("Revision found in invalid message")
+	}
+}
@@ -0,0 +1,12 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+)
+
+// GetReceiverHealth returns the state of the workers of the receiver pool
+func (s *Services) GetReceiverHealth(ctx context.Context) []receiver.WorkerHealth {
+	return s.Pool.Health()
+}
@@ -12,7 +12,7 @@ func (s *Services) NewRepo(ctx context.Context, name string, enabled bool) error
 	outcome := "success"
 	defer logAction(ctx, "new_repo", &outcome, t0)
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginWriteTx(ctx)
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
@@ -59,7 +59,7 @@ func (s *Services) GetRepo(ctx context.Context, repoName string) (*RepositoryCon
 
 	repoConfig := s.Access.GetRepo(repoName)
 	if repo != nil && repoConfig != nil {
-		repoConfig.Enabled = repo.Enabled
+		setRepoState(repoConfig, repo)
 	}
 
 	return repoConfig, nil
@@ -86,7 +86,7 @@ func (s *Services) GetRepos(ctx context.Context) (map[string]RepositoryConfig, e
 	repoConfig := s.Access.GetRepos()
 	for _, repo := range repos {
 		cfg := repoConfig[repo.Name]
-		cfg.Enabled = repo.Enabled
+		setRepoState(&cfg, &repo)
 		repoConfig[repo.Name] = cfg
 	}
 
@@ -97,15 +97,24 @@ func (s *Services) GetRepos(ctx context.Context) (map[string]RepositoryConfig, e
 	return repoConfig, nil
 }
 
-// SetRepoEnabled enables or disables a repository. The change does not persist
-// across applications restarts
-func (s *Services) SetRepoEnabled(ctx context.Context, repoName string, enable bool) error {
+// SetRepoEnabled enables or disables a repository. The reason is recorded
+// together with the time when the repository was disabled, and both are
+// cleared when the repository is enabled again. The change persists across
+// application restarts
+func (s *Services) SetRepoEnabled(ctx context.Context, repoName string, enable bool, reason string) error {
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "set_repo_enabled", &outcome, t0)
+	action := "disable_repo"
+	if enable {
+		action = "enable_repo"
+	}
+	defer func() {
+		s.audit(ctx, AuditRecord{Action: action, Repository: repoName}, outcome)
+	}()
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginWriteTx(ctx)
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
@@ -113,15 +122,90 @@ func (s *Services) SetRepoEnabled(ctx context.Context, repoName string, enable b
 
 	repo, err := FindRepositoryByName(ctx, tx, repoName)
 	if err != nil {
+		outcome = err.Error()
 		return err
 	}
 
+	if repo == nil {
+		err := fmt.Errorf("invalid_repo")
+		outcome = err.Error()
+		return err
+	}
+
+	if enable {
+		repo.DisabledReason = ""
+		repo.DisabledSince = time.Time{}
+	} else if repo.Enabled {
+		repo.DisabledReason = reason
+		repo.DisabledSince = time.Now()
+	} else {
+		// Repository already disabled, keep the original time
+		repo.DisabledReason = reason
+	}
 	repo.Enabled = enable
 
 	if err := UpdateRepository(ctx, tx, *repo); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		outcome = err.Error()
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileRepositories brings the Repository table in line with the access
+// configuration: repositories which are new in the configuration are created
+// (enabled), repositories no longer present are removed, and the state of the
+// others is left untouched
+func (s *Services) ReconcileRepositories(ctx context.Context) error {
+	return s.reconcileRepositories(ctx, s.Access.GetRepos())
+}
+
+// reconcileRepositories makes the repository table match the given
+// configured repositories
+func (s *Services) reconcileRepositories(ctx context.Context, configured map[string]RepositoryConfig) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "reconcile_repositories", &outcome, t0)
+
+	tx, err := s.DB.BeginWriteTx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := FindAllRepositories(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
 		return err
 	}
 
+	known := make(map[string]bool)
+	for _, repo := range existing {
+		known[repo.Name] = true
+		if _, present := configured[repo.Name]; !present {
+			if err := DeleteRepositoryByName(ctx, tx, repo.Name); err != nil {
+				outcome = err.Error()
+				return err
+			}
+		}
+	}
+
+	for name := range configured {
+		if !known[name] {
+			repo := Repository{Name: name, Manifest: "", Enabled: true}
+			if err := CreateRepository(ctx, tx, repo); err != nil {
+				outcome = err.Error()
+				return fmt.Errorf("could not create repository: %w", err)
+			}
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("could not commit transaction: %w", err)
 	}
@@ -135,7 +219,7 @@ func (s *Services) DeleteAllRepositories(ctx context.Context) error {
 	outcome := "success"
 	defer logAction(ctx, "delete_all", &outcome, t0)
 
-	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginWriteTx(ctx)
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
@@ -151,3 +235,16 @@ func (s *Services) DeleteAllRepositories(ctx context.Context) error {
 
 	return nil
 }
+
+// setRepoState copies the runtime state of a repository from the DB entity
+// into the repository configuration
+func setRepoState(cfg *RepositoryConfig, repo *Repository) {
+	cfg.Enabled = repo.Enabled
+	cfg.DisabledReason = repo.DisabledReason
+	cfg.DisabledSince = nil
+	if !repo.DisabledSince.IsZero() {
+		since := repo.DisabledSince
+		cfg.DisabledSince = &since
+	}
+	cfg.LastGC = repo.LastGC
+}
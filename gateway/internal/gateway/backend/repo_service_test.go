@@ -21,17 +21,58 @@ func TestRepoServiceToggleRepo(t *testing.T) {
 		t.Fatalf("Repository %v should be enabled by default", repoName)
 	}
 
-	backend.SetRepoEnabled(ctx, repoName, false)
+	backend.SetRepoEnabled(ctx, repoName, false, "maintenance")
 
 	repos, _ = backend.GetRepos(ctx)
 	if repos[repoName].Enabled {
 		t.Fatalf("Repository %v should have been disabled", repoName)
 	}
+	if repos[repoName].DisabledReason != "maintenance" || repos[repoName].DisabledSince == nil {
+		t.Fatalf("Repository %v should have a disabled reason and time: %+v", repoName, repos[repoName])
+	}
 
-	backend.SetRepoEnabled(ctx, repoName, true)
+	backend.SetRepoEnabled(ctx, repoName, true, "")
 
 	repos, _ = backend.GetRepos(ctx)
 	if !repos[repoName].Enabled {
 		t.Fatalf("Repository %v should have been reenabled", repoName)
 	}
+	if repos[repoName].DisabledReason != "" || repos[repoName].DisabledSince != nil {
+		t.Fatalf("Repository %v should not have a disabled reason and time: %+v", repoName, repos[repoName])
+	}
+}
+
+func TestRepoServicePersistState(t *testing.T) {
+	backend, tmp := StartTestBackend("repo_actions_persist_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	repoName := "test1.repo.org"
+	if err := backend.SetRepoEnabled(ctx, repoName, false, "maintenance"); err != nil {
+		t.Fatalf("could not disable repository: %v", err)
+	}
+
+	// Simulate an application restart
+	if err := backend.DB.Close(); err != nil {
+		t.Fatalf("could not close database: %v", err)
+	}
+	db, err := OpenDB(backend.Config)
+	if err != nil {
+		t.Fatalf("could not reopen database: %v", err)
+	}
+	backend.DB = db
+	if err := PopulateRepositories(backend); err != nil {
+		t.Fatalf("could not populate repositories: %v", err)
+	}
+
+	rc, err := backend.GetRepo(ctx, repoName)
+	if err != nil {
+		t.Fatalf("could not query repository: %v", err)
+	}
+	if rc.Enabled || rc.DisabledReason != "maintenance" {
+		t.Fatalf("Repository %v state was not preserved across restart: %+v", repoName, rc)
+	}
 }
@@ -3,6 +3,7 @@ package backend
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -14,17 +15,22 @@ import (
 var ErrRepoDisabled = fmt.Errorf("repo_disabled")
 
 type Repository struct {
-	Name     string
-	Manifest string
-	Enabled  bool
+	Name           string
+	Manifest       string
+	Enabled        bool
+	DisabledReason string
+	DisabledSince  time.Time  // zero if the repository is enabled
+	LastGCRun      time.Time  // start of the last scheduled garbage collection run
+	LastGC         *GCOutcome // outcome of the last scheduled garbage collection
 }
 
 func CreateRepository(ctx context.Context, tx *sql.Tx, repo Repository) error {
 	t0 := time.Now()
 
 	res, err := tx.ExecContext(ctx,
-		"insert into Repository (Name, Manifest, Enabled) values (?, ?, ?);",
-		repo.Name, repo.Manifest, repo.Enabled)
+		"insert into Repository (Name, Manifest, Enabled, DisabledReason, DisabledSince, LastGCRun, LastGCOutcome) values (?, ?, ?, ?, ?, ?, ?);",
+		repo.Name, repo.Manifest, repo.Enabled, repo.DisabledReason, disabledSinceMilli(repo),
+		milliOrZero(repo.LastGCRun), lastGCOutcome(repo))
 	if err != nil {
 		return fmt.Errorf("could not insert new repository: %w", err)
 	}
@@ -46,8 +52,9 @@ func UpdateRepository(ctx context.Context, tx *sql.Tx, repo Repository) error {
 	t0 := time.Now()
 
 	res, err := tx.ExecContext(ctx,
-		"update Repository set Manifest = ?, Enabled = ? where Name = ?;",
-		repo.Manifest, repo.Enabled, repo.Name)
+		"update Repository set Manifest = ?, Enabled = ?, DisabledReason = ?, DisabledSince = ?, LastGCRun = ?, LastGCOutcome = ? where Name = ?;",
+		repo.Manifest, repo.Enabled, repo.DisabledReason, disabledSinceMilli(repo),
+		milliOrZero(repo.LastGCRun), lastGCOutcome(repo), repo.Name)
 	if err != nil {
 		return fmt.Errorf("could not update repository: %w", err)
 	}
@@ -70,7 +77,7 @@ func FindAllRepositories(ctx context.Context, tx *sql.Tx) ([]Repository, error)
 
 	rows, err := tx.QueryContext(
 		ctx,
-		"select * from Repository;")
+		"select * from Repository order by Name;")
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -121,6 +128,23 @@ func FindRepositoryByName(ctx context.Context, tx *sql.Tx, name string) (*Reposi
 	return &repo, nil
 }
 
+func DeleteRepositoryByName(ctx context.Context, tx *sql.Tx, name string) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx, "delete from Repository where Name = ?;", name)
+	if err != nil {
+		return fmt.Errorf("could not delete repository: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "delete_by_name").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("deleted %v repositories", numDeleted)
+
+	return nil
+}
+
 func DeleteAllRepositories(ctx context.Context, tx *sql.Tx) error {
 	t0 := time.Now()
 
@@ -138,12 +162,44 @@ func DeleteAllRepositories(ctx context.Context, tx *sql.Tx) error {
 }
 
 func scanRepository(rows *sql.Rows, repo *Repository) error {
+	var disabledSinceMilli, lastGCRunMilli int64
+	var lastGCOutcome string
 	if err := rows.Scan(
 		&repo.Name,
 		&repo.Manifest,
-		&repo.Enabled); err != nil {
+		&repo.Enabled,
+		&repo.DisabledReason,
+		&disabledSinceMilli,
+		&lastGCRunMilli,
+		&lastGCOutcome); err != nil {
 		return err
 	}
 
+	if disabledSinceMilli != 0 {
+		repo.DisabledSince = time.UnixMilli(disabledSinceMilli)
+	}
+	repo.LastGCRun = timeOrZero(lastGCRunMilli)
+	if lastGCOutcome != "" {
+		repo.LastGC = &GCOutcome{}
+		if err := json.Unmarshal([]byte(lastGCOutcome), repo.LastGC); err != nil {
+			return fmt.Errorf("could not deserialize gc outcome: %w", err)
+		}
+	}
+
 	return nil
 }
+
+func disabledSinceMilli(repo Repository) int64 {
+	if repo.DisabledSince.IsZero() {
+		return 0
+	}
+	return repo.DisabledSince.UnixMilli()
+}
+
+func lastGCOutcome(repo Repository) string {
+	if repo.LastGC == nil {
+		return ""
+	}
+	outcome, _ := json.Marshal(repo.LastGC)
+	return string(outcome)
+}
@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -49,7 +50,8 @@ const accessConfigV2 = `
 				{
 					"id": "keyid1",
 					"admin": true,
-					"path": "/"
+					"path": "/",
+					"can_publish_notifications": true
 				},
 				{
 					"id": "keyid2",
@@ -78,6 +80,44 @@ const accessConfigV2 = `
 }
 `
 
+// accessConfigV2LeaseLimits is an access configuration with repository and key
+// lease time limits
+const accessConfigV2LeaseLimits = `
+{
+	"version": 2,
+	"repos" : [
+		{
+			"domain": "test.repo.org",
+			"max_lease_time": 600,
+			"default_lease_time": 300,
+			"keys": [
+				{
+					"id": "keyid1",
+					"path": "/"
+				},
+				{
+					"id": "keyid2",
+					"path": "/",
+					"max_lease_time": 60
+				}
+			]
+		}
+	],
+	"keys": [
+		{
+			"type": "plain_text",
+			"id": "keyid1",
+			"secret": "secret1"
+		},
+		{
+			"type": "plain_text",
+			"id": "keyid2",
+			"secret": "secret2"
+		}
+	]
+}
+`
+
 // accessConfigV2NoKeys is a minimal access configuration using the new syntax
 const accessConfigV2NoKeys = `
 {
@@ -98,6 +138,8 @@ func mockKeyImporter(ks KeySpec) (string, string, string, bool, error) {
 	switch ks.KeyType {
 	case "plain_text":
 		return ks.ID, ks.Secret, ks.Path, ks.Admin, nil
+	case "client_cert":
+		return ks.ID, "", ks.Path, ks.Admin, nil
 	case "file":
 		return "keyid123", "secret123", "/", false, nil
 	default:
@@ -140,18 +182,46 @@ func StartTestBackend(name string, maxLeaseTime time.Duration) (*Services, strin
 		os.Exit(3)
 	}
 
-	smgr := stats.NewStatisticsMgr()
+	smgr := stats.NewStatisticsMgr(leaseStatisticsStore{db: db})
 
-	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr)
+	pool, err := receiver.StartPool(
+		cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr,
+		receiver.PoolOptions{
+			MaxTasks:       cfg.ReceiverMaxTasks,
+			IdleTimeout:    cfg.ReceiverIdleTimeout,
+			PayloadTimeout: cfg.ReceiverPayloadTimeout,
+			CommitTimeout:  cfg.ReceiverCommitTimeout,
+		})
 	if err != nil {
 		os.Exit(4)
 	}
 
-	services := Services{Config: cfg, Access: ac, DB: db, Pool: pool, StatsMgr: smgr}
+	gcJobs, err := NewGCJobRunner(tmp)
+	if err != nil {
+		os.Exit(6)
+	}
+
+	services := Services{
+		Config:       cfg,
+		Access:       ac,
+		DB:           db,
+		Pool:         pool,
+		StatsMgr:     smgr,
+		LeaseQueue:   NewLeaseQueue(),
+		LeaseCommits: NewLeaseCommits(),
+		GCJobs:       gcJobs,
+		Hooks:        NewHookRunner(cfg.PreCommitHooks, cfg.PostCommitHooks, cfg.HookConcurrency, cfg.HookQueueSize),
+		Nonces:       NewNonceCache(cfg.RequestTimestampSkew),
+		KeyExpiry:    NewKeyExpiryMonitor(),
+	}
 
 	if err := PopulateRepositories(&services); err != nil {
 		os.Exit(5)
 	}
 
+	if err := services.LoadManagedKeys(context.Background()); err != nil {
+		os.Exit(5)
+	}
+
 	return &services, tmp
 }
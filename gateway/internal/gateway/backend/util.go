@@ -2,15 +2,25 @@ package backend
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
 )
 
 func logAction(ctx context.Context, actionName string, outcome *string, t0 time.Time) {
+	dt := time.Since(t0)
+
 	gw.LogC(ctx, "actions", gw.LogInfo).
 		Str("action", actionName).
 		Str("outcome", *outcome).
-		Dur("action_dt", time.Since(t0)).
+		Dur("action_dt", dt).
 		Msg("action complete")
+
+	status := "error"
+	if strings.HasPrefix(*outcome, "success") {
+		status = "success"
+	}
+	metrics.ActionDuration.WithLabelValues(actionName, status).Observe(dt.Seconds())
 }
@@ -12,6 +12,14 @@ import (
 type Config struct {
 	// Port used by the HTTP frontend
 	Port int `mapstructure:"port"`
+	// TLSCertFile and TLSKeyFile are the PEM certificate and private key of
+	// the frontend. When they are set, the frontend serves HTTPS
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	// TLSClientCAFile is a PEM bundle of the CAs of the client certificates.
+	// When set, clients can authenticate with a certificate associated with a
+	// key in the access configuration, instead of HMAC
+	TLSClientCAFile string `mapstructure:"tls_client_ca_file"`
 	// MaxLeaseTime is the maximum lease duration in seconds
 	MaxLeaseTime time.Duration `mapstructure:"max_lease_time"`
 	// LogLevel sets the logging level
@@ -24,10 +32,70 @@ type Config struct {
 	NumReceivers int `mapstructure:"num_receivers"`
 	// ReceiverPath is the path of the cvmfs_receiver executable
 	ReceiverPath string `mapstructure:"receiver_path"`
+	// ReceiverMaxTasks is the number of tasks after which a receiver process
+	// is replaced by a new one (0 means unlimited)
+	ReceiverMaxTasks int `mapstructure:"receiver_max_tasks"`
+	// ReceiverIdleTimeout is the time in seconds after which an unused receiver
+	// process is replaced by a new one (0 means never)
+	ReceiverIdleTimeout time.Duration `mapstructure:"receiver_idle_timeout"`
+	// ReceiverPayloadTimeout is the time in seconds after which a payload
+	// submission is interrupted (0 means no timeout)
+	ReceiverPayloadTimeout time.Duration `mapstructure:"receiver_payload_timeout"`
+	// ReceiverCommitTimeout is the time in seconds after which a commit is
+	// interrupted (0 means no timeout)
+	ReceiverCommitTimeout time.Duration `mapstructure:"receiver_commit_timeout"`
 	// WorkDir is where the lease BD stores its data
 	WorkDir string `mapstructure:"work_dir"`
 	// MockReceiver enables a mocked implementation of the receiver worker
 	MockReceiver bool `mapstructure:"mock_receiver"`
+	// RevokedLeasePolicy is the action taken on leases whose key is no longer
+	// valid after reloading the access configuration ("report" or "cancel")
+	RevokedLeasePolicy string `mapstructure:"revoked_lease_policy"`
+	// NotificationQueueSize is the maximum number of notification messages
+	// queued for each subscriber
+	NotificationQueueSize int `mapstructure:"notification_queue_size"`
+	// NotificationSlowConsumerPolicy is the action taken when the message
+	// queue of a notification subscriber is full ("drop_oldest" or "disconnect")
+	NotificationSlowConsumerPolicy string `mapstructure:"notification_slow_consumer_policy"`
+	// RequestTimestampSkew is the maximum difference in seconds between the
+	// timestamp of a signed request and the time of the gateway
+	RequestTimestampSkew time.Duration `mapstructure:"request_timestamp_skew"`
+	// KeyExpiryWarning is the time in seconds before the expiry of a key from
+	// which warnings are logged, and the key is listed as expiring
+	KeyExpiryWarning time.Duration `mapstructure:"key_expiry_warning"`
+	// GCJobRetention is the time in seconds after which finished garbage
+	// collection jobs, and their logs, are deleted (0 means never)
+	GCJobRetention time.Duration `mapstructure:"gc_job_retention"`
+	// PreCommitHooks are run before every commit, and can reject it
+	PreCommitHooks []HookConfig `mapstructure:"pre_commit_hooks"`
+	// PostCommitHooks are run in the background after every successful commit
+	PostCommitHooks []HookConfig `mapstructure:"post_commit_hooks"`
+	// HookConcurrency is the maximum number of hooks running at the same time
+	HookConcurrency int `mapstructure:"hook_concurrency"`
+	// HookQueueSize is the maximum number of pending post-commit hooks, further
+	// hooks are dropped
+	HookQueueSize int `mapstructure:"hook_queue_size"`
+}
+
+// HookConfig describes a hook run on repository events: either an executable
+// or an HTTP webhook, which receive the event as a JSON document. A pre-commit
+// hook rejects the commit by exiting with a non-zero status or by replying with
+// a 4xx status, giving the reason in its output
+type HookConfig struct {
+	// Name identifies the hook in the logs (defaults to the command or URL)
+	Name string `mapstructure:"name"`
+	// Command is the path of an executable, run with the repository name as
+	// argument and the event on its standard input
+	Command string `mapstructure:"command"`
+	// URL of a webhook, which receives the event in a POST request
+	URL string `mapstructure:"url"`
+	// Timeout is the time limit in seconds of each attempt (0 for the default)
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Retries is the number of times a failed hook is run again. A rejection
+	// by a pre-commit hook is final
+	Retries int `mapstructure:"retries"`
+	// RetryDelay is the time in seconds between attempts (0 for the default)
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
 }
 
 // ReadConfig reads configuration files and commandline flags, and populates a Config object
@@ -36,13 +104,28 @@ func ReadConfig() (*Config, error) {
 	pflag.StringVar(&configFile, "user_config_file", "/etc/cvmfs/gateway/user.json", "config file with user modifiable settings")
 	pflag.String("access_config_file", "/etc/cvmfs/gateway/repo.json", "repository access configuration file")
 	pflag.Int("port", 4929, "HTTP frontend port")
+	pflag.String("tls_cert_file", "", "TLS certificate of the frontend (enables HTTPS)")
+	pflag.String("tls_key_file", "", "TLS private key of the frontend")
+	pflag.String("tls_client_ca_file", "", "CA bundle for the verification of TLS client certificates (enables client certificate authentication)")
 	pflag.Int("max_lease_time", 7200, "maximum lease time in seconds")
 	pflag.String("log_level", "info", "log level (debug|info|warn|error|fatal|panic)")
 	pflag.Bool("log_timestamps", false, "enable timestamps in logging output")
 	pflag.Int("num_receivers", 1, "number of parallel cvmfs_receiver processes to run")
 	pflag.String("receiver_path", "/usr/bin/cvmfs_receiver", "the path of the cvmfs_receiver executable")
+	pflag.Int("receiver_max_tasks", 1000, "number of tasks after which a cvmfs_receiver process is replaced (0 for unlimited)")
+	pflag.Int("receiver_idle_timeout", 600, "time in seconds after which an unused cvmfs_receiver process is replaced (0 for never)")
+	pflag.Int("receiver_payload_timeout", 0, "time in seconds after which a payload submission is interrupted (0 for no timeout)")
+	pflag.Int("receiver_commit_timeout", 0, "time in seconds after which a commit is interrupted (0 for no timeout)")
 	pflag.String("work_dir", "/var/lib/cvmfs-gateway", "the working directory for database files")
 	pflag.Bool("mock_receiver", false, "enable the mocked implementation of the receiver process (for testing)")
+	pflag.String("revoked_lease_policy", "report", "action on leases of revoked keys after an access configuration reload (report|cancel)")
+	pflag.Int("notification_queue_size", 1000, "maximum number of notification messages queued for each subscriber")
+	pflag.String("notification_slow_consumer_policy", "drop_oldest", "action when the queue of a notification subscriber is full (drop_oldest|disconnect)")
+	pflag.Int("request_timestamp_skew", 300, "maximum difference in seconds between the timestamp of a signed request and the gateway time")
+	pflag.Int("key_expiry_warning", 604800, "time in seconds before the expiry of a key from which warnings are logged")
+	pflag.Int("gc_job_retention", 2592000, "time in seconds after which finished garbage collection jobs and their logs are deleted (0 for never)")
+	pflag.Int("hook_concurrency", 4, "maximum number of hooks running at the same time")
+	pflag.Int("hook_queue_size", 1000, "maximum number of pending post-commit hooks")
 	pflag.Parse()
 
 	viper.SetConfigFile(configFile)
@@ -56,6 +139,71 @@ func ReadConfig() (*Config, error) {
 
 	// max_lease_time is given in seconds in the config file or at the command line
 	conf.MaxLeaseTime = conf.MaxLeaseTime * time.Second
+	conf.ReceiverIdleTimeout = conf.ReceiverIdleTimeout * time.Second
+	conf.ReceiverPayloadTimeout = conf.ReceiverPayloadTimeout * time.Second
+	conf.ReceiverCommitTimeout = conf.ReceiverCommitTimeout * time.Second
+	conf.RequestTimestampSkew = conf.RequestTimestampSkew * time.Second
+	conf.KeyExpiryWarning = conf.KeyExpiryWarning * time.Second
+	conf.GCJobRetention = conf.GCJobRetention * time.Second
+
+	if (conf.TLSCertFile == "") != (conf.TLSKeyFile == "") {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file must be given together")
+	}
+	if conf.TLSClientCAFile != "" && conf.TLSCertFile == "" {
+		return nil, fmt.Errorf("tls_client_ca_file requires tls_cert_file and tls_key_file")
+	}
+
+	if conf.ReceiverMaxTasks < 0 {
+		return nil, fmt.Errorf("invalid receiver_max_tasks: %v", conf.ReceiverMaxTasks)
+	}
+	if conf.ReceiverIdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid receiver_idle_timeout: %v", conf.ReceiverIdleTimeout)
+	}
+	if conf.ReceiverPayloadTimeout < 0 {
+		return nil, fmt.Errorf("invalid receiver_payload_timeout: %v", conf.ReceiverPayloadTimeout)
+	}
+	if conf.ReceiverCommitTimeout < 0 {
+		return nil, fmt.Errorf("invalid receiver_commit_timeout: %v", conf.ReceiverCommitTimeout)
+	}
+
+	if conf.RevokedLeasePolicy != "report" && conf.RevokedLeasePolicy != "cancel" {
+		return nil, fmt.Errorf("invalid revoked_lease_policy: %v", conf.RevokedLeasePolicy)
+	}
+
+	if conf.NotificationQueueSize <= 0 {
+		return nil, fmt.Errorf("invalid notification_queue_size: %v", conf.NotificationQueueSize)
+	}
+
+	if conf.NotificationSlowConsumerPolicy != "drop_oldest" &&
+		conf.NotificationSlowConsumerPolicy != "disconnect" {
+		return nil, fmt.Errorf(
+			"invalid notification_slow_consumer_policy: %v", conf.NotificationSlowConsumerPolicy)
+	}
+
+	if conf.RequestTimestampSkew <= 0 {
+		return nil, fmt.Errorf("invalid request_timestamp_skew: %v", conf.RequestTimestampSkew)
+	}
+
+	if conf.KeyExpiryWarning < 0 {
+		return nil, fmt.Errorf("invalid key_expiry_warning: %v", conf.KeyExpiryWarning)
+	}
+
+	if conf.GCJobRetention < 0 {
+		return nil, fmt.Errorf("invalid gc_job_retention: %v", conf.GCJobRetention)
+	}
+
+	if conf.HookConcurrency <= 0 {
+		return nil, fmt.Errorf("invalid hook_concurrency: %v", conf.HookConcurrency)
+	}
+	if conf.HookQueueSize <= 0 {
+		return nil, fmt.Errorf("invalid hook_queue_size: %v", conf.HookQueueSize)
+	}
+	if err := prepareHooks(conf.PreCommitHooks); err != nil {
+		return nil, fmt.Errorf("invalid pre_commit_hooks: %w", err)
+	}
+	if err := prepareHooks(conf.PostCommitHooks); err != nil {
+		return nil, fmt.Errorf("invalid post_commit_hooks: %w", err)
+	}
 
 	// Manually handler legacy parameter names
 
@@ -91,3 +239,23 @@ func ReadConfig() (*Config, error) {
 
 	return &conf, nil
 }
+
+// prepareHooks validates the hook configurations, fills in the default names
+// and converts the times given in seconds
+func prepareHooks(hooks []HookConfig) error {
+	for i := range hooks {
+		h := &hooks[i]
+		if (h.Command == "") == (h.URL == "") {
+			return fmt.Errorf("hook %v: exactly one of command and url must be given", i)
+		}
+		if h.Name == "" {
+			h.Name = h.Command + h.URL
+		}
+		if h.Timeout < 0 || h.Retries < 0 || h.RetryDelay < 0 {
+			return fmt.Errorf("hook %v: negative timeout, retries or retry_delay", h.Name)
+		}
+		h.Timeout = h.Timeout * time.Second
+		h.RetryDelay = h.RetryDelay * time.Second
+	}
+	return nil
+}
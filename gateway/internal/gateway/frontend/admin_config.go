@@ -0,0 +1,29 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeAdminConfigHandler creates an HTTP handler for reloading the repository
+// access configuration
+func MakeAdminConfigHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		msg := map[string]interface{}{"status": "ok"}
+		if report, err := services.ReloadAccessConfig(ctx); err != nil {
+			msg["status"] = "error"
+			msg["reason"] = err.Error()
+		} else {
+			msg["data"] = report
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeAuditHandler creates an HTTP handler for the "/audit" endpoint, which
+// queries the audit log. The records can be filtered with the "repository",
+// "key_id", "from" and "to" (RFC 3339 times) query parameters, and their
+// number capped with "limit"
+func MakeAuditHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		filter, err := parseAuditFilter(h)
+		if err != nil {
+			httpWrapError(ctx, err, err.Error(), w, http.StatusBadRequest)
+			return
+		}
+
+		msg := map[string]interface{}{"status": "ok"}
+		if records, err := services.GetAuditRecords(ctx, filter); err != nil {
+			msg["status"] = "error"
+			msg["reason"] = err.Error()
+		} else {
+			msg["data"] = records
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
+
+func parseAuditFilter(h *http.Request) (be.AuditFilter, error) {
+	query := h.URL.Query()
+	filter := be.AuditFilter{
+		Repository: query.Get("repository"),
+		KeyID:      query.Get("key_id"),
+	}
+
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %v time: %v", name, v)
+			}
+			*t = parsed
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("invalid limit: %v", v)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,67 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAuditHandler(t *testing.T) {
+	backend := mockBackend{}
+	t.Run("query", func(t *testing.T) {
+		req := httptest.NewRequest(
+			"GET", "/api/v1/audit?repository=test2.repo.org&from=2030-01-01T00:00:00Z&limit=1", nil)
+		w := httptest.NewRecorder()
+		handler := MakeAuditHandler(&backend)
+		handler(w, req, httprouter.Params{})
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   []struct {
+				ID     int64  `json:"id"`
+				Action string `json:"action"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if resp.Status != "ok" || len(resp.Data) != 1 || resp.Data[0].Action != "commit_lease" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("invalid time", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/audit?from=yesterday", nil)
+		w := httptest.NewRecorder()
+		handler := MakeAuditHandler(&backend)
+		handler(w, req, httprouter.Params{})
+
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Errorf("Invalid HTTP response status code: %v", w.Result().StatusCode)
+		}
+	})
+}
+
+func TestAuthorizationMiddlewareKeyIDInContext(t *testing.T) {
+	backend := mockBackend{}
+	var keyID string
+	next := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		keyID, _ = req.Context().Value(gw.KeyIDKey).(string)
+	}
+
+	reqBody := []byte("hello")
+	HMAC := ComputeHMAC(reqBody, backend.GetKey(context.TODO(), "keyid2").Secret)
+	req := httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+	req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+	WithAuthz(&backend, next)(httptest.NewRecorder(), req, httprouter.Params{})
+
+	if keyID != "keyid2" {
+		t.Errorf("Invalid key ID in request context: %q", keyID)
+	}
+}
@@ -2,13 +2,16 @@ package frontend
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -23,7 +26,35 @@ type message map[string]interface{}
 func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		ctx := req.Context()
-		keyID, HMAC, err := parseHeader(&req.Header)
+
+		if subject, ok := clientCertSubject(req); ok {
+			keyID := ac.GetKeyIDByCertSubject(ctx, subject)
+			keyCfg := ac.GetKey(ctx, keyID)
+			if keyID == "" || keyCfg == nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Str("subject", subject).
+					Msg("no key for client certificate")
+				replyJSON(ctx, w, message{"status": "error", "reason": "invalid_client_cert"})
+				return
+			}
+			if !keyCfg.Admin {
+				gw.LogC(ctx, "http", gw.LogError).
+					Msg("key does not have admin rights")
+				replyJSON(ctx, w, message{"status": "error", "reason": "no_admin_key"})
+				return
+			}
+			if err := keyCfg.CheckValidity(time.Now()); err != nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Err(err).
+					Msg("key outside of its validity window")
+				replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+				return
+			}
+			next(w, req.WithContext(context.WithValue(ctx, gw.KeyIDKey, keyID)), ps)
+			return
+		}
+
+		scheme, keyID, HMAC, err := parseHeader(&req.Header)
 		if err != nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Err(err).
@@ -49,8 +80,8 @@ func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.H
 
 		var HMACInput []byte
 		switch req.Method {
-		case "DELETE":
-			// For DELETE requests, use the path component of the URL to compute the HMAC
+		case "GET", "DELETE":
+			// For GET and DELETE requests, use the path component of the URL to compute the HMAC
 			HMACInput = []byte(req.URL.Path)
 		case "POST":
 			// For POST requests, the request body is used to compute HMAC
@@ -64,24 +95,95 @@ func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.H
 			gw.LogC(ctx, "http", gw.LogError).
 				Msgf(msg)
 			http.Error(w, msg, http.StatusMethodNotAllowed)
+			return
 		}
 
-		if !CheckHMAC(HMACInput, HMAC, keyCfg.Secret) {
+		timestamp, nonce, protected := replayProtection(req)
+		if protected {
+			body, err := readSignedBody(req)
+			if err != nil {
+				httpWrapError(ctx, err, "could not read request body", w, http.StatusBadRequest)
+				return
+			}
+			HMACInput = signingInput(req, timestamp, nonce, body)
+		}
+
+		// Administrative requests do not carry an API protocol version, so they
+		// are signed with HMAC-SHA1 unless another scheme is given
+		algorithm := scheme
+		if algorithm == "" {
+			algorithm = HMACSHA1
+		}
+
+		// Keys without a secret can only be used with a client certificate
+		if !checkKeyHMAC(keyCfg, algorithm, HMACInput, HMAC) {
 			gw.LogC(ctx, "http", gw.LogError).
+				Str("algorithm", algorithm).
 				Msg("invalid HMAC")
 			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
 			return
 		}
 
-		next(w, req, ps)
+		// The validity window of the key is only checked once the request is
+		// authenticated, so that it is not revealed to other clients
+		if err := keyCfg.CheckValidity(time.Now()); err != nil {
+			gw.LogC(ctx, "http", gw.LogError).
+				Err(err).
+				Msg("key outside of its validity window")
+			replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+			return
+		}
+
+		if algorithm == HMACSHA1 && keyCfg.RejectSHA1 {
+			gw.LogC(ctx, "http", gw.LogError).
+				Msg("HMAC-SHA1 not allowed for key")
+			replyJSON(ctx, w, message{"status": "error", "reason": "sha1_not_allowed"})
+			return
+		}
+
+		if protected {
+			if err := checkReplay(ctx, ac, keyID, timestamp, nonce); err != nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Err(err).
+					Msg("request timestamp or nonce refused")
+				replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+				return
+			}
+		}
+
+		next(w, req.WithContext(context.WithValue(ctx, gw.KeyIDKey, keyID)), ps)
 	}
 }
 
-// WithAuthz returns an HMAC authorization middleware
+// WithAuthz returns an HMAC authorization middleware. Requests without an
+// Authorization header can instead be authenticated by a TLS client
+// certificate associated with a key
 func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		ctx := req.Context()
-		keyID, HMAC, err := parseHeader(&req.Header)
+
+		if subject, ok := clientCertSubject(req); ok {
+			keyID := ac.GetKeyIDByCertSubject(ctx, subject)
+			keyCfg := ac.GetKey(ctx, keyID)
+			if keyID == "" || keyCfg == nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Str("subject", subject).
+					Msg("no key for client certificate")
+				replyJSON(ctx, w, message{"status": "error", "reason": "invalid_client_cert"})
+				return
+			}
+			if err := keyCfg.CheckValidity(time.Now()); err != nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Err(err).
+					Msg("key outside of its validity window")
+				replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+				return
+			}
+			next(w, req.WithContext(context.WithValue(ctx, gw.KeyIDKey, keyID)), ps)
+			return
+		}
+
+		scheme, keyID, HMAC, err := parseHeader(&req.Header)
 		if err != nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Err(err).
@@ -115,6 +217,13 @@ func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle
 					return
 				}
 			}
+		} else if strings.HasPrefix(req.URL.Path, APIRoot+"/notifications") {
+			// For notification publishing requests, the request body is used to compute HMAC
+			HMACInput, err = readBody(req, req.ContentLength)
+			if err != nil {
+				httpWrapError(ctx, err, "could not read request body", w, http.StatusInternalServerError)
+				return
+			}
 		} else if strings.HasPrefix(req.URL.Path, APIRoot+"/payloads") {
 			token := ps.ByName("token")
 			if token != "" {
@@ -137,13 +246,96 @@ func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle
 			}
 		}
 
-		if !CheckHMAC(HMACInput, HMAC, keyCfg.Secret) {
+		// Without a scheme in the Authorization header, the HMAC algorithm is
+		// given by the protocol version of the client. The version of a request
+		// on a lease is only known from the lease, which is looked up once the
+		// request is authenticated, so both algorithms are tried until then
+		scope := findRequestScope(HMACInput, ps.ByName("token"))
+		algorithms := []string{scheme}
+		if scheme == "" {
+			if scope.token == "" || scope.version != 0 {
+				algorithms = []string{HMACAlgorithmForVersion(scope.version)}
+			} else {
+				algorithms = []string{HMACSHA256, HMACSHA1}
+			}
+		}
+
+		// With a timestamp and a nonce, the HMAC covers the method, the path
+		// and the body of the request, instead of the token or message alone
+		timestamp, nonce, protected := replayProtection(req)
+		if protected {
+			body, err := readSignedBody(req)
+			if err != nil {
+				httpWrapError(ctx, err, "could not read request body", w, http.StatusBadRequest)
+				return
+			}
+			HMACInput = signingInput(req, timestamp, nonce, body)
+		}
+
+		// Keys without a secret can only be used with a client certificate
+		algorithm := ""
+		for _, a := range algorithms {
+			if checkKeyHMAC(keyCfg, a, HMACInput, HMAC) {
+				algorithm = a
+				break
+			}
+		}
+		if algorithm == "" {
 			gw.LogC(ctx, "http", gw.LogError).
+				Strs("algorithms", algorithms).
 				Msg("invalid HMAC")
 			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
 			return
 		}
-		next(w, req, ps)
+
+		// The validity window of the key is only checked once the request is
+		// authenticated, so that it is not revealed to other clients
+		if err := keyCfg.CheckValidity(time.Now()); err != nil {
+			gw.LogC(ctx, "http", gw.LogError).
+				Err(err).
+				Msg("key outside of its validity window")
+			replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+			return
+		}
+
+		// Once the request is authenticated, the lease gives the protocol
+		// version, which must match the HMAC algorithm, and the repository
+		scope.resolveLease(ctx, ac)
+		if scheme == "" && algorithm != HMACAlgorithmForVersion(scope.version) {
+			gw.LogC(ctx, "http", gw.LogError).
+				Str("algorithm", algorithm).
+				Int("version", scope.version).
+				Msg("HMAC algorithm does not match the protocol version")
+			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
+			return
+		}
+
+		if algorithm == HMACSHA1 {
+			rejected, err := rejectsSHA1(ctx, ac, keyCfg, scope.repository)
+			if err != nil {
+				httpWrapError(ctx, err, "could not retrieve repository information", w, http.StatusInternalServerError)
+				return
+			}
+			if rejected {
+				gw.LogC(ctx, "http", gw.LogError).
+					Str("repository", scope.repository).
+					Msg("HMAC-SHA1 not allowed for key or repository")
+				replyJSON(ctx, w, message{"status": "error", "reason": "sha1_not_allowed"})
+				return
+			}
+		}
+
+		if protected {
+			if err := checkReplay(ctx, ac, keyID, timestamp, nonce); err != nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Err(err).
+					Msg("request timestamp or nonce refused")
+				replyJSON(ctx, w, message{"status": "error", "reason": err.Error()})
+				return
+			}
+		}
+
+		next(w, req.WithContext(context.WithValue(ctx, gw.KeyIDKey, keyID)), ps)
 	}
 }
 
@@ -167,20 +359,116 @@ func (r recombineReadCloser) Close() error {
 	return r.original.Close()
 }
 
-func parseHeader(h *http.Header) (string, []byte, error) {
+// requestScope is what the authorization middleware can find out about a
+// publishing request: the API protocol version of the client and the
+// repository. Both are left empty if they can not be determined
+type requestScope struct {
+	version    int
+	repository string
+	// token is the session token of a request on a lease, whose version and
+	// repository are given by the lease
+	token string
+}
+
+// findRequestScope determines the scope of a request from the JSON message
+// which is signed by the client, or from the session token of the route. The
+// lease of the token is not looked up, see resolveLease
+func findRequestScope(signed []byte, token string) requestScope {
+	scope := requestScope{token: token}
+
+	if token == "" {
+		var msg struct {
+			Version    json.Number `json:"api_version"` // sent as a string by cvmfs_swissknife
+			Path       string      `json:"path"`
+			Repository string      `json:"repository"`
+			Token      string      `json:"session_token"`
+		}
+		if err := json.Unmarshal(signed, &msg); err != nil {
+			return scope
+		}
+		if v, err := strconv.Atoi(string(msg.Version)); err == nil {
+			scope.version = v
+		}
+		scope.repository = msg.Repository
+		if repo, _, err := gw.SplitLeasePath(msg.Path); err == nil {
+			scope.repository = repo
+		}
+		scope.token = msg.Token
+	}
+
+	return scope
+}
+
+// resolveLease completes the scope of a request on a lease with the lease of
+// its session token. It is only called once the request is authenticated, so
+// that unauthenticated clients can't make the gateway query the lease database
+func (scope *requestScope) resolveLease(ctx context.Context, ac be.ActionController) {
+	if scope.token == "" {
+		return
+	}
+
+	lease, err := ac.GetLease(ctx, scope.token)
+	if err != nil {
+		return
+	}
+	if scope.version == 0 {
+		scope.version = lease.ProtocolVersion
+	}
+	if repo, _, err := gw.SplitLeasePath(lease.LeasePath); err == nil {
+		scope.repository = repo
+	}
+}
+
+// checkKeyHMAC verifies the HMAC of a request with the secret of the key. The
+// previous secret of a rotated key is also accepted during the overlap
+func checkKeyHMAC(keyCfg *be.KeyConfig, algorithm string, input, HMAC []byte) bool {
+	if keyCfg.Secret != "" && CheckHMACWith(algorithm, input, HMAC, keyCfg.Secret) {
+		return true
+	}
+	return keyCfg.PreviousSecret != "" && CheckHMACWith(algorithm, input, HMAC, keyCfg.PreviousSecret)
+}
+
+// rejectsSHA1 returns true if the key or the repository of a request refuse
+// requests signed with HMAC-SHA1
+func rejectsSHA1(ctx context.Context, ac be.ActionController, keyCfg *be.KeyConfig, repository string) (bool, error) {
+	if keyCfg.RejectSHA1 {
+		return true, nil
+	}
+	if repository == "" {
+		return false, nil
+	}
+	repoCfg, err := ac.GetRepo(ctx, repository)
+	if err != nil {
+		return false, err
+	}
+	return repoCfg != nil && repoCfg.RejectSHA1, nil
+}
+
+// parseHeader splits the Authorization header into the HMAC scheme, the key ID
+// and the HMAC. The scheme is optional ("[scheme] keyID HMAC"), an empty
+// scheme is returned if it is missing
+func parseHeader(h *http.Header) (string, string, []byte, error) {
 	tokens := strings.Split(h.Get("Authorization"), " ")
-	if len(tokens) != 2 {
-		return "", nil, fmt.Errorf("missing tokens in authoriation header")
+	scheme := ""
+	switch len(tokens) {
+	case 2:
+	case 3:
+		scheme, tokens = tokens[0], tokens[1:]
+		if !IsHMACAlgorithm(scheme) {
+			return "", "", nil, fmt.Errorf("unknown HMAC scheme: %v", scheme)
+		}
+	default:
+		return "", "", nil, fmt.Errorf("missing tokens in authoriation header")
 	}
 
 	keyID := tokens[0]
 
 	HMAC, err := base64.StdEncoding.DecodeString(tokens[1])
 	if err != nil {
-		return "", nil, fmt.Errorf("could not base64 decode HMAC: %w", err)
+		return "", "", nil, fmt.Errorf("could not base64 decode HMAC: %w", err)
 	}
 
-	return keyID, HMAC, nil
+	return scheme, keyID, HMAC, nil
 }
 
 // Read the request body and place and resets the consumed Reader, allowing the
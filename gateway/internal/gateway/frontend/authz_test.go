@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"sync/atomic"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
@@ -262,6 +263,31 @@ func TestAdminAuthorizationMiddleware(t *testing.T) {
 			t.Errorf("Invalid response body: %v", string(respBody))
 		}
 	})
+	t.Run("GET", func(t *testing.T) {
+		HMAC := ComputeHMAC([]byte("/api/v1/gc/jobs"), backend.GetKey(context.TODO(), "admin0").Secret)
+		req := httptest.NewRequest("GET", "/api/v1/gc/jobs", nil)
+		ps := httprouter.Params{}
+
+		req.Header["Authorization"] = []string{"admin0 " + base64.StdEncoding.EncodeToString(HMAC)}
+		w := httptest.NewRecorder()
+		handler := WithAdminAuthz(&backend, forwardBody)
+
+		handler(w, req, ps)
+
+		resp := w.Result()
+
+		if resp.StatusCode != 200 {
+			t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("Could not read response body")
+		}
+		if len(respBody) != 0 {
+			t.Errorf("Invalid response body: %v", string(respBody))
+		}
+	})
 	t.Run("POST", func(t *testing.T) {
 		msg := []byte("hello")
 
@@ -290,3 +316,262 @@ func TestAdminAuthorizationMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestAuthorizationMiddlewarePublishNotification(t *testing.T) {
+	backend := mockBackend{}
+	t.Run("POST /notifications/publish OK", func(t *testing.T) {
+		reqBody := []byte(`{"repository":"test2.repo.org"}`)
+		HMAC := ComputeHMAC(reqBody, backend.GetKey(context.TODO(), "keyid1").Secret)
+		req := httptest.NewRequest("POST", "/api/v1/notifications/publish", bytes.NewReader(reqBody))
+		req.Header["Authorization"] = []string{"keyid1 " + base64.StdEncoding.EncodeToString(HMAC)}
+		w := httptest.NewRecorder()
+		handler := WithAuthz(&backend, forwardBody)
+
+		handler(w, req, httprouter.Params{})
+
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		if !bytes.Equal(reqBody, respBody) {
+			t.Errorf("Invalid response body: %v", string(respBody))
+		}
+	})
+	t.Run("POST /notifications/publish invalid HMAC", func(t *testing.T) {
+		reqBody := []byte(`{"repository":"test2.repo.org"}`)
+		req := httptest.NewRequest("POST", "/api/v1/notifications/publish", bytes.NewReader(reqBody))
+		req.Header["Authorization"] = []string{"keyid1 " + base64.StdEncoding.EncodeToString([]byte("rubbish"))}
+		w := httptest.NewRecorder()
+		handler := WithAuthz(&backend, forwardBody)
+
+		handler(w, req, httprouter.Params{})
+
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		if !bytes.Equal([]byte("{\"reason\":\"invalid_hmac\",\"status\":\"error\"}"), respBody) {
+			t.Errorf("Invalid response body: %v", string(respBody))
+		}
+	})
+}
+
+func TestAuthorizationMiddlewareHMACAlgorithms(t *testing.T) {
+	backend := mockBackend{}
+	secret := backend.GetKey(context.TODO(), "keyid2").Secret
+	ok := "ok"
+	invalidHMAC := "{\"reason\":\"invalid_hmac\",\"status\":\"error\"}"
+	sha1NotAllowed := "{\"reason\":\"sha1_not_allowed\",\"status\":\"error\"}"
+
+	// authorize returns "ok" if the request passes the middleware, or the reply
+	authorize := func(path string, body []byte, token, header string) string {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header["Authorization"] = []string{header}
+		ps := httprouter.Params{}
+		if token != "" {
+			ps = httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+		}
+		w := httptest.NewRecorder()
+		passed := false
+		next := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			passed = true
+		}
+		WithAuthz(&backend, next)(w, req, ps)
+		if passed {
+			return ok
+		}
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		return string(respBody)
+	}
+	newLease := func(version, path string) []byte {
+		msg, _ := json.Marshal(map[string]string{"path": path, "api_version": version})
+		return msg
+	}
+	sign := func(algorithm, scheme, keyID string, input []byte) string {
+		header := keyID + " " + base64.StdEncoding.EncodeToString(ComputeHMACWith(algorithm, input, secret))
+		if scheme != "" {
+			header = scheme + " " + header
+		}
+		return header
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		body     []byte
+		token    string
+		header   string
+		expected string
+	}{
+		{"scheme prefix SHA-256", "/api/v1/leases", []byte("hello"), "",
+			sign(HMACSHA256, HMACSHA256, "keyid2", []byte("hello")), ok},
+		{"scheme prefix SHA-1", "/api/v1/leases", []byte("hello"), "",
+			sign(HMACSHA1, HMACSHA1, "keyid2", []byte("hello")), ok},
+		{"scheme prefix mismatch", "/api/v1/leases", []byte("hello"), "",
+			sign(HMACSHA1, HMACSHA256, "keyid2", []byte("hello")), invalidHMAC},
+		{"unknown scheme", "/api/v1/leases", []byte("hello"), "",
+			sign(HMACSHA256, "HMAC-MD5", "keyid2", []byte("hello")), invalidHMAC},
+		{"protocol 3 lease request", "/api/v1/leases", newLease("3", "test2.repo.org/path"), "",
+			sign(HMACSHA1, "", "keyid2", newLease("3", "test2.repo.org/path")), ok},
+		{"protocol 4 lease request", "/api/v1/leases", newLease("4", "test2.repo.org/path"), "",
+			sign(HMACSHA256, "", "keyid2", newLease("4", "test2.repo.org/path")), ok},
+		{"protocol 4 lease request signed with SHA-1", "/api/v1/leases", newLease("4", "test2.repo.org/path"), "",
+			sign(HMACSHA1, "", "keyid2", newLease("4", "test2.repo.org/path")), invalidHMAC},
+		{"protocol 3 lease commit", "/api/v1/leases/token", nil, "token",
+			sign(HMACSHA1, "", "keyid2", []byte("token")), ok},
+		{"protocol 4 lease commit", "/api/v1/leases/v4token", nil, "v4token",
+			sign(HMACSHA256, "", "keyid2", []byte("v4token")), ok},
+		{"protocol 4 lease commit signed with SHA-1", "/api/v1/leases/v4token", nil, "v4token",
+			sign(HMACSHA1, "", "keyid2", []byte("v4token")), invalidHMAC},
+		{"protocol 3 lease commit signed with SHA-256", "/api/v1/leases/token", nil, "token",
+			sign(HMACSHA256, "", "keyid2", []byte("token")), invalidHMAC},
+		{"key refusing SHA-1", "/api/v1/leases", newLease("3", "test2.repo.org/path"), "",
+			sign(HMACSHA1, "", "strict1", newLease("3", "test2.repo.org/path")), sha1NotAllowed},
+		{"key refusing SHA-1 with SHA-256", "/api/v1/leases", newLease("3", "test2.repo.org/path"), "",
+			sign(HMACSHA256, HMACSHA256, "strict1", newLease("3", "test2.repo.org/path")), ok},
+		{"repository refusing SHA-1", "/api/v1/leases", newLease("3", "strict.repo.org/path"), "",
+			sign(HMACSHA1, "", "keyid2", newLease("3", "strict.repo.org/path")), sha1NotAllowed},
+		{"repository refusing SHA-1 with SHA-256", "/api/v1/leases", newLease("4", "strict.repo.org/path"), "",
+			sign(HMACSHA256, "", "keyid2", newLease("4", "strict.repo.org/path")), ok},
+		{"repository of lease refusing SHA-1", "/api/v1/leases/stricttoken", nil, "stricttoken",
+			sign(HMACSHA1, "", "keyid2", []byte("stricttoken")), sha1NotAllowed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if resp := authorize(c.path, c.body, c.token, c.header); resp != c.expected {
+				t.Errorf("Invalid response: %v", resp)
+			}
+		})
+	}
+
+	t.Run("lease looked up after authentication", func(t *testing.T) {
+		atomic.StoreInt32(&backend.leaseLookups, 0)
+		resp := authorize("/api/v1/leases/v4token", nil, "v4token", sign(HMACSHA256, "", "keyid2", []byte("other")))
+		if resp != invalidHMAC {
+			t.Errorf("Invalid response: %v", resp)
+		}
+		if n := atomic.LoadInt32(&backend.leaseLookups); n != 0 {
+			t.Errorf("lease looked up %v times for an unauthenticated request", n)
+		}
+	})
+
+	t.Run("admin requests", func(t *testing.T) {
+		path := "/api/v1/repos/test1.repo.org"
+		admin := func(header string) string {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header["Authorization"] = []string{header}
+			w := httptest.NewRecorder()
+			passed := false
+			next := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+				passed = true
+			}
+			WithAdminAuthz(&backend, next)(w, req, httprouter.Params{})
+			if passed {
+				return ok
+			}
+			respBody, _ := ioutil.ReadAll(w.Result().Body)
+			return string(respBody)
+		}
+
+		if resp := admin(sign(HMACSHA1, "", "admin1", []byte(path))); resp != ok {
+			t.Errorf("Invalid response: %v", resp)
+		}
+		if resp := admin(sign(HMACSHA256, HMACSHA256, "admin1", []byte(path))); resp != ok {
+			t.Errorf("Invalid response: %v", resp)
+		}
+		if resp := admin(sign(HMACSHA1, "", "adminstrict1", []byte(path))); resp != sha1NotAllowed {
+			t.Errorf("Invalid response: %v", resp)
+		}
+		if resp := admin(sign(HMACSHA256, HMACSHA256, "adminstrict1", []byte(path))); resp != ok {
+			t.Errorf("Invalid response: %v", resp)
+		}
+	})
+}
+
+func TestAuthorizationMiddlewareRotatedKey(t *testing.T) {
+	backend := mockBackend{}
+	reqBody := []byte("hello")
+	path := "/api/v1/repos/test1.repo.org"
+
+	// authorize returns true if the request passes the middleware
+	authorize := func(admin bool, keyID, secret string) bool {
+		var req *http.Request
+		var HMAC []byte
+		if admin {
+			req = httptest.NewRequest("GET", path, nil)
+			HMAC = ComputeHMAC([]byte(path), secret)
+		} else {
+			req = httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+			HMAC = ComputeHMAC(reqBody, secret)
+		}
+		req.Header["Authorization"] = []string{keyID + " " + base64.StdEncoding.EncodeToString(HMAC)}
+		passed := false
+		next := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			passed = true
+		}
+		if admin {
+			WithAdminAuthz(&backend, next)(httptest.NewRecorder(), req, httprouter.Params{})
+		} else {
+			WithAuthz(&backend, next)(httptest.NewRecorder(), req, httprouter.Params{})
+		}
+		return passed
+	}
+
+	for _, admin := range []bool{false, true} {
+		t.Run("admin "+strconv.FormatBool(admin), func(t *testing.T) {
+			if !authorize(admin, "adminrotated1", "big_secret") {
+				t.Errorf("current secret of rotated key refused")
+			}
+			if !authorize(admin, "adminrotated1", "old_secret") {
+				t.Errorf("previous secret of rotated key refused during the overlap")
+			}
+			if authorize(admin, "admin1", "old_secret") {
+				t.Errorf("previous secret accepted for key which was not rotated")
+			}
+		})
+	}
+}
+
+func TestAuthorizationMiddlewareKeyValidity(t *testing.T) {
+	backend := mockBackend{}
+	secret := backend.GetKey(context.TODO(), "keyid2").Secret
+	reqBody := []byte("hello")
+	path := "/api/v1/repos/test1.repo.org"
+
+	// authorize returns "ok" if the request passes the middleware, or the reply
+	authorize := func(admin bool, keyID string) string {
+		var req *http.Request
+		var HMAC []byte
+		if admin {
+			req = httptest.NewRequest("GET", path, nil)
+			HMAC = ComputeHMAC([]byte(path), secret)
+		} else {
+			req = httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+			HMAC = ComputeHMAC(reqBody, secret)
+		}
+		req.Header["Authorization"] = []string{keyID + " " + base64.StdEncoding.EncodeToString(HMAC)}
+		w := httptest.NewRecorder()
+		passed := false
+		next := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			passed = true
+		}
+		if admin {
+			WithAdminAuthz(&backend, next)(w, req, httprouter.Params{})
+		} else {
+			WithAuthz(&backend, next)(w, req, httprouter.Params{})
+		}
+		if passed {
+			return "ok"
+		}
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		return string(respBody)
+	}
+
+	for _, admin := range []bool{false, true} {
+		t.Run("admin "+strconv.FormatBool(admin), func(t *testing.T) {
+			if resp := authorize(admin, "admin1"); resp != "ok" {
+				t.Errorf("Invalid response: %v", resp)
+			}
+			if resp := authorize(admin, "adminexpired1"); resp != "{\"reason\":\"key_expired\",\"status\":\"error\"}" {
+				t.Errorf("Invalid response: %v", resp)
+			}
+			if resp := authorize(admin, "adminfuture1"); resp != "{\"reason\":\"key_not_yet_valid\",\"status\":\"error\"}" {
+				t.Errorf("Invalid response: %v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeCommitsHandler creates an HTTP handler for the "/repos/:name/commits"
+// endpoint, which returns the commit history of a repository, most recent
+// first. The history is paginated with the "offset" and "limit" query
+// parameters
+func MakeCommitsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		var offset, limit int
+		for name, v := range map[string]*int{"offset": &offset, "limit": &limit} {
+			if p := h.URL.Query().Get(name); p != "" {
+				var err error
+				if *v, err = strconv.Atoi(p); err != nil || *v < 0 {
+					msg := fmt.Sprintf("invalid %v: %v", name, p)
+					httpWrapError(ctx, err, msg, w, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		msg := map[string]interface{}{"status": "ok"}
+		if history, err := services.GetCommitHistory(ctx, ps.ByName("name"), offset, limit); err != nil {
+			msg["status"] = "error"
+			msg["reason"] = err.Error()
+		} else {
+			msg["data"] = history
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
@@ -0,0 +1,60 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCommitsHandler(t *testing.T) {
+	backend := mockBackend{}
+	query := func(url, repo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		handler := MakeCommitsHandler(&backend)
+		handler(w, req, httprouter.Params{httprouter.Param{Key: "name", Value: repo}})
+		return w
+	}
+
+	t.Run("page", func(t *testing.T) {
+		w := query("/api/v1/repos/test2.repo.org/commits?offset=1&limit=1", "test2.repo.org")
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   struct {
+				Total   int `json:"total"`
+				Commits []struct {
+					Revision uint64 `json:"revision"`
+				} `json:"commits"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if resp.Status != "ok" || resp.Data.Total != 2 ||
+			len(resp.Data.Commits) != 1 || resp.Data.Commits[0].Revision != 1 {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("invalid repository", func(t *testing.T) {
+		w := query("/api/v1/repos/test1.repo.org/commits", "test1.repo.org")
+
+		var resp map[string]interface{}
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if resp["status"] != "error" || resp["reason"] != "invalid_repo" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("invalid offset", func(t *testing.T) {
+		w := query("/api/v1/repos/test2.repo.org/commits?offset=-1", "test2.repo.org")
+
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Errorf("Invalid HTTP response status code: %v", w.Result().StatusCode)
+		}
+	})
+}
@@ -37,13 +37,19 @@ func NewFrontend(services be.ActionController, port int, timeout time.Duration)
 	// Repositories
 	router.GET(APIRoot+"/repos", tag(MakeReposHandler(services)))
 	router.GET(APIRoot+"/repos/:name", tag(MakeReposHandler(services)))
+	router.GET(APIRoot+"/repos/:name/commits", tag(MakeCommitsHandler(services)))
 
 	// Leases
 	router.GET(APIRoot+"/leases", tag(MakeLeasesHandler(services)))
 	router.GET(APIRoot+"/leases/:token", tag(MakeLeasesHandler(services)))
 	router.POST(APIRoot+"/leases", mw(MakeLeasesHandler(services)))
 	router.POST(APIRoot+"/leases/:token", mw(MakeLeasesHandler(services)))
+	router.PUT(APIRoot+"/leases/:token", mw(MakeLeasesHandler(services)))
 	router.DELETE(APIRoot+"/leases/:token", mw(MakeLeasesHandler(services)))
+	router.GET(APIRoot+"/lease-queue", tag(MakeLeaseQueueHandler(services)))
+
+	// Receiver worker health
+	router.GET(APIRoot+"/receivers", tag(MakeReceiversHandler(services)))
 
 	// Payloads (legacy endpoint)
 	router.POST(APIRoot+"/payloads", mw(MakePayloadsHandler(services)))
@@ -51,13 +57,30 @@ func NewFrontend(services be.ActionController, port int, timeout time.Duration)
 	router.POST(APIRoot+"/payloads/:token", mw(MakePayloadsHandler(services)))
 
 	// Notification system endpoints
-	router.POST(APIRoot+"/notifications/publish", tag(MakeNotificationsHandler(services)))
+	router.POST(APIRoot+"/notifications/publish", mw(MakeNotificationsHandler(services)))
 	router.GET(APIRoot+"/notifications/subscribe", tag(MakeNotificationsHandler(services)))
 
+	// Metrics (not tagged, to avoid logging every scrape)
+	router.GET("/metrics", MakeMetricsHandler(services))
+
 	// Admin routes
 	router.POST(APIRoot+"/repos/:name", amw(MakeAdminReposHandler(services)))
 	router.DELETE(APIRoot+"/leases-by-path/*path", amw(MakeAdminLeasesHandler(services)))
 	router.POST(APIRoot+"/gc", amw(MakeGCHandler(services)))
+	router.GET(APIRoot+"/gc/jobs", amw(MakeGCJobsHandler(services)))
+	router.GET(APIRoot+"/gc/jobs/:id", amw(MakeGCJobsHandler(services)))
+	router.DELETE(APIRoot+"/gc/jobs/:id", amw(MakeGCJobsHandler(services)))
+	router.GET(APIRoot+"/gc/jobs/:id/log", amw(MakeGCJobLogHandler(services)))
+	router.POST(APIRoot+"/config/reload", amw(MakeAdminConfigHandler(services)))
+	router.GET(APIRoot+"/audit", amw(MakeAuditHandler(services)))
+	router.GET(APIRoot+"/keys", amw(MakeKeysHandler(services)))
+	router.GET(APIRoot+"/keys/expiring", amw(MakeExpiringKeysHandler(services)))
+	router.POST(APIRoot+"/keys", amw(MakeKeysHandler(services)))
+	router.POST(APIRoot+"/keys/:id", amw(MakeKeysHandler(services)))
+	router.DELETE(APIRoot+"/keys/:id", amw(MakeKeysHandler(services)))
+	router.POST(APIRoot+"/keys/:id/rotate", amw(MakeKeyRotationHandler(services)))
+	router.POST(APIRoot+"/keys/:id/paths", amw(MakeKeyPathsHandler(services)))
+	router.DELETE(APIRoot+"/keys/:id/paths/:repository", amw(MakeKeyPathsHandler(services)))
 
 	// Configure and start the HTTP server
 	srv := &http.Server{
@@ -70,9 +93,18 @@ func NewFrontend(services be.ActionController, port int, timeout time.Duration)
 	return srv
 }
 
-// Start HTTP frontend
-func Start(services *be.Services, port int, timeout time.Duration) error {
+// Start HTTP frontend. With a certificate reloader, HTTPS is served instead
+// of plain HTTP
+func Start(services *be.Services, port int, timeout time.Duration, certs *CertificateReloader) error {
 	srv := NewFrontend(services, port, timeout)
+	if certs != nil {
+		srv.TLSConfig = certs.TLSConfig()
+		if err := srv.ListenAndServeTLS("", ""); err != nil {
+			return fmt.Errorf("could not run HTTPS front-end: %w", err)
+		}
+		return nil
+	}
+
 	if err := srv.ListenAndServe(); err != nil {
 		return fmt.Errorf("could not run HTTP front-end: %w", err)
 	}
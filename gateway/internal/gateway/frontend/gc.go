@@ -3,13 +3,22 @@ package frontend
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
 	"github.com/julienschmidt/httprouter"
 )
 
-// MakeGCHandler creates an HTTP handler for the "/gc" endpoint
+const (
+	// gcLogPollInterval is the interval between reads of the log of a running
+	// garbage collection job, when streaming it to the client
+	gcLogPollInterval = 1 * time.Second
+)
+
+// MakeGCHandler creates an HTTP handler for the "/gc" endpoint. The garbage
+// collection runs in the background, the ID of the new job is returned
 func MakeGCHandler(services be.ActionController) httprouter.Handle {
 	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 		ctx := h.Context()
@@ -21,11 +30,11 @@ func MakeGCHandler(services be.ActionController) httprouter.Handle {
 		}
 
 		msg := map[string]interface{}{"status": "ok"}
-		if output, err := services.RunGC(ctx, options); err != nil {
+		if jobID, err := services.StartGC(ctx, options); err != nil {
 			msg["status"] = "error"
 			msg["reason"] = err.Error()
 		} else {
-			msg["output"] = output
+			msg["job_id"] = jobID
 		}
 
 		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
@@ -33,3 +42,98 @@ func MakeGCHandler(services be.ActionController) httprouter.Handle {
 		replyJSON(ctx, w, msg)
 	}
 }
+
+// MakeGCJobsHandler creates an HTTP handler for the "/gc/jobs" endpoints,
+// used to query the status of garbage collection jobs and to cancel them
+func MakeGCJobsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		msg := map[string]interface{}{"status": "ok"}
+
+		jobID := ps.ByName("id")
+		switch {
+		case h.Method == "DELETE":
+			if err := services.CancelGCJob(ctx, jobID); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			}
+		case jobID != "":
+			if job, err := services.GetGCJob(ctx, jobID); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = job
+			}
+		default:
+			if jobs, err := services.GetGCJobs(ctx); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = jobs
+			}
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
+
+// MakeGCJobLogHandler creates an HTTP handler which streams the output of a
+// garbage collection job as plain text, until the job is finished. The
+// optional "offset" query parameter allows resuming an interrupted stream
+func MakeGCJobLogHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		jobID := ps.ByName("id")
+
+		var offset int64
+		if o := h.URL.Query().Get("offset"); o != "" {
+			var err error
+			if offset, err = strconv.ParseInt(o, 10, 64); err != nil || offset < 0 {
+				httpWrapError(ctx, err, "invalid offset", w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Check that the job exists before starting the stream
+		if _, err := services.GetGCJob(ctx, jobID); err != nil {
+			replyJSON(ctx, w, map[string]interface{}{"status": "error", "reason": err.Error()})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			msg := "response writer does not support flushing"
+			gw.LogC(ctx, "http", gw.LogError).Msg(msg)
+			http.Error(w, msg, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		ticker := time.NewTicker(gcLogPollInterval)
+		defer ticker.Stop()
+		for {
+			next, done, err := services.ReadGCJobLog(ctx, jobID, offset, w)
+			if err != nil {
+				gw.LogC(ctx, "http", gw.LogError).Err(err).Msg("could not read gc job log")
+				return
+			}
+			offset = next
+			flusher.Flush()
+			if done {
+				break
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+	}
+}
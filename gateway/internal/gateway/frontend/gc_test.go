@@ -0,0 +1,93 @@
+package frontend
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGCHandlerStartGC(t *testing.T) {
+	backend := mockBackend{}
+	msg, _ := json.Marshal(map[string]interface{}{
+		"repo":          "test2.repo.org",
+		"num_revisions": 5,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/gc", bytes.NewReader(msg))
+
+	w := httptest.NewRecorder()
+	handler := MakeGCHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	expected, _ := json.Marshal(map[string]interface{}{
+		"status": "ok",
+		"job_id": "gc_job_id",
+	})
+
+	respBody, _ := ioutil.ReadAll(w.Result().Body)
+	if !bytes.Equal(respBody, expected) {
+		t.Errorf("Invalid response body: %v", string(respBody))
+	}
+}
+
+func TestGCHandlerJobs(t *testing.T) {
+	backend := mockBackend{}
+	t.Run("get job", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/gc/jobs/gc_job_id", nil)
+		w := httptest.NewRecorder()
+		handler := MakeGCJobsHandler(&backend)
+		handler(w, req, httprouter.Params{httprouter.Param{Key: "id", Value: "gc_job_id"}})
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if resp.Status != "ok" || resp.Data.ID != "gc_job_id" || resp.Data.Status != "succeeded" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("cancel finished job", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/v1/gc/jobs/gc_job_id", nil)
+		w := httptest.NewRecorder()
+		handler := MakeGCJobsHandler(&backend)
+		handler(w, req, httprouter.Params{httprouter.Param{Key: "id", Value: "gc_job_id"}})
+
+		expected := `{"reason":"job_finished","status":"error"}`
+		if respBody, _ := ioutil.ReadAll(w.Result().Body); string(respBody) != expected {
+			t.Errorf("Invalid response body: %v", string(respBody))
+		}
+	})
+}
+
+func TestGCHandlerJobLog(t *testing.T) {
+	backend := mockBackend{}
+	t.Run("full log", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/gc/jobs/gc_job_id/log", nil)
+		w := httptest.NewRecorder()
+		handler := MakeGCJobLogHandler(&backend)
+		handler(w, req, httprouter.Params{httprouter.Param{Key: "id", Value: "gc_job_id"}})
+
+		if respBody, _ := ioutil.ReadAll(w.Result().Body); string(respBody) != "gc output\n" {
+			t.Errorf("Invalid response body: %q", string(respBody))
+		}
+	})
+	t.Run("log from offset", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/gc/jobs/gc_job_id/log?offset=3", nil)
+		w := httptest.NewRecorder()
+		handler := MakeGCJobLogHandler(&backend)
+		handler(w, req, httprouter.Params{httprouter.Param{Key: "id", Value: "gc_job_id"}})
+
+		if respBody, _ := ioutil.ReadAll(w.Result().Body); string(respBody) != "output\n" {
+			t.Errorf("Invalid response body: %q", string(respBody))
+		}
+	})
+}
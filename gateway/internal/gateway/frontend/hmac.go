@@ -3,17 +3,72 @@ package frontend
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"hash"
 )
 
-// ComputeHMAC of a message using a specific key
+// Names of the HMAC algorithms, as given in the scheme prefix of the
+// Authorization header
+const (
+	HMACSHA1   = "HMAC-SHA1"
+	HMACSHA256 = "HMAC-SHA256"
+)
+
+// MinSHA256APIVersion is the API protocol version from which requests without
+// an explicit scheme are signed with HMAC-SHA256 instead of HMAC-SHA1
+const MinSHA256APIVersion = 4
+
+var hmacAlgorithms = map[string]func() hash.Hash{
+	HMACSHA1:   sha1.New,
+	HMACSHA256: sha256.New,
+}
+
+// RegisterHMACAlgorithm makes a hash function available for the request
+// signatures, under the given scheme name. It is not thread-safe and must be
+// called before the frontend is started
+func RegisterHMACAlgorithm(name string, h func() hash.Hash) {
+	hmacAlgorithms[name] = h
+}
+
+// IsHMACAlgorithm returns true if an HMAC algorithm is registered under the name
+func IsHMACAlgorithm(name string) bool {
+	_, present := hmacAlgorithms[name]
+	return present
+}
+
+// HMACAlgorithmForVersion returns the HMAC algorithm used by clients of an API
+// protocol version which do not give a scheme in the Authorization header
+func HMACAlgorithmForVersion(version int) string {
+	if version >= MinSHA256APIVersion {
+		return HMACSHA256
+	}
+	return HMACSHA1
+}
+
+// ComputeHMAC of a message using a specific key, with HMAC-SHA1
 func ComputeHMAC(message []byte, key string) []byte {
-	mac := hmac.New(sha1.New, []byte(key))
+	return ComputeHMACWith(HMACSHA1, message, key)
+}
+
+// ComputeHMACWith computes the HMAC of a message using a specific key and a
+// registered HMAC algorithm
+func ComputeHMACWith(algorithm string, message []byte, key string) []byte {
+	mac := hmac.New(hmacAlgorithms[algorithm], []byte(key))
 	mac.Write(message)
 	return []byte(hex.EncodeToString(mac.Sum(nil)))
 }
 
-// CheckHMAC of a message
+// CheckHMAC of a message, with HMAC-SHA1
 func CheckHMAC(message, messageHMAC []byte, key string) bool {
-	return hmac.Equal(messageHMAC, ComputeHMAC(message, key))
+	return CheckHMACWith(HMACSHA1, message, messageHMAC, key)
+}
+
+// CheckHMACWith checks the HMAC of a message computed with the given algorithm.
+// Returns false if the algorithm is not registered
+func CheckHMACWith(algorithm string, message, messageHMAC []byte, key string) bool {
+	if !IsHMACAlgorithm(algorithm) {
+		return false
+	}
+	return hmac.Equal(messageHMAC, ComputeHMACWith(algorithm, message, key))
 }
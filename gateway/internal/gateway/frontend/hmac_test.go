@@ -1,6 +1,8 @@
 package frontend
 
 import (
+	"bytes"
+	"crypto/sha512"
 	"testing"
 )
 
@@ -21,3 +23,38 @@ func TestHMAC(t *testing.T) {
 		t.Errorf("HMAC of msg2 should not be the same as for msg1")
 	}
 }
+
+func TestHMACAlgorithms(t *testing.T) {
+	key := "Qui?"
+	msg := []byte("Hello is it me you're looking for?")
+
+	sha1HMAC := ComputeHMACWith(HMACSHA1, msg, key)
+	sha256HMAC := ComputeHMACWith(HMACSHA256, msg, key)
+	if len(sha1HMAC) != 40 || len(sha256HMAC) != 64 {
+		t.Errorf("invalid HMAC lengths: %v, %v", len(sha1HMAC), len(sha256HMAC))
+	}
+	if !bytes.Equal(sha1HMAC, ComputeHMAC(msg, key)) {
+		t.Errorf("ComputeHMAC should use HMAC-SHA1")
+	}
+	if !CheckHMACWith(HMACSHA256, msg, sha256HMAC, key) {
+		t.Errorf("HMAC-SHA256 verification failed")
+	}
+	if CheckHMACWith(HMACSHA1, msg, sha256HMAC, key) {
+		t.Errorf("HMAC-SHA256 accepted as HMAC-SHA1")
+	}
+	if CheckHMACWith("HMAC-UNKNOWN", msg, sha256HMAC, key) {
+		t.Errorf("unknown algorithm accepted")
+	}
+
+	RegisterHMACAlgorithm("HMAC-SHA512", sha512.New)
+	defer delete(hmacAlgorithms, "HMAC-SHA512")
+	if !CheckHMACWith("HMAC-SHA512", msg, ComputeHMACWith("HMAC-SHA512", msg, key), key) {
+		t.Errorf("HMAC-SHA512 verification failed")
+	}
+
+	for version, expected := range map[int]string{2: HMACSHA1, 3: HMACSHA1, 4: HMACSHA256} {
+		if alg := HMACAlgorithmForVersion(version); alg != expected {
+			t.Errorf("invalid algorithm for version %v: %v", version, alg)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package frontend
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeKeysHandler creates an HTTP handler for the "/keys" endpoints, used to
+// list, create, enable, disable and delete keys at runtime
+func MakeKeysHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		msg := map[string]interface{}{"status": "ok"}
+
+		keyID := ps.ByName("id")
+		switch {
+		case h.Method == "GET":
+			if keys, err := services.GetKeys(ctx); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = keys
+			}
+		case h.Method == "DELETE":
+			if report, err := services.DeleteKey(ctx, keyID); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = report
+			}
+		case keyID == "":
+			var reqMsg struct {
+				ID string `json:"id"`
+				be.KeyOptions
+			}
+			if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
+				httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+				return
+			}
+			if secret, err := services.CreateKey(ctx, reqMsg.ID, reqMsg.KeyOptions); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = secret
+			}
+		default:
+			var reqMsg struct {
+				Enable bool `json:"enable"`
+			}
+			if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
+				httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+				return
+			}
+			if report, err := services.SetKeyEnabled(ctx, keyID, reqMsg.Enable); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = report
+			}
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
+
+// MakeExpiringKeysHandler creates an HTTP handler for the "/keys/expiring"
+// endpoint, listing the keys which have expired or expire soon. The optional
+// "within" query parameter, in seconds, replaces the key_expiry_warning time
+func MakeExpiringKeysHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		var within time.Duration
+		if v := h.URL.Query().Get("within"); v != "" {
+			seconds, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || seconds <= 0 {
+				httpWrapError(ctx, err, "invalid within parameter", w, http.StatusBadRequest)
+				return
+			}
+			within = time.Duration(seconds) * time.Second
+		}
+
+		msg := map[string]interface{}{"status": "ok"}
+		if keys, err := services.GetExpiringKeys(ctx, within); err != nil {
+			msg["status"] = "error"
+			msg["reason"] = err.Error()
+		} else {
+			msg["data"] = keys
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
+
+// MakeKeyRotationHandler creates an HTTP handler for the "/keys/:id/rotate"
+// endpoint. The new secret of the key is returned. The optional "overlap",
+// in seconds, is the time during which the previous secret is still accepted
+func MakeKeyRotationHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		var reqMsg struct {
+			Overlap *int64 `json:"overlap"`
+		}
+		if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil && err != io.EOF {
+			httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+			return
+		}
+		overlap := be.DefaultKeyRotationOverlap
+		if reqMsg.Overlap != nil {
+			overlap = time.Duration(*reqMsg.Overlap) * time.Second
+		}
+
+		msg := map[string]interface{}{"status": "ok"}
+		if secret, err := services.RotateKey(ctx, ps.ByName("id"), overlap); err != nil {
+			msg["status"] = "error"
+			msg["reason"] = err.Error()
+		} else {
+			msg["data"] = secret
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
+
+// MakeKeyPathsHandler creates an HTTP handler for the "/keys/:id/paths"
+// endpoints, used to attach keys to repository subpaths and to detach them
+func MakeKeyPathsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		msg := map[string]interface{}{"status": "ok"}
+
+		keyID := ps.ByName("id")
+		if h.Method == "DELETE" {
+			if report, err := services.DetachKey(ctx, keyID, ps.ByName("repository")); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			} else {
+				msg["data"] = report
+			}
+		} else {
+			var reqMsg struct {
+				Repository string `json:"repository"`
+				Path       string `json:"path"` // optional, the whole repository by default
+			}
+			if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
+				httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+				return
+			}
+			if err := services.AttachKey(ctx, keyID, reqMsg.Repository, reqMsg.Path); err != nil {
+				msg["status"] = "error"
+				msg["reason"] = err.Error()
+			}
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
@@ -0,0 +1,224 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// keysResponse is the reply of the key management handlers
+type keysResponse struct {
+	Status string          `json:"status"`
+	Reason string          `json:"reason"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func callKeysHandler(t *testing.T, handler httprouter.Handle, method, body string, ps httprouter.Params) (int, keysResponse) {
+	req := httptest.NewRequest(method, "/api/v1/keys", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, req, ps)
+
+	var resp keysResponse
+	if w.Result().StatusCode == http.StatusOK {
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+	}
+	return w.Result().StatusCode, resp
+}
+
+func TestKeysHandler(t *testing.T) {
+	backend := mockBackend{}
+	handler := MakeKeysHandler(&backend)
+	keyParam := func(id string) httprouter.Params {
+		return httprouter.Params{httprouter.Param{Key: "id", Value: id}}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "GET", "", httprouter.Params{})
+		var keys []struct {
+			ID           string            `json:"id"`
+			Enabled      bool              `json:"enabled"`
+			InConfigFile bool              `json:"in_config_file"`
+			Repositories map[string]string `json:"repositories"`
+		}
+		if err := json.Unmarshal(resp.Data, &keys); err != nil {
+			t.Fatalf("could not decode keys: %v", err)
+		}
+		if resp.Status != "ok" || len(keys) != 2 || keys[0].Repositories["test2.repo.org"] != "/" || keys[1].Enabled {
+			t.Errorf("Invalid response: %+v %+v", resp, keys)
+		}
+	})
+	t.Run("create", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "POST", `{"id": "keyid3", "admin": false}`, httprouter.Params{})
+		var secret struct {
+			KeyID  string `json:"key_id"`
+			Secret string `json:"secret"`
+		}
+		if err := json.Unmarshal(resp.Data, &secret); err != nil {
+			t.Fatalf("could not decode secret: %v", err)
+		}
+		if resp.Status != "ok" || secret.KeyID != "keyid3" || secret.Secret != "new_secret" {
+			t.Errorf("Invalid response: %+v %+v", resp, secret)
+		}
+
+		_, resp = callKeysHandler(t, handler, "POST", `{"id": "keyid1"}`, httprouter.Params{})
+		if resp.Status != "error" || resp.Reason != "key_exists" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+
+		_, resp = callKeysHandler(t, handler, "POST",
+			`{"id": "keyid3", "not_before": "2030-01-01T00:00:00Z", "not_after": "2029-01-01T00:00:00Z"}`,
+			httprouter.Params{})
+		if resp.Status != "error" || resp.Reason != "invalid_validity" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+
+		if status, _ := callKeysHandler(t, handler, "POST", `{"id":`, httprouter.Params{}); status != http.StatusBadRequest {
+			t.Errorf("Invalid HTTP response status code: %v", status)
+		}
+	})
+	t.Run("disable", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "POST", `{"enable": false}`, keyParam("keyid3"))
+		var report struct {
+			RevokedLeases []struct {
+				KeyID string `json:"key_id"`
+			} `json:"revoked_leases"`
+		}
+		if err := json.Unmarshal(resp.Data, &report); err != nil {
+			t.Fatalf("could not decode report: %v", err)
+		}
+		if resp.Status != "ok" || len(report.RevokedLeases) != 1 || report.RevokedLeases[0].KeyID != "keyid3" {
+			t.Errorf("Invalid response: %+v %+v", resp, report)
+		}
+
+		_, resp = callKeysHandler(t, handler, "POST", `{"enable": true}`, keyParam("unknown"))
+		if resp.Status != "error" || resp.Reason != "invalid_key" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "DELETE", "", keyParam("keyid3"))
+		if resp.Status != "ok" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+		_, resp = callKeysHandler(t, handler, "DELETE", "", keyParam("keyid1"))
+		if resp.Status != "error" || resp.Reason != "key_not_managed" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+}
+
+func TestExpiringKeysHandler(t *testing.T) {
+	backend := mockBackend{}
+	handler := MakeExpiringKeysHandler(&backend)
+	type expiringKey struct {
+		ID       string `json:"id"`
+		NotAfter string `json:"not_after"`
+	}
+	call := func(query string) (int, []expiringKey) {
+		req := httptest.NewRequest("GET", "/api/v1/keys/expiring"+query, nil)
+		w := httptest.NewRecorder()
+		handler(w, req, httprouter.Params{})
+
+		var resp struct {
+			Status string        `json:"status"`
+			Data   []expiringKey `json:"data"`
+		}
+		if w.Result().StatusCode == http.StatusOK {
+			if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if resp.Status != "ok" {
+				t.Fatalf("Invalid response status: %v", resp.Status)
+			}
+		}
+		return w.Result().StatusCode, resp.Data
+	}
+
+	if _, keys := call(""); len(keys) != 1 || keys[0].ID != "keyid2" || keys[0].NotAfter == "" {
+		t.Errorf("Invalid expiring keys: %+v", keys)
+	}
+	if _, keys := call("?within=60"); len(keys) != 0 {
+		t.Errorf("Invalid expiring keys: %+v", keys)
+	}
+	for _, query := range []string{"?within=soon", "?within=0"} {
+		if status, _ := call(query); status != http.StatusBadRequest {
+			t.Errorf("Invalid HTTP response status code for %v: %v", query, status)
+		}
+	}
+}
+
+func TestKeyRotationHandler(t *testing.T) {
+	backend := mockBackend{}
+	handler := MakeKeyRotationHandler(&backend)
+	ps := httprouter.Params{httprouter.Param{Key: "id", Value: "keyid1"}}
+
+	cases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"default overlap", "", "1h0m0s"},
+		{"overlap", `{"overlap": 60}`, "1m0s"},
+		{"no overlap", `{"overlap": 0}`, "0s"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, resp := callKeysHandler(t, handler, "POST", c.body, ps)
+			var secret struct {
+				Secret                string `json:"secret"`
+				PreviousSecretExpires string `json:"previous_secret_expires"`
+			}
+			if err := json.Unmarshal(resp.Data, &secret); err != nil {
+				t.Fatalf("could not decode secret: %v", err)
+			}
+			if resp.Status != "ok" || secret.Secret != "rotated_secret" || secret.PreviousSecretExpires != c.expected {
+				t.Errorf("Invalid response: %+v %+v", resp, secret)
+			}
+		})
+	}
+
+	t.Run("negative overlap", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "POST", `{"overlap": -1}`, ps)
+		if resp.Status != "error" || resp.Reason != "invalid_overlap" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+}
+
+func TestKeyPathsHandler(t *testing.T) {
+	backend := mockBackend{}
+	handler := MakeKeyPathsHandler(&backend)
+	ps := func(repository string) httprouter.Params {
+		return httprouter.Params{
+			httprouter.Param{Key: "id", Value: "keyid3"},
+			httprouter.Param{Key: "repository", Value: repository},
+		}
+	}
+
+	t.Run("attach", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "POST", `{"repository": "test2.repo.org", "path": "/sub"}`, ps(""))
+		if resp.Status != "ok" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+		_, resp = callKeysHandler(t, handler, "POST", `{"repository": "unknown.repo.org"}`, ps(""))
+		if resp.Status != "error" || resp.Reason != "invalid_repo" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+	t.Run("detach", func(t *testing.T) {
+		_, resp := callKeysHandler(t, handler, "DELETE", "", ps("test2.repo.org"))
+		if resp.Status != "ok" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+		_, resp = callKeysHandler(t, handler, "DELETE", "", ps("unknown.repo.org"))
+		if resp.Status != "error" || resp.Reason != "invalid_key_path" {
+			t.Errorf("Invalid response: %+v", resp)
+		}
+	})
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
@@ -27,6 +27,8 @@ func MakeLeasesHandler(services be.ActionController) httprouter.Handle {
 				// Requesting a new lease
 				handleNewLease(services, w, h)
 			}
+		case "PUT":
+			handleRenewLease(services, token, w, h)
 		case "DELETE":
 			handleCancelLease(services, token, w, h)
 		default:
@@ -69,6 +71,7 @@ func handleNewLease(services be.ActionController, w http.ResponseWriter, h *http
 		Path     string `json:"path"`
 		Version  string `json:"api_version"` // cvmfs_swissknife sends this field as a string
 		Hostname string `json:"hostname"` // May be empty for cvmfs < 2.11
+		Wait     int    `json:"wait"`     // Optional: seconds to wait in the lease queue if the path is busy
 	}
 	if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
 		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
@@ -94,10 +97,17 @@ func handleNewLease(services be.ActionController, w http.ResponseWriter, h *http
 			clientVersion,
 			MinAPIProtocolVersion)
 	} else {
-		// The authorization is expected to have the correct format, since it has already been checked.
-		keyID := strings.Split(h.Header.Get("Authorization"), " ")[0]
+		// The key ID is set by the authorization middleware
+		keyID, _ := ctx.Value(gw.KeyIDKey).(string)
 		protocolVersion := MaxAPIVersion(clientVersion)
-		token, err := services.NewLease(ctx, keyID, reqMsg.Path, hostname, protocolVersion)
+		var token string
+		var err error
+		if reqMsg.Wait > 0 {
+			maxWait := time.Duration(reqMsg.Wait) * time.Second
+			token, err = services.WaitForLease(ctx, keyID, reqMsg.Path, hostname, protocolVersion, maxWait)
+		} else {
+			token, err = services.NewLease(ctx, keyID, reqMsg.Path, hostname, protocolVersion)
+		}
 		if err != nil {
 			if busyError, ok := err.(be.PathBusyError); ok {
 				msg["status"] = "path_busy"
@@ -142,6 +152,27 @@ func handleCommitLease(services be.ActionController, token string, w http.Respon
 	replyJSON(ctx, w, msg)
 }
 
+func handleRenewLease(services be.ActionController, token string, w http.ResponseWriter, h *http.Request) {
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	ctx := h.Context()
+
+	msg := make(map[string]interface{})
+
+	if expiration, err := services.RenewLease(ctx, token); err != nil {
+		msg["status"] = "error"
+		msg["reason"] = err.Error()
+	} else {
+		msg["status"] = "ok"
+		msg["expires"] = expiration.String()
+	}
+
+	replyJSON(ctx, w, msg)
+}
+
 func handleCancelLease(services be.ActionController, token string, w http.ResponseWriter, h *http.Request) {
 	if token == "" {
 		http.Error(w, "missing token", http.StatusBadRequest)
@@ -161,3 +192,24 @@ func handleCancelLease(services be.ActionController, token string, w http.Respon
 
 	replyJSON(ctx, w, msg)
 }
+
+// MakeLeaseQueueHandler creates an HTTP handler for inspecting the queue of
+// lease requests waiting for busy paths
+func MakeLeaseQueueHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		msg := make(map[string]interface{})
+
+		queue, err := services.GetLeaseQueue(ctx)
+		if err != nil {
+			httpWrapError(ctx, err, err.Error(), w, http.StatusInternalServerError)
+			return
+		}
+		msg["status"] = "ok"
+		msg["data"] = queue
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
@@ -112,6 +112,62 @@ func TestLeaseHandlerCancelLease(t *testing.T) {
 	}
 }
 
+func TestLeaseHandlerRenewLease(t *testing.T) {
+	backend := mockBackend{}
+	token := "lease_token"
+	req := httptest.NewRequest("PUT", "/api/v1/leases/"+token, nil)
+	HMAC := ComputeHMAC([]byte(token), backend.GetKey(context.TODO(), "keyid2").Secret)
+	req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+
+	w := httptest.NewRecorder()
+	handler := MakeLeasesHandler(&backend)
+
+	ps := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+	handler(w, req, ps)
+
+	expected, _ := json.Marshal(map[string]interface{}{
+		"status":  "ok",
+		"expires": "2030-01-01 00:00:00 +0000 UTC",
+	})
+
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(respBody, expected) {
+		t.Errorf("Invalid response body: %v", string(respBody))
+	}
+}
+
+func TestLeaseHandlerLeaseQueue(t *testing.T) {
+	backend := mockBackend{}
+	req := httptest.NewRequest("GET", "/api/v1/lease-queue", nil)
+
+	w := httptest.NewRecorder()
+	handler := MakeLeaseQueueHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	queue, _ := backend.GetLeaseQueue(context.TODO())
+	expected, _ := json.Marshal(map[string]interface{}{
+		"status": "ok",
+		"data":   queue,
+	})
+
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(respBody, expected) {
+		t.Errorf("Invalid response body: %v", string(respBody))
+	}
+}
+
 func TestLeaseHandlerCommitLease(t *testing.T) {
 	backend := mockBackend{}
 	token := "lease_token"
@@ -0,0 +1,26 @@
+package frontend
+
+import (
+	"bytes"
+	"net/http"
+
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeMetricsHandler creates an HTTP handler for the "/metrics" endpoint,
+// which exports the gateway metrics in the Prometheus text format
+func MakeMetricsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+
+		var buf bytes.Buffer
+		if err := services.WriteMetrics(ctx, &buf); err != nil {
+			httpWrapError(ctx, err, "could not collect metrics", w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
@@ -3,6 +3,7 @@ package frontend
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -13,9 +14,12 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-const (
-	notificationTimeout = 2 * time.Hour
-)
+// notificationTimeout is the time after which an event stream is closed
+var notificationTimeout = 2 * time.Hour
+
+// notificationKeepalive is the interval between the comment lines sent to
+// keep idle event streams from being closed by proxies
+const notificationKeepalive = 30 * time.Second
 
 // MakeNotificationsHandler creates an HTTP handler for the notifications API
 func MakeNotificationsHandler(services be.ActionController) httprouter.Handle {
@@ -52,9 +56,16 @@ func handlePublish(
 		return
 	}
 
+	// The key ID is set by the authorization middleware
+	keyID, _ := ctx.Value(gw.KeyIDKey).(string)
+
 	rep := map[string]interface{}{"status": "ok"}
 
-	services.PublishManifest(ctx, req.Repository, be.NotificationMessage(body.String()))
+	err := services.PublishManifest(ctx, keyID, req.Repository, be.NotificationMessage(body.String()))
+	if err != nil {
+		rep["status"] = "error"
+		rep["reason"] = err.Error()
+	}
 
 	gw.LogC(ctx, "http", gw.LogInfo).Msg("request_processed")
 
@@ -65,13 +76,29 @@ func handleSubscribe(
 	services be.ActionController, w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 	ctx := h.Context()
 
-	var req struct {
-		Version    int    `json:"version"`
-		Repository string `json:"repository"`
+	repository := h.URL.Query().Get("repository")
+	if repository == "" {
+		// Older clients send the repository in a JSON request body
+		var req struct {
+			Version    int    `json:"version"`
+			Repository string `json:"repository"`
+		}
+		if err := json.NewDecoder(h.Body).Decode(&req); err != nil {
+			httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+			return
+		}
+		repository = req.Repository
+	}
+	if repository == "" {
+		httpWrapError(ctx, nil, "missing repository", w, http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(h.Body).Decode(&req); err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		msg := "response writer does not support flushing"
+		gw.LogC(ctx, "http", gw.LogError).Msg(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
 		return
 	}
 
@@ -81,29 +108,59 @@ func handleSubscribe(
 
 	gw.LogC(ctx, "http", gw.LogInfo).Msg("event stream starting")
 
-	eventSource := services.SubscribeToNotifications(ctx, req.Repository)
-	defer services.UnsubscribeFromNotifications(ctx, req.Repository, eventSource)
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		msg := "response writer does not support flushing"
-		gw.LogC(ctx, "http", gw.LogError).Msg(msg)
-		http.Error(w, msg, http.StatusInternalServerError)
+	lastEventID := h.Header.Get("Last-Event-ID")
+	eventSource, missed := services.SubscribeToNotifications(ctx, repository, lastEventID)
+	defer services.UnsubscribeFromNotifications(ctx, repository, eventSource)
+
+	if missed {
+		// The manifests following the last event are no longer in the
+		// history, the client is told before the history is replayed
+		w.Write([]byte("event: history_gap\ndata: {\"status\":\"history_gap\"}\n\n"))
+		flusher.Flush()
 	}
+
+	keepalive := time.NewTicker(notificationKeepalive)
+	defer keepalive.Stop()
+	timeout := time.NewTimer(notificationTimeout)
+	defer timeout.Stop()
+
 	for {
-		timeout := time.NewTimer(notificationTimeout)
 		select {
 		case event, ok := <-eventSource:
-			if ok {
-				w.Write([]byte("data: " + event + "\n\n"))
-				flusher.Flush()
-			} else {
-				break
+			if !ok {
+				// The subscription was closed by the notification system
+				gw.LogC(ctx, "http", gw.LogInfo).Msg("event stream closed")
+				return
 			}
-			timeout.Stop()
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-keepalive.C:
+			w.Write([]byte(": keepalive\n\n"))
+			flusher.Flush()
 		case <-timeout.C:
+			// The headers have been sent, the timeout is reported as an event
 			gw.LogC(ctx, "http", gw.LogInfo).Msg("notification timeout")
-			replyJSON(ctx, w, map[string]interface{}{"status": "timeout"})
+			w.Write([]byte("event: timeout\ndata: {\"status\":\"timeout\"}\n\n"))
+			flusher.Flush()
+			return
+		case <-ctx.Done():
+			gw.LogC(ctx, "http", gw.LogInfo).Msg("event stream closed by client")
 			return
 		}
 	}
 }
+
+// writeEvent writes a notification message as a server-sent event. The event
+// ID is the revision of the manifest, which is sent back by reconnecting
+// clients in the Last-Event-ID header
+func writeEvent(w io.Writer, event be.NotificationMessage) {
+	var buf bytes.Buffer
+	if revision, ok := event.Revision(); ok {
+		fmt.Fprintf(&buf, "id: %v\n", revision)
+	}
+	for _, line := range strings.Split(string(event), "\n") {
+		buf.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	buf.WriteString("\n")
+	w.Write(buf.Bytes())
+}
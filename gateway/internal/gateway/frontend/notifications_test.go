@@ -0,0 +1,130 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestNotificationsHandlerSubscribe(t *testing.T) {
+	backend := mockBackend{}
+	req := httptest.NewRequest(
+		"GET", "/api/v1/notifications/subscribe?repository=test2.repo.org", nil)
+	req.Header.Set("Last-Event-ID", "41")
+
+	w := httptest.NewRecorder()
+	handler := MakeNotificationsHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Invalid content type: %v", ct)
+	}
+
+	expected := "id: 42\n" +
+		"data: {\"repository\":\"test2.repo.org\",\"manifest\":\"C0123\\nS42\\n--\\nsig\"}\n\n"
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if string(respBody) != expected {
+		t.Errorf("Invalid response body: %q", string(respBody))
+	}
+}
+
+func TestNotificationsHandlerSubscribeHistoryGap(t *testing.T) {
+	backend := mockBackend{}
+	req := httptest.NewRequest(
+		"GET", "/api/v1/notifications/subscribe?repository=test2.repo.org", nil)
+	// Older than the history of the repository
+	req.Header.Set("Last-Event-ID", "1")
+
+	w := httptest.NewRecorder()
+	handler := MakeNotificationsHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	expected := "event: history_gap\ndata: {\"status\":\"history_gap\"}\n\n" +
+		"id: 42\n" +
+		"data: {\"repository\":\"test2.repo.org\",\"manifest\":\"C0123\\nS42\\n--\\nsig\"}\n\n"
+	respBody, _ := ioutil.ReadAll(w.Result().Body)
+	if string(respBody) != expected {
+		t.Errorf("Invalid response body: %q", string(respBody))
+	}
+}
+
+func TestNotificationsHandlerSubscribeTimeout(t *testing.T) {
+	timeout := notificationTimeout
+	notificationTimeout = 10 * time.Millisecond
+	defer func() {
+		notificationTimeout = timeout
+	}()
+
+	backend := mockBackend{}
+	req := httptest.NewRequest(
+		"GET", "/api/v1/notifications/subscribe?repository=test1.repo.org", nil)
+
+	w := httptest.NewRecorder()
+	handler := MakeNotificationsHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	resp := w.Result()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Invalid content type: %v", ct)
+	}
+
+	expected := "event: timeout\ndata: {\"status\":\"timeout\"}\n\n"
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if string(respBody) != expected {
+		t.Errorf("Invalid response body: %q", string(respBody))
+	}
+}
+
+func TestNotificationsHandlerSubscribeMissingRepository(t *testing.T) {
+	backend := mockBackend{}
+	req := httptest.NewRequest("GET", "/api/v1/notifications/subscribe", nil)
+
+	w := httptest.NewRecorder()
+	handler := MakeNotificationsHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	if resp := w.Result(); resp.StatusCode != 400 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+}
+
+func TestNotificationsHandlerPublish(t *testing.T) {
+	backend := mockBackend{}
+	publish := func(keyID, repository string) string {
+		reqBody := []byte(`{"version":1,"repository":"` + repository + `","manifest":"S42"}`)
+		req := httptest.NewRequest("POST", "/api/v1/notifications/publish", bytes.NewReader(reqBody))
+		// The key ID is normally set by the authorization middleware
+		req = req.WithContext(context.WithValue(req.Context(), gw.KeyIDKey, keyID))
+
+		w := httptest.NewRecorder()
+		handler := MakeNotificationsHandler(&backend)
+		handler(w, req, httprouter.Params{})
+
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		return string(respBody)
+	}
+
+	if resp := publish("keyid1", "test2.repo.org"); resp != `{"status":"ok"}` {
+		t.Errorf("Invalid response body: %v", resp)
+	}
+	expected := `{"reason":"authorization error: no_publish_permission","status":"error"}`
+	if resp := publish("keyid2", "test2.repo.org"); resp != expected {
+		t.Errorf("Invalid response body: %v", resp)
+	}
+	expected = `{"reason":"authorization error: invalid_repo","status":"error"}`
+	if resp := publish("keyid1", "other.repo.org"); resp != expected {
+		t.Errorf("Invalid response body: %v", resp)
+	}
+}
@@ -0,0 +1,25 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeReceiversHandler creates an HTTP handler for the "/receivers" endpoint,
+// which reports the health of the receiver workers
+func MakeReceiversHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		msg := make(map[string]interface{})
+
+		msg["status"] = "ok"
+		msg["data"] = services.GetReceiverHealth(ctx)
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, msg)
+	}
+}
@@ -0,0 +1,37 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestReceiversHandler(t *testing.T) {
+	backend := mockBackend{}
+	req := httptest.NewRequest("GET", "/api/v1/receivers", nil)
+
+	w := httptest.NewRecorder()
+	handler := MakeReceiversHandler(&backend)
+	handler(w, req, httprouter.Params{})
+
+	expected, _ := json.Marshal(map[string]interface{}{
+		"status": "ok",
+		"data":   backend.GetReceiverHealth(context.TODO()),
+	})
+
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(respBody, expected) {
+		t.Errorf("Invalid response body: %v", string(respBody))
+	}
+}
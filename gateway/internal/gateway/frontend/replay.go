@@ -0,0 +1,77 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+)
+
+// Headers with the timestamp (in seconds since the Unix epoch) and the nonce
+// of a signed request. With these headers, the HMAC covers the whole request
+// and the gateway refuses to accept the same request twice
+const (
+	TimestampHeader = "X-Request-Timestamp"
+	NonceHeader     = "X-Request-Nonce"
+)
+
+// replayProtection returns the timestamp and the nonce of a request, and true
+// if the request uses them
+func replayProtection(req *http.Request) (string, string, bool) {
+	timestamp := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	return timestamp, nonce, timestamp != "" || nonce != ""
+}
+
+// signingInput returns the HMAC input of a request with a timestamp and a
+// nonce: the method, the request URI, the timestamp, the nonce and the hex
+// encoded SHA-256 digest of the body, separated by newlines
+func signingInput(req *http.Request, timestamp, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		req.Method, req.URL.RequestURI(), timestamp, nonce, hex.EncodeToString(digest[:])}, "\n"))
+}
+
+// readSignedBody returns the part of the request body covered by the digest
+// in the signing input. For payload submissions, this is the JSON message in
+// front of the payload, which includes the digest of the payload. For all
+// the other requests, this is the whole body
+func readSignedBody(req *http.Request) ([]byte, error) {
+	if strings.HasPrefix(req.URL.Path, APIRoot+"/payloads") {
+		msgSize, err := strconv.Atoi(req.Header.Get("message-size"))
+		if err != nil {
+			return nil, fmt.Errorf("missing message-size header: %w", err)
+		}
+		return readBody(req, int64(msgSize))
+	}
+
+	if req.ContentLength < 0 {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return body, nil
+	}
+
+	return readBody(req, req.ContentLength)
+}
+
+// checkReplay verifies the timestamp and the nonce of a request whose HMAC
+// has been checked. The returned error is the reason given to the client
+func checkReplay(ctx context.Context, ac be.ActionController, keyID, timestamp, nonce string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid_timestamp")
+	}
+	return ac.CheckRequestNonce(ctx, keyID, nonce, time.Unix(seconds, 0))
+}
@@ -0,0 +1,123 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAuthorizationMiddlewareReplayProtection(t *testing.T) {
+	backend := mockBackend{}
+	secret := backend.GetKey(context.TODO(), "keyid2").Secret
+	token := "lease_token"
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// newRequest returns a request signed for the given method, path and body
+	newRequest := func(method, path string, body []byte, timestamp, nonce string) *http.Request {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		HMAC := ComputeHMAC(signingInput(req, timestamp, nonce, body), secret)
+		req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(NonceHeader, nonce)
+		return req
+	}
+	// authorize returns the body forwarded to the next handler, or the reply
+	authorize := func(handler httprouter.Handle, req *http.Request, ps httprouter.Params) string {
+		w := httptest.NewRecorder()
+		handler(w, req, ps)
+		respBody, _ := ioutil.ReadAll(w.Result().Body)
+		return string(respBody)
+	}
+	tokenParams := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+	commitBody := []byte(`{"old_root_hash":"abc","new_root_hash":"def"}`)
+
+	t.Run("commit", func(t *testing.T) {
+		req := newRequest("POST", "/api/v1/leases/"+token, commitBody, now, "n1")
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != string(commitBody) {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("signature used for another method", func(t *testing.T) {
+		req := newRequest("POST", "/api/v1/leases/"+token, nil, now, "n2")
+		req.Method = "DELETE"
+		expected := "{\"reason\":\"invalid_hmac\",\"status\":\"error\"}"
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("tampered body", func(t *testing.T) {
+		req := newRequest("POST", "/api/v1/leases/"+token, commitBody, now, "n3")
+		req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"old_root_hash":"abc","new_root_hash":"xyz"}`)))
+		expected := "{\"reason\":\"invalid_hmac\",\"status\":\"error\"}"
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("payload message", func(t *testing.T) {
+		msg := []byte(`{"payload_digest":"abcdef","header_size":"123","api_version":"3"}`)
+		body := append(append([]byte{}, msg...), []byte("payload")...)
+		req := httptest.NewRequest("POST", "/api/v1/payloads/"+token, bytes.NewReader(body))
+		HMAC := ComputeHMAC(signingInput(req, now, "n4", msg), secret)
+		req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+		req.Header.Set(TimestampHeader, now)
+		req.Header.Set(NonceHeader, "n4")
+		req.Header.Set("Message-Size", strconv.Itoa(len(msg)))
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != string(body) {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("stale timestamp", func(t *testing.T) {
+		old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		req := newRequest("DELETE", "/api/v1/leases/"+token, nil, old, "n5")
+		expected := "{\"reason\":\"stale_request\",\"status\":\"error\"}"
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("invalid timestamp", func(t *testing.T) {
+		req := newRequest("DELETE", "/api/v1/leases/"+token, nil, "yesterday", "n6")
+		expected := "{\"reason\":\"invalid_timestamp\",\"status\":\"error\"}"
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("replayed nonce", func(t *testing.T) {
+		req := newRequest("DELETE", "/api/v1/leases/"+token, nil, now, "replayed")
+		expected := "{\"reason\":\"replayed_request\",\"status\":\"error\"}"
+		if resp := authorize(WithAuthz(&backend, forwardBody), req, tokenParams); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+	t.Run("admin request", func(t *testing.T) {
+		path := "/api/v1/repos/test1.repo.org"
+		req := httptest.NewRequest("GET", path, nil)
+		HMAC := ComputeHMAC(signingInput(req, now, "n7", nil), secret)
+		req.Header["Authorization"] = []string{"admin1 " + base64.StdEncoding.EncodeToString(HMAC)}
+		req.Header.Set(TimestampHeader, now)
+		req.Header.Set(NonceHeader, "n7")
+		w := httptest.NewRecorder()
+		WithAdminAuthz(&backend, forwardBody)(w, req, httprouter.Params{})
+		if resp, _ := ioutil.ReadAll(w.Result().Body); len(resp) != 0 {
+			t.Errorf("Invalid response body: %v", string(resp))
+		}
+
+		// The HMAC of the path alone is not accepted with a timestamp
+		req = httptest.NewRequest("GET", path, nil)
+		HMAC = ComputeHMAC([]byte(path), secret)
+		req.Header["Authorization"] = []string{"admin1 " + base64.StdEncoding.EncodeToString(HMAC)}
+		req.Header.Set(TimestampHeader, now)
+		req.Header.Set(NonceHeader, "n8")
+		expected := "{\"reason\":\"invalid_hmac\",\"status\":\"error\"}"
+		if resp := authorize(WithAdminAuthz(&backend, forwardBody), req, httprouter.Params{}); resp != expected {
+			t.Errorf("Invalid response body: %v", resp)
+		}
+	})
+}
@@ -49,8 +49,9 @@ func MakeAdminReposHandler(services be.ActionController) httprouter.Handle {
 		ctx := h.Context()
 
 		var reqMsg struct {
-			Enable bool `json:"enable"`
-			Wait   bool `json:"wait"`
+			Enable bool   `json:"enable"`
+			Wait   bool   `json:"wait"`
+			Reason string `json:"reason"` // optional, recorded when disabling
 		}
 
 		if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
@@ -61,17 +62,17 @@ func MakeAdminReposHandler(services be.ActionController) httprouter.Handle {
 		repoName := ps.ByName("name")
 
 		msg := make(map[string]interface{})
-		if err := services.SetRepoEnabled(ctx, repoName, reqMsg.Enable); err != nil {
+		if err := services.SetRepoEnabled(ctx, repoName, reqMsg.Enable, reqMsg.Reason); err != nil {
 			if _, ok := err.(be.RepoBusyError); ok {
 				msg["status"] = "repo_busy"
 			} else {
 				msg["status"] = "error"
 				msg["reason"] = err.Error()
 			}
+		} else {
+			msg["status"] = "ok"
 		}
 
-		msg["status"] = "ok"
-
 		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
 
 		replyJSON(ctx, w, msg)
@@ -2,14 +2,17 @@ package frontend
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -19,6 +22,8 @@ func forwardBody(w http.ResponseWriter, req *http.Request, _ httprouter.Params)
 }
 
 type mockBackend struct {
+	// leaseLookups counts the calls to GetLease
+	leaseLookups int32
 }
 
 func (b *mockBackend) GetKey(ctx context.Context, keyID string) *be.KeyConfig {
@@ -26,11 +31,54 @@ func (b *mockBackend) GetKey(ctx context.Context, keyID string) *be.KeyConfig {
 	if strings.HasPrefix(keyID, "admin") {
 		admin = true
 	}
-	return &be.KeyConfig{Secret: "big_secret", Admin: admin}
+	if strings.HasPrefix(keyID, "certonly") {
+		return &be.KeyConfig{Admin: admin}
+	}
+	// Keys containing "strict" refuse HMAC-SHA1
+	rejectSHA1 := strings.Contains(keyID, "strict")
+	cfg := &be.KeyConfig{Secret: "big_secret", Admin: admin, RejectSHA1: rejectSHA1}
+	// Keys containing "rotated" still accept their previous secret
+	if strings.Contains(keyID, "rotated") {
+		cfg.PreviousSecret = "old_secret"
+	}
+	// Keys containing "expired" are past their validity window, keys
+	// containing "future" are not valid yet
+	if strings.Contains(keyID, "expired") {
+		cfg.NotAfter = time.Now().Add(-time.Hour)
+	}
+	if strings.Contains(keyID, "future") {
+		cfg.NotBefore = time.Now().Add(time.Hour)
+	}
+	return cfg
+}
+
+func (b *mockBackend) GetKeyIDByCertSubject(ctx context.Context, subject string) string {
+	switch subject {
+	case "CN=publisher1,O=Test":
+		return "keyid1"
+	case "CN=admin,O=Test":
+		return "admin1"
+	case "CN=publisher2,O=Test":
+		return "certonly1"
+	}
+	return ""
+}
+
+func (b *mockBackend) CheckRequestNonce(ctx context.Context, keyID, nonce string, timestamp time.Time) error {
+	if time.Since(timestamp) > time.Minute || time.Until(timestamp) > time.Minute {
+		return be.ErrStaleRequest
+	}
+	if nonce == "replayed" {
+		return be.ErrReplayedRequest
+	}
+	return nil
 }
 
 func (b *mockBackend) GetRepo(ctx context.Context, repoName string) (*be.RepositoryConfig, error) {
-	return &be.RepositoryConfig{Keys: be.KeyPaths{"keyid1": "/", "keyid2": "/restricted/to/subdir"}}, nil
+	return &be.RepositoryConfig{
+		Keys:       be.KeyPaths{"keyid1": "/", "keyid2": "/restricted/to/subdir"},
+		RejectSHA1: repoName == "strict.repo.org",
+	}, nil
 }
 
 func (b *mockBackend) GetRepos(ctx context.Context) (map[string]be.RepositoryConfig, error) {
@@ -44,7 +92,100 @@ func (b *mockBackend) GetRepos(ctx context.Context) (map[string]be.RepositoryCon
 	}, nil
 }
 
-func (b *mockBackend) SetRepoEnabled(ctx context.Context, repository string, enabled bool) error {
+func (b *mockBackend) ReloadAccessConfig(ctx context.Context) (*be.ReloadReport, error) {
+	return &be.ReloadReport{
+		Diff:             be.AccessConfigDiff{AddedRepos: []string{"test3.repo.org"}},
+		RevocationReport: be.RevocationReport{RevokedLeases: []be.LeaseDTO{}},
+	}, nil
+}
+
+func (b *mockBackend) GetKeys(ctx context.Context) ([]be.KeyDTO, error) {
+	return []be.KeyDTO{
+		{
+			ID:           "keyid1",
+			Enabled:      true,
+			InConfigFile: true,
+			Repositories: be.KeyPaths{"test2.repo.org": "/"},
+		},
+		{
+			ID:           "keyid3",
+			Enabled:      false,
+			Managed:      true,
+			Repositories: be.KeyPaths{},
+		},
+	}, nil
+}
+
+func (b *mockBackend) GetExpiringKeys(ctx context.Context, within time.Duration) ([]be.KeyDTO, error) {
+	keys := []be.KeyDTO{
+		{
+			ID:           "keyid2",
+			Enabled:      true,
+			InConfigFile: true,
+			NotAfter:     time.Now().Add(time.Hour).Format(time.RFC3339),
+			Repositories: be.KeyPaths{"test2.repo.org": "/restricted/to/subdir"},
+		},
+	}
+	if within > 0 && within < time.Hour {
+		keys = keys[:0]
+	}
+	return keys, nil
+}
+
+func (b *mockBackend) CreateKey(ctx context.Context, keyID string, opts be.KeyOptions) (*be.KeySecretDTO, error) {
+	if keyID == "keyid1" {
+		return nil, fmt.Errorf("key_exists")
+	}
+	if !opts.NotBefore.IsZero() && !opts.NotAfter.After(opts.NotBefore) {
+		return nil, fmt.Errorf("invalid_validity")
+	}
+	return &be.KeySecretDTO{KeyID: keyID, Secret: "new_secret"}, nil
+}
+
+func (b *mockBackend) SetKeyEnabled(ctx context.Context, keyID string, enable bool) (*be.RevocationReport, error) {
+	if keyID == "unknown" {
+		return nil, fmt.Errorf("invalid_key")
+	}
+	report := &be.RevocationReport{RevokedLeases: []be.LeaseDTO{}}
+	if !enable {
+		report.RevokedLeases = append(report.RevokedLeases, be.LeaseDTO{KeyID: keyID})
+	}
+	return report, nil
+}
+
+func (b *mockBackend) RotateKey(ctx context.Context, keyID string, overlap time.Duration) (*be.KeySecretDTO, error) {
+	if overlap < 0 {
+		return nil, fmt.Errorf("invalid_overlap")
+	}
+	return &be.KeySecretDTO{
+		KeyID:                 keyID,
+		Secret:                "rotated_secret",
+		PreviousSecretExpires: fmt.Sprintf("%v", overlap),
+	}, nil
+}
+
+func (b *mockBackend) DeleteKey(ctx context.Context, keyID string) (*be.RevocationReport, error) {
+	if keyID == "keyid1" {
+		return nil, fmt.Errorf("key_not_managed")
+	}
+	return &be.RevocationReport{RevokedLeases: []be.LeaseDTO{}}, nil
+}
+
+func (b *mockBackend) AttachKey(ctx context.Context, keyID, repository, path string) error {
+	if repository != "test2.repo.org" {
+		return fmt.Errorf("invalid_repo")
+	}
+	return nil
+}
+
+func (b *mockBackend) DetachKey(ctx context.Context, keyID, repository string) (*be.RevocationReport, error) {
+	if repository != "test2.repo.org" {
+		return nil, fmt.Errorf("invalid_key_path")
+	}
+	return &be.RevocationReport{RevokedLeases: []be.LeaseDTO{}}, nil
+}
+
+func (b *mockBackend) SetRepoEnabled(ctx context.Context, repository string, enabled bool, reason string) error {
 	return nil
 }
 
@@ -52,6 +193,21 @@ func (b *mockBackend) NewLease(ctx context.Context, keyID, leasePath, hostname s
 	return "lease_token_string", nil
 }
 
+func (b *mockBackend) WaitForLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int, maxWait time.Duration) (string, error) {
+	return "queued_lease_token_string", nil
+}
+
+func (b *mockBackend) GetLeaseQueue(ctx context.Context) ([]be.LeaseWaiterDTO, error) {
+	return []be.LeaseWaiterDTO{
+		{
+			KeyID:        "keyid1",
+			LeasePath:    "test2.repo.org/some/path/one/below",
+			WaitingSince: "2030-01-01 00:00:00 +0000 UTC",
+			BlockedBy:    []string{"test2.repo.org/some/path/one"},
+		},
+	}, nil
+}
+
 func (b *mockBackend) GetLeases(ctx context.Context) (map[string]be.LeaseDTO, error) {
 	return map[string]be.LeaseDTO{
 		"test2.repo.org/some/path/one": {
@@ -66,11 +222,26 @@ func (b *mockBackend) GetLeases(ctx context.Context) (map[string]be.LeaseDTO, er
 }
 
 func (b *mockBackend) GetLease(ctx context.Context, tokenStr string) (*be.LeaseDTO, error) {
-	return &be.LeaseDTO{
-		KeyID:     "keyid1",
-		LeasePath: "test2.repo.org/some/path/one",
-		Expires:   time.Now().Add(60 * time.Second).String(),
-	}, nil
+	atomic.AddInt32(&b.leaseLookups, 1)
+	lease := &be.LeaseDTO{
+		KeyID:           "keyid1",
+		LeasePath:       "test2.repo.org/some/path/one",
+		Expires:         time.Now().Add(60 * time.Second).String(),
+		ProtocolVersion: 3,
+	}
+	// Tokens "v4..." belong to leases of protocol version 4 clients, tokens
+	// "strict..." to leases of a repository which refuses HMAC-SHA1
+	if strings.HasPrefix(tokenStr, "v4") {
+		lease.ProtocolVersion = 4
+	}
+	if strings.HasPrefix(tokenStr, "strict") {
+		lease.LeasePath = "strict.repo.org/some/path/one"
+	}
+	return lease, nil
+}
+
+func (b *mockBackend) RenewLease(ctx context.Context, tokenStr string) (time.Time, error) {
+	return time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC), nil
 }
 
 func (b *mockBackend) CancelLeases(ctx context.Context, repoPath string) error {
@@ -89,18 +260,142 @@ func (b *mockBackend) SubmitPayload(ctx context.Context, token string, payload i
 	return nil
 }
 
-func (b *mockBackend) RunGC(ctx context.Context, options be.GCOptions) (string, error) {
-	return "", nil
+func (b *mockBackend) StartGC(ctx context.Context, options be.GCOptions) (string, error) {
+	return "gc_job_id", nil
+}
+
+func (b *mockBackend) GetGCJobs(ctx context.Context) ([]be.GCJobDTO, error) {
+	job, _ := b.GetGCJob(ctx, "gc_job_id")
+	return []be.GCJobDTO{*job}, nil
+}
+
+func (b *mockBackend) GetGCJob(ctx context.Context, id string) (*be.GCJobDTO, error) {
+	if id != "gc_job_id" {
+		return nil, be.ErrInvalidGCJob
+	}
+	return &be.GCJobDTO{
+		ID:         "gc_job_id",
+		Repository: "test2.repo.org",
+		Options:    be.GCOptions{Repository: "test2.repo.org"},
+		Status:     be.GCJobSucceeded,
+		Created:    "2030-01-01 00:00:00 +0000 UTC",
+	}, nil
 }
 
-func (b *mockBackend) PublishManifest(ctx context.Context, repository string, message be.NotificationMessage) {
+func (b *mockBackend) CancelGCJob(ctx context.Context, id string) error {
+	return be.ErrGCJobDone
 }
 
-func (b *mockBackend) SubscribeToNotifications(ctx context.Context, repository string) be.SubscriberHandle {
-	return make(chan be.NotificationMessage)
+func (b *mockBackend) ReadGCJobLog(ctx context.Context, id string, offset int64, w io.Writer) (int64, bool, error) {
+	output := "gc output\n"
+	if offset >= int64(len(output)) {
+		return offset, true, nil
+	}
+	n, err := w.Write([]byte(output[offset:]))
+	return offset + int64(n), true, err
+}
+
+func (b *mockBackend) PublishManifest(ctx context.Context, keyID, repository string, message be.NotificationMessage) error {
+	if repository != "test2.repo.org" {
+		return be.AuthError{Reason: "invalid_repo"}
+	}
+	if keyID != "keyid1" {
+		return be.AuthError{Reason: "no_publish_permission"}
+	}
+	return nil
+}
+
+func (b *mockBackend) SubscribeToNotifications(ctx context.Context, repository, lastEventID string) (be.SubscriberHandle, bool) {
+	if repository == "test1.repo.org" {
+		// Nothing is ever published
+		return make(chan be.NotificationMessage), false
+	}
+	handle := make(chan be.NotificationMessage, 1)
+	handle <- be.NotificationMessage(
+		`{"repository":"` + repository + `","manifest":"C0123\nS42\n--\nsig"}`)
+	close(handle)
+	// The history starts at the manifest with revision 42
+	return handle, lastEventID == "1"
 }
 
 func (b *mockBackend) UnsubscribeFromNotifications(
 	ctx context.Context, repository string, handle be.SubscriberHandle) error {
 	return nil
 }
+
+func (b *mockBackend) WriteMetrics(ctx context.Context, w io.Writer) error {
+	_, err := w.Write([]byte("# TYPE cvmfs_gateway_leases gauge\ncvmfs_gateway_leases{repository=\"test2.repo.org\"} 2\n"))
+	return err
+}
+
+func (b *mockBackend) GetAuditRecords(ctx context.Context, filter be.AuditFilter) ([]be.AuditRecordDTO, error) {
+	records := []be.AuditRecordDTO{
+		{
+			ID:         2,
+			Time:       "2030-01-01T00:00:01Z",
+			Action:     "commit_lease",
+			Repository: "test2.repo.org",
+			LeasePath:  "test2.repo.org/some/path",
+			KeyID:      "keyid1",
+			Outcome:    "success",
+		},
+		{
+			ID:         1,
+			Time:       "2030-01-01T00:00:00Z",
+			Action:     "new_lease",
+			Repository: "test2.repo.org",
+			LeasePath:  "test2.repo.org/some/path",
+			KeyID:      "keyid1",
+			Outcome:    "success",
+		},
+	}
+	if filter.Limit > 0 && filter.Limit < len(records) {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+func (b *mockBackend) GetCommitHistory(ctx context.Context, repository string, offset, limit int) (*be.CommitHistoryDTO, error) {
+	if repository != "test2.repo.org" {
+		return nil, fmt.Errorf("invalid_repo")
+	}
+	commits := []be.CommitRecordDTO{
+		{
+			Revision:    2,
+			OldRootHash: "hash1",
+			NewRootHash: "hash2",
+			KeyID:       "keyid1",
+			Hostname:    "host1",
+			LeasePath:   "test2.repo.org/some/path",
+			Time:        "2030-01-01T00:00:01Z",
+			Duration:    0.5,
+		},
+		{
+			Revision:    1,
+			OldRootHash: "hash0",
+			NewRootHash: "hash1",
+			KeyID:       "keyid1",
+			Hostname:    "host1",
+			LeasePath:   "test2.repo.org/some/path",
+			Time:        "2030-01-01T00:00:00Z",
+			Duration:    0.5,
+		},
+	}
+	history := &be.CommitHistoryDTO{Total: len(commits), Offset: offset, Limit: limit}
+	if offset < len(commits) {
+		commits = commits[offset:]
+	} else {
+		commits = commits[:0]
+	}
+	if limit > 0 && limit < len(commits) {
+		commits = commits[:limit]
+	}
+	history.Commits = commits
+	return history, nil
+}
+
+func (b *mockBackend) GetReceiverHealth(ctx context.Context) []receiver.WorkerHealth {
+	return []receiver.WorkerHealth{
+		{ID: 0, Status: receiver.WorkerHealthy, Tasks: 3, Restarts: 1},
+	}
+}
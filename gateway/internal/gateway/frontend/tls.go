@@ -0,0 +1,105 @@
+package frontend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// CertificateReloader holds the TLS certificate of the HTTP frontend and the
+// CAs used to verify the client certificates. Both are read again from their
+// files by Reload, so they can be replaced without restarting the gateway
+type CertificateReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool // nil if client certificates are not requested
+	lock         sync.RWMutex
+}
+
+// NewCertificateReloader loads the certificate and key of the frontend, and
+// the optional CA bundle used to verify client certificates
+func NewCertificateReloader(certFile, keyFile, clientCAFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload the certificates from their files. On error, the certificates which
+// were loaded before are kept
+func (r *CertificateReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %v", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+
+	gw.Log("http", gw.LogInfo).
+		Str("cert_file", r.certFile).
+		Bool("client_certs", clientCAs != nil).
+		Msg("TLS certificates loaded")
+
+	return nil
+}
+
+// TLSConfig returns the TLS configuration of the frontend, which always uses
+// the most recently loaded certificates. Client certificates are optional:
+// requests without one are authenticated with HMAC
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Also needed for http.Server.ServeTLS to accept the configuration
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientCertSubject returns the subject of the verified TLS client certificate
+// of a request. Requests with an Authorization header are authenticated with
+// HMAC, even if they come with a client certificate
+func clientCertSubject(req *http.Request) (string, bool) {
+	if req.Header.Get("Authorization") != "" || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.String(), true
+}
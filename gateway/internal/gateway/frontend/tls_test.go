@@ -0,0 +1,228 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/julienschmidt/httprouter"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by the parent, or a self-signed CA
+// certificate if parent is nil
+func newTestCert(t *testing.T, subject pkix.Name, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write the certificate and key as PEM files, returns the file names
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	certFile := path.Join(dir, name+".crt")
+	keyFile := path.Join(dir, name+".key")
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("could not serialize key: %v", err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatalf("could not write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSFrontend(t *testing.T) {
+	tmp := t.TempDir()
+	ca := newTestCert(t, pkix.Name{CommonName: "Test CA"}, 1, nil)
+	caFile, _ := ca.write(t, tmp, "ca")
+	server := newTestCert(t, pkix.Name{CommonName: "gateway"}, 2, ca)
+	certFile, keyFile := server.write(t, tmp, "server")
+
+	certs, err := NewCertificateReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("could not load certificates: %v", err)
+	}
+
+	backend := mockBackend{}
+	handler := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		keyID, _ := req.Context().Value(gw.KeyIDKey).(string)
+		replyJSON(req.Context(), w, message{"status": "ok", "key_id": keyID})
+	}
+	router := httprouter.New()
+	router.POST(APIRoot+"/leases", WithAuthz(&backend, handler))
+	router.POST(APIRoot+"/gc", WithAdminAuthz(&backend, handler))
+	srv := httptest.NewUnstartedServer(router)
+	srv.TLS = certs.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(clientCert *testCert) *http.Client {
+		cfg := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			cfg.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+	post := func(client *http.Client, route string, header string) map[string]interface{} {
+		body := []byte("hello")
+		req, _ := http.NewRequest("POST", srv.URL+APIRoot+route, bytes.NewReader(body))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var msg map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		return msg
+	}
+
+	publisher := newTestCert(t, pkix.Name{CommonName: "publisher1", Organization: []string{"Test"}}, 3, ca)
+	unknown := newTestCert(t, pkix.Name{CommonName: "unknown", Organization: []string{"Test"}}, 4, ca)
+	untrusted := newTestCert(t, pkix.Name{CommonName: "publisher1", Organization: []string{"Test"}}, 5, nil)
+
+	t.Run("client certificate", func(t *testing.T) {
+		msg := post(newClient(publisher), "/leases", "")
+		if msg["status"] != "ok" || msg["key_id"] != "keyid1" {
+			t.Errorf("invalid response: %v", msg)
+		}
+	})
+	t.Run("client certificate without admin rights", func(t *testing.T) {
+		msg := post(newClient(publisher), "/gc", "")
+		if msg["status"] != "error" || msg["reason"] != "no_admin_key" {
+			t.Errorf("invalid response: %v", msg)
+		}
+	})
+	t.Run("unknown client certificate", func(t *testing.T) {
+		msg := post(newClient(unknown), "/leases", "")
+		if msg["status"] != "error" || msg["reason"] != "invalid_client_cert" {
+			t.Errorf("invalid response: %v", msg)
+		}
+	})
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		// The certificate is not sent, since it is not issued by one of the
+		// accepted CAs, and the request falls back to HMAC
+		msg := post(newClient(untrusted), "/leases", "")
+		if msg["status"] != "error" || msg["reason"] != "invalid_hmac" {
+			t.Errorf("invalid response: %v", msg)
+		}
+	})
+	t.Run("HMAC", func(t *testing.T) {
+		HMAC := ComputeHMAC([]byte("hello"), backend.GetKey(context.TODO(), "keyid2").Secret)
+		header := "keyid2 " + base64.StdEncoding.EncodeToString(HMAC)
+		msg := post(newClient(publisher), "/leases", header)
+		if msg["status"] != "ok" || msg["key_id"] != "keyid2" {
+			t.Errorf("invalid response: %v", msg)
+		}
+		msg = post(newClient(nil), "/leases", "")
+		if msg["status"] != "error" || msg["reason"] != "invalid_hmac" {
+			t.Errorf("invalid response: %v", msg)
+		}
+	})
+	t.Run("reload", func(t *testing.T) {
+		renewed := newTestCert(t, pkix.Name{CommonName: "gateway"}, 6, ca)
+		renewed.write(t, tmp, "server")
+		if err := certs.Reload(); err != nil {
+			t.Fatalf("could not reload certificates: %v", err)
+		}
+		client := newClient(publisher)
+		resp, err := client.Post(srv.URL+APIRoot+"/leases", "", bytes.NewReader([]byte("hello")))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 6 {
+			t.Errorf("certificate not reloaded, serial number: %v", serial)
+		}
+
+		// A broken certificate file is not loaded
+		os.WriteFile(certFile, []byte("garbage"), 0600)
+		if err := certs.Reload(); err == nil {
+			t.Errorf("invalid certificate loaded")
+		}
+		resp, err = newClient(publisher).Post(srv.URL+APIRoot+"/leases", "", bytes.NewReader([]byte("hello")))
+		if err != nil {
+			t.Fatalf("request failed after invalid reload: %v", err)
+		}
+		resp.Body.Close()
+	})
+}
+
+func TestAuthorizationMiddlewareCertificateOnlyKey(t *testing.T) {
+	backend := mockBackend{}
+	reqBody := []byte("hello")
+	// A key without secret can not be used with an HMAC computed with an empty secret
+	HMAC := ComputeHMAC(reqBody, "")
+	req := httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+	req.Header["Authorization"] = []string{"certonly1 " + base64.StdEncoding.EncodeToString(HMAC)}
+	w := httptest.NewRecorder()
+	WithAuthz(&backend, forwardBody)(w, req, httprouter.Params{})
+
+	var msg map[string]interface{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&msg); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if msg["reason"] != "invalid_hmac" {
+		t.Errorf("invalid response: %v", msg)
+	}
+}
@@ -2,8 +2,8 @@ package frontend
 
 const (
 	// APIProtocolVersion is the latest API protocol version understood by the
-	// server
-	APIProtocolVersion = 3
+	// server. Clients of version 4 sign their requests with HMAC-SHA256
+	APIProtocolVersion = 4
 	// MinAPIProtocolVersion is the oldest API protocol version understood by the
 	// server
 	MinAPIProtocolVersion = 2
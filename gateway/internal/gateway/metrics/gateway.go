@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics exported by the gateway on the "/metrics" endpoint
+var (
+	factory = promauto.With(Registry)
+
+	// ActionDuration is the latency of the backend actions (new_lease,
+	// commit_lease, submit_payload, etc.), taken from the action log
+	ActionDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cvmfs_gateway_action_duration_seconds",
+		Help:    "Duration of the gateway backend actions.",
+		Buckets: DefaultBuckets,
+	}, []string{"action", "status"})
+
+	// Leases is the number of active leases per repository
+	Leases = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_leases",
+		Help: "Number of active leases.",
+	}, []string{"repository"})
+
+	// CommitsInProgress is the number of commits being processed per repository
+	CommitsInProgress = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_commits_in_progress",
+		Help: "Number of commits currently being processed by the receiver.",
+	}, []string{"repository"})
+
+	// ReceiverWorkers is the number of workers in the receiver pool
+	ReceiverWorkers = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_receiver_workers",
+		Help: "Number of workers in the receiver pool.",
+	})
+
+	// ReceiverBusyWorkers is the number of receiver workers processing a task
+	ReceiverBusyWorkers = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_receiver_busy_workers",
+		Help: "Number of receiver workers currently processing a task.",
+	})
+
+	// ReceiverHealthyWorkers is the number of receiver workers with a running
+	// and responsive receiver process
+	ReceiverHealthyWorkers = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_receiver_healthy_workers",
+		Help: "Number of receiver workers with a healthy receiver process.",
+	})
+
+	// ReceiverWorkerRestarts is the number of receiver process restarts
+	ReceiverWorkerRestarts = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_receiver_restarts_total",
+		Help: "Number of receiver process restarts.",
+	}, []string{"reason"})
+
+	// ReceiverQueuedTasks is the number of tasks waiting for a free receiver worker
+	ReceiverQueuedTasks = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "cvmfs_gateway_receiver_queued_tasks",
+		Help: "Number of tasks waiting for a free receiver worker.",
+	})
+
+	// ChunksAdded is the number of chunks added by publish operations
+	ChunksAdded = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_publish_chunks_added_total",
+		Help: "Number of chunks added by publish operations.",
+	}, []string{"repository"})
+
+	// ChunksDuplicated is the number of duplicated chunks in publish operations
+	ChunksDuplicated = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_publish_chunks_duplicated_total",
+		Help: "Number of duplicated chunks found by publish operations.",
+	}, []string{"repository"})
+
+	// CatalogsAdded is the number of catalogs added by publish operations
+	CatalogsAdded = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_publish_catalogs_added_total",
+		Help: "Number of catalogs added by publish operations.",
+	}, []string{"repository"})
+
+	// UploadedBytes is the number of bytes uploaded by publish operations
+	UploadedBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_publish_uploaded_bytes_total",
+		Help: "Number of bytes uploaded by publish operations.",
+	}, []string{"repository"})
+
+	// UploadedCatalogBytes is the number of catalog bytes uploaded by publish
+	// operations
+	UploadedCatalogBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_publish_uploaded_catalog_bytes_total",
+		Help: "Number of catalog bytes uploaded by publish operations.",
+	}, []string{"repository"})
+
+	// HookRuns is the number of completed hook runs, including their retries
+	HookRuns = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_hook_runs_total",
+		Help: "Number of completed hook runs.",
+	}, []string{"hook", "status"})
+
+	// NotificationsDropped is the number of notification messages dropped
+	// because the queue of a subscriber was full
+	NotificationsDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_notifications_dropped_total",
+		Help: "Number of notification messages dropped for slow subscribers.",
+	}, []string{"repository"})
+
+	// NotificationSubscribersDisconnected is the number of notification
+	// subscribers disconnected for being too slow
+	NotificationSubscribersDisconnected = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "cvmfs_gateway_notification_subscribers_disconnected_total",
+		Help: "Number of slow notification subscribers which were disconnected.",
+	}, []string{"repository"})
+)
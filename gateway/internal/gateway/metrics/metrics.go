@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds (in seconds) used for
+// the gateway action latency histograms
+var DefaultBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// Registry holds the metrics exported by the gateway
+var Registry = prometheus.NewRegistry()
+
+// Write all the metrics of the registry to the output, in the Prometheus text
+// exposition format
+func Write(out io.Writer) error {
+	families, err := Registry.Gather()
+	if err != nil {
+		return fmt.Errorf("could not gather metrics: %w", err)
+	}
+	enc := expfmt.NewEncoder(out, expfmt.FmtText)
+	for _, f := range families {
+		if err := enc.Encode(f); err != nil {
+			return fmt.Errorf("could not encode metrics: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	Leases.WithLabelValues("b.repo.org").Set(3)
+	Leases.WithLabelValues("a.repo.org").Set(1)
+	Leases.WithLabelValues("a.repo.org").Add(-1)
+	ActionDuration.WithLabelValues("commit_lease", "success").Observe(0.004)
+	ActionDuration.WithLabelValues("commit_lease", "success").Observe(7200)
+	HookRuns.WithLabelValues("a\"b\\c\nd", "success").Inc()
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatalf("could not write metrics: %v", err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		`# HELP cvmfs_gateway_leases Number of active leases.
+# TYPE cvmfs_gateway_leases gauge
+cvmfs_gateway_leases{repository="a.repo.org"} 0
+cvmfs_gateway_leases{repository="b.repo.org"} 3
+`,
+		`# TYPE cvmfs_gateway_action_duration_seconds histogram
+cvmfs_gateway_action_duration_seconds_bucket{action="commit_lease",status="success",le="0.005"} 1
+`,
+		`cvmfs_gateway_action_duration_seconds_bucket{action="commit_lease",status="success",le="3600"} 1
+cvmfs_gateway_action_duration_seconds_bucket{action="commit_lease",status="success",le="+Inf"} 2
+cvmfs_gateway_action_duration_seconds_sum{action="commit_lease",status="success"} 7200.004
+cvmfs_gateway_action_duration_seconds_count{action="commit_lease",status="success"} 2
+`,
+		"cvmfs_gateway_hook_runs_total{hook=\"a\\\"b\\\\c\\nd\",status=\"success\"} 1\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("metrics output is missing:\n%v\ngot:\n%v", expected, out)
+		}
+	}
+}
@@ -4,20 +4,36 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 )
 
+// MockCommitLatency is the time taken by a commit of the MockReceiver, to
+// simulate the receiver process in benchmarks
+var MockCommitLatency time.Duration
+
 // MockReceiver is a mocked implementation of the Receiver interface, for testing
 // Can implement fault injection
 type MockReceiver struct {
-	ctx context.Context
+	ctx         context.Context
+	crashed     bool
+	interrupted chan struct{}
+	interrupt   sync.Once
+	killed      chan struct{}
+	kill        sync.Once
 }
 
+// mockRunningCommits is the number of commits in progress in the
+// MockReceivers, which are only stopped by killing the receiver
+var mockRunningCommits int64
+
 // NewMockReceiver constructs a new MockReceiver object which implements the
 // Receiver interface
 func NewMockReceiver(ctx context.Context) (Receiver, error) {
-	return &MockReceiver{ctx}, nil
+	return &MockReceiver{ctx: ctx, interrupted: make(chan struct{}), killed: make(chan struct{})}, nil
 }
 
 func (r *MockReceiver) Quit() error {
@@ -28,6 +44,9 @@ func (r *MockReceiver) Quit() error {
 }
 
 func (r *MockReceiver) Echo() error {
+	if r.isCrashed() {
+		return fmt.Errorf("worker 'echo' call failed: %w", errMockCrashed)
+	}
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "echo").
 		Msgf("reply: PID: 12345")
@@ -35,6 +54,15 @@ func (r *MockReceiver) Echo() error {
 }
 
 func (r *MockReceiver) Commit(leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
+	atomic.AddInt64(&mockRunningCommits, 1)
+	defer atomic.AddInt64(&mockRunningCommits, -1)
+	// The commit ignores interruptions, like a receiver process which is
+	// busy publishing
+	select {
+	case <-time.After(MockCommitLatency):
+	case <-r.killed:
+		return 0, errMockCrashed
+	}
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "commit").
 		Str("lease_path", leasePath).
@@ -43,6 +71,22 @@ func (r *MockReceiver) Commit(leasePath, oldRootHash, newRootHash string, tag gw
 }
 
 func (r *MockReceiver) SubmitPayload(leasePath string, payload io.Reader, digest string, headerSize int) error {
+	// The payload is consumed like by the receiver process, until it is
+	// interrupted
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, payload)
+		copied <- err
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return fmt.Errorf("could not write request payload: %w", err)
+		}
+	case <-r.interrupted:
+		return errMockCrashed
+	}
+
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "submit payload").
 		Str("lease_path", leasePath).
@@ -51,12 +95,38 @@ func (r *MockReceiver) SubmitPayload(leasePath string, payload io.Reader, digest
 }
 
 func (r *MockReceiver) Interrupt() error {
+	r.interrupt.Do(func() {
+		close(r.interrupted)
+	})
 	return nil
 }
 
+func (r *MockReceiver) Kill() error {
+	r.kill.Do(func() {
+		close(r.killed)
+	})
+	return nil
+}
+
+func (r *MockReceiver) isCrashed() bool {
+	select {
+	case <-r.interrupted:
+		return true
+	case <-r.killed:
+		return true
+	default:
+		return r.crashed
+	}
+}
+
 func (r *MockReceiver) TestCrash() error {
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "test crash").
 		Msgf("worker process is crashing")
-	return fmt.Errorf("mock receiver has crashed")
+	r.crashed = true
+	return errMockCrashed
 }
+
+// errMockCrashed is returned by the calls to a crashed MockReceiver, like
+// the EOF error of a crashed receiver process
+var errMockCrashed = fmt.Errorf("mock receiver has crashed: %w", io.EOF)
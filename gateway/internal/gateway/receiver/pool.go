@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
@@ -71,28 +72,95 @@ func (p testCrashTask) Context() context.Context {
 	return p.ctx
 }
 
+// DefaultHealthCheckInterval is the interval between the health checks of
+// the idle receiver workers
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// DefaultInterruptGracePeriod is the time given to an interrupted receiver
+// process to stop before it is killed
+const DefaultInterruptGracePeriod = 10 * time.Second
+
+// PoolOptions controls the life cycle of the receiver worker processes
+type PoolOptions struct {
+	// MaxTasks is the number of tasks after which a receiver process is
+	// replaced by a new one (0 means unlimited)
+	MaxTasks int
+	// IdleTimeout is the time after which an unused receiver process is
+	// replaced by a new one (0 means never)
+	IdleTimeout time.Duration
+	// HealthCheckInterval is the interval between the health checks of the
+	// idle receiver processes (DefaultHealthCheckInterval if 0)
+	HealthCheckInterval time.Duration
+	// PayloadTimeout is the deadline of a payload submission, including the
+	// time spent waiting for a free worker (0 means no deadline)
+	PayloadTimeout time.Duration
+	// CommitTimeout is the deadline of a commit, including the time spent
+	// waiting for a free worker (0 means no deadline)
+	CommitTimeout time.Duration
+	// InterruptGracePeriod is the time given to an interrupted receiver
+	// process to stop before it is killed (DefaultInterruptGracePeriod if 0)
+	InterruptGracePeriod time.Duration
+}
+
 // Pool maintains a number of parallel receiver workers to service
 // payload submission and commit requests. Payload submissions are done in
 // parallel, using Config.NumReceivers workers, while only a single commit
 // request can be treated per repository at a time.
+//
+// Each worker keeps a long-lived receiver process, which is health-checked
+// with an echo request before each task and periodically while idle. The
+// process is restarted when it crashes, and recycled after a number of tasks
+// or when it has been idle for too long.
 type Pool struct {
-	tasks      chan<- task
-	wg         sync.WaitGroup
-	workerExec string
-	mock       bool
-	smgr       *stats.StatisticsMgr
+	tasks       chan<- task
+	wg          sync.WaitGroup
+	workerExec  string
+	mock        bool
+	smgr        *stats.StatisticsMgr
+	options     PoolOptions
+	workers     []*worker
+	numWorkers  int
+	busyWorkers int64
+	queuedTasks int64
+}
+
+// PoolStats is a snapshot of the utilization of the receiver pool
+type PoolStats struct {
+	NumWorkers     int `json:"num_workers"`
+	BusyWorkers    int `json:"busy_workers"`
+	HealthyWorkers int `json:"healthy_workers"`
+	QueuedTasks    int `json:"queued_tasks"`
 }
 
 // StartPool the receiver pool using the specified executable and number of payload
 // submission workers
-func StartPool(workerExec string, numWorkers int, mock bool, smgr *stats.StatisticsMgr) (*Pool, error) {
+func StartPool(workerExec string, numWorkers int, mock bool, smgr *stats.StatisticsMgr, options PoolOptions) (*Pool, error) {
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if options.InterruptGracePeriod <= 0 {
+		options.InterruptGracePeriod = DefaultInterruptGracePeriod
+	}
+
 	// Start payload submission workers
 	tasks := make(chan task)
-	pool := &Pool{tasks, sync.WaitGroup{}, workerExec, mock, smgr}
+	pool := &Pool{
+		tasks:      tasks,
+		workerExec: workerExec,
+		mock:       mock,
+		smgr:       smgr,
+		options:    options,
+		workers:    make([]*worker, numWorkers),
+		numWorkers: numWorkers,
+	}
 
 	for i := 0; i < numWorkers; i++ {
+		pool.workers[i] = &worker{
+			pool:   pool,
+			health: WorkerHealth{ID: i, Status: WorkerStopped},
+		}
 		pool.wg.Add(1)
-		go worker(tasks, pool, i)
+		go pool.workers[i].run(tasks)
 	}
 
 	gw.Log("worker_pool", gw.LogInfo).
@@ -108,88 +176,107 @@ func (p *Pool) Stop() error {
 	return nil
 }
 
-// SubmitPayload to be unpacked into the repository
-// TODO: implement timeout or context?
+// Stats returns the number of workers, busy workers, and tasks waiting to be
+// picked up by a worker
+func (p *Pool) Stats() PoolStats {
+	healthy := 0
+	for _, h := range p.Health() {
+		if h.Status == WorkerHealthy {
+			healthy++
+		}
+	}
+	return PoolStats{
+		NumWorkers:     p.numWorkers,
+		BusyWorkers:    int(atomic.LoadInt64(&p.busyWorkers)),
+		HealthyWorkers: healthy,
+		QueuedTasks:    int(atomic.LoadInt64(&p.queuedTasks)),
+	}
+}
+
+// Health returns the state of each of the workers of the pool
+func (p *Pool) Health() []WorkerHealth {
+	ret := make([]WorkerHealth, 0, len(p.workers))
+	for _, w := range p.workers {
+		ret = append(ret, w.getHealth())
+	}
+	return ret
+}
+
+// enqueue blocks until the task is picked up by a worker, or the context of
+// the task is done
+func (p *Pool) enqueue(t task) error {
+	atomic.AddInt64(&p.queuedTasks, 1)
+	defer atomic.AddInt64(&p.queuedTasks, -1)
+	select {
+	case p.tasks <- t:
+		return nil
+	case <-t.Context().Done():
+		return fmt.Errorf("task not started: %w", t.Context().Err())
+	}
+}
+
+// wait for the reply of a task. If the context of the task is done first,
+// the worker interrupts the receiver process, and kills it if it doesn't stop
+// within PoolOptions.InterruptGracePeriod. The reply is still awaited, so that
+// the caller doesn't return while the receiver is working on the task. A task
+// which completed despite the interruption is successful, otherwise the
+// context error is returned
+func (p *Pool) wait(ctx context.Context, reply <-chan error) error {
+	select {
+	case result := <-reply:
+		return result
+	case <-ctx.Done():
+	}
+
+	if result := <-reply; result == nil {
+		return nil
+	}
+	return fmt.Errorf("task interrupted: %w", ctx.Err())
+}
+
+// withTimeout returns a context with the given deadline, if it is not zero
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// SubmitPayload to be unpacked into the repository. The submission is
+// abandoned when the context is done or after PoolOptions.PayloadTimeout
 func (p *Pool) SubmitPayload(ctx context.Context, leasePath string, payload io.Reader, digest string, headerSize int) error {
+	ctx, cancel := withTimeout(ctx, p.options.PayloadTimeout)
+	defer cancel()
+
 	reply := make(chan error, 1)
-	p.tasks <- payloadTask{ctx, leasePath, payload, digest, headerSize, reply}
-	result := <-reply
-	return result
+	if err := p.enqueue(payloadTask{ctx, leasePath, payload, digest, headerSize, reply}); err != nil {
+		return err
+	}
+	return p.wait(ctx, reply)
 }
 
-// CommitLease associated with the token (transaction commit)
-// TODO: implement timeout or context?
+// CommitLease associated with the token (transaction commit). The commit is
+// abandoned when the context is done or after PoolOptions.CommitTimeout
 func (p *Pool) CommitLease(ctx context.Context, leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, p.options.CommitTimeout)
+	defer cancel()
+
 	reply := make(chan error, 1)
 	finalRevChan := make(chan uint64, 1)
-	p.tasks <- commitTask{ctx, leasePath, oldRootHash, newRootHash, tag, reply, finalRevChan}
-	result := <-reply
-	if result == nil {
-		return <-finalRevChan, nil
+	if err := p.enqueue(commitTask{ctx, leasePath, oldRootHash, newRootHash, tag, reply, finalRevChan}); err != nil {
+		return 0, err
 	}
-	return 0, result
+	if err := p.wait(ctx, reply); err != nil {
+		return 0, err
+	}
+	return <-finalRevChan, nil
 }
 
-func worker(tasks <-chan task, pool *Pool, workerIdx int) {
-	gw.Log("worker_pool", gw.LogDebug).
-		Int("worker_id", workerIdx).
-		Msg("started")
-
-	defer pool.wg.Done()
-M:
-	for {
-		task, more := <-tasks
-
-		if !more {
-			break M
-		}
-
-		func() {
-			t0 := time.Now()
-			receiver, err := NewReceiver(task.Context(), pool.workerExec, pool.mock, pool.smgr)
-			if err != nil {
-				task.Reply() <- err
-				return
-			}
-			defer func() {
-				if err := receiver.Quit(); err != nil {
-					gw.LogC(task.Context(), "worker_pool", gw.LogError).
-						Int("worker_id", workerIdx).
-						Msgf("error when quitting the receiver: %v", err.Error())
-				}
-			}()
-
-			var taskType string
-			var result error
-			var finalRev uint64
-			switch t := task.(type) {
-			case payloadTask:
-				result = receiver.SubmitPayload(t.leasePath, t.payload, t.digest, t.headerSize)
-				taskType = "payload"
-			case commitTask:
-				finalRev, result = receiver.Commit(t.leasePath, t.oldRootHash, t.newRootHash, t.tag)
-				taskType = "commit"
-				t.finalRevChan <- finalRev
-				close(t.finalRevChan)
-			case testCrashTask:
-				result = receiver.TestCrash()
-				taskType = "testcrash"
-			default:
-				task.Reply() <- fmt.Errorf("unknown task type")
-				return
-			}
-
-			task.Reply() <- result
-			close(task.Reply())
-
-			gw.LogC(task.Context(), "worker_pool", gw.LogDebug).
-				Int("worker_id", workerIdx).
-				Dur("task_dt", time.Since(t0)).
-				Msgf("%v task complete", taskType)
-		}()
+// testCrash makes the receiver process of the worker picking up the task crash
+func (p *Pool) testCrash(ctx context.Context) error {
+	reply := make(chan error, 1)
+	if err := p.enqueue(testCrashTask{ctx, reply}); err != nil {
+		return err
 	}
-
-	gw.Log("worker_pool", gw.LogDebug).
-		Int("worker_id", workerIdx).
-		Msg("finished")
+	return p.wait(ctx, reply)
 }
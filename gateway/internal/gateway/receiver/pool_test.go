@@ -0,0 +1,203 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func startMockPool(t *testing.T, options PoolOptions) *Pool {
+	pool, err := StartPool("", 1, true, newStatisticsMgr(), options)
+	if err != nil {
+		t.Fatalf("could not start pool: %v", err)
+	}
+	return pool
+}
+
+// waitForHealth polls the health of the single worker of the pool until the
+// condition is met
+func waitForHealth(t *testing.T, pool *Pool, cond func(h WorkerHealth) bool) WorkerHealth {
+	var h WorkerHealth
+	for i := 0; i < 100; i++ {
+		h = pool.Health()[0]
+		if cond(h) {
+			return h
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("unexpected worker state: %+v", h)
+	return h
+}
+
+func submit(t *testing.T, pool *Pool) {
+	err := pool.SubmitPayload(context.TODO(), "test.repo.org/path", strings.NewReader(""), "digest", 0)
+	if err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+}
+
+func TestPoolPersistentWorker(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{})
+	defer pool.Stop()
+
+	for i := 0; i < 3; i++ {
+		submit(t, pool)
+	}
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Status == WorkerHealthy && h.Tasks == 3 && h.Restarts == 0 && !h.Busy
+	})
+	if stats := pool.Stats(); stats.NumWorkers != 1 || stats.HealthyWorkers != 1 {
+		t.Fatalf("invalid pool stats: %+v", stats)
+	}
+}
+
+func TestPoolRestartOnCrash(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{})
+	defer pool.Stop()
+
+	submit(t, pool)
+	if err := pool.testCrash(context.TODO()); err == nil {
+		t.Fatalf("crash task should fail")
+	}
+	h := waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Restarts == 1
+	})
+	if h.Status != WorkerHealthy || h.Tasks != 0 || h.LastError == "" {
+		t.Fatalf("invalid worker state after crash: %+v", h)
+	}
+	submit(t, pool)
+}
+
+func TestPoolRecycleAfterMaxTasks(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{MaxTasks: 2})
+	defer pool.Stop()
+
+	submit(t, pool)
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Tasks == 1 && h.Restarts == 0
+	})
+	submit(t, pool)
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Tasks == 0 && h.Restarts == 1 && h.Status == WorkerHealthy
+	})
+}
+
+func TestPoolRecycleWhenIdle(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{
+		IdleTimeout:         20 * time.Millisecond,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	defer pool.Stop()
+
+	submit(t, pool)
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Restarts > 0 && h.Tasks == 0 && h.Status == WorkerHealthy
+	})
+}
+
+func TestPoolPayloadTimeout(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{PayloadTimeout: 50 * time.Millisecond})
+	defer pool.Stop()
+
+	// The payload never ends, the submission has to be interrupted
+	rd, wr := io.Pipe()
+	defer wr.Close()
+
+	err := pool.SubmitPayload(context.TODO(), "test.repo.org/path", rd, "digest", 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got: %v", err)
+	}
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Restarts == 1 && h.Status == WorkerHealthy && !h.Busy
+	})
+	submit(t, pool)
+}
+
+func TestPoolCancellation(t *testing.T) {
+	pool := startMockPool(t, PoolOptions{})
+	defer pool.Stop()
+
+	rd, wr := io.Pipe()
+	defer wr.Close()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	result1 := make(chan error, 1)
+	go func() {
+		result1 <- pool.SubmitPayload(ctx1, "test.repo.org/path", rd, "digest", 0)
+	}()
+	waitForHealth(t, pool, func(h WorkerHealth) bool {
+		return h.Busy
+	})
+
+	t.Run("cancelled while queued", func(t *testing.T) {
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel2()
+		err := pool.SubmitPayload(ctx2, "test.repo.org/path", strings.NewReader(""), "digest", 0)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded error, got: %v", err)
+		}
+		if stats := pool.Stats(); stats.QueuedTasks != 0 {
+			t.Fatalf("cancelled task still queued: %+v", stats)
+		}
+	})
+	t.Run("cancelled while running", func(t *testing.T) {
+		cancel1()
+		if err := <-result1; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected cancellation error, got: %v", err)
+		}
+		waitForHealth(t, pool, func(h WorkerHealth) bool {
+			return h.Restarts == 1 && h.Status == WorkerHealthy && !h.Busy
+		})
+		submit(t, pool)
+	})
+}
+
+func TestPoolInterruptGracePeriod(t *testing.T) {
+	MockCommitLatency = 100 * time.Millisecond
+	defer func() {
+		MockCommitLatency = 0
+	}()
+
+	t.Run("reply awaited", func(t *testing.T) {
+		pool := startMockPool(t, PoolOptions{CommitTimeout: 20 * time.Millisecond})
+		defer pool.Stop()
+
+		// The mock commit ignores the interruption, and completes
+		t0 := time.Now()
+		rev, err := pool.CommitLease(context.TODO(), "test.repo.org/path", "old", "new", gw.RepositoryTag{})
+		if err != nil || rev != 1 {
+			t.Fatalf("commit completed despite the interruption should succeed: %v, %v", rev, err)
+		}
+		if time.Since(t0) < MockCommitLatency {
+			t.Fatalf("commit returned before the worker replied")
+		}
+	})
+	t.Run("receiver killed", func(t *testing.T) {
+		pool := startMockPool(t, PoolOptions{
+			CommitTimeout:        20 * time.Millisecond,
+			InterruptGracePeriod: 20 * time.Millisecond,
+		})
+		defer pool.Stop()
+
+		t0 := time.Now()
+		_, err := pool.CommitLease(context.TODO(), "test.repo.org/path", "old", "new", gw.RepositoryTag{})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded error, got: %v", err)
+		}
+		if time.Since(t0) >= MockCommitLatency {
+			t.Fatalf("commit did not return after the grace period")
+		}
+		if n := atomic.LoadInt64(&mockRunningCommits); n != 0 {
+			t.Fatalf("commit returned while the receiver was still running: %v", n)
+		}
+		waitForHealth(t, pool, func(h WorkerHealth) bool {
+			return h.Restarts == 1 && h.Status == WorkerHealthy && !h.Busy
+		})
+	})
+}
@@ -47,7 +47,7 @@ type Receiver interface {
 	SubmitPayload(leasePath string, payload io.Reader, digest string, headerSize int) error
 	Commit(leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error)
 	Interrupt() error // like Ctrl-C SIGTERM -2
-	// Kill() error // like Crtl-D SIGKILL -9
+	Kill() error      // like Crtl-D SIGKILL -9, returns once the process has exited
 	TestCrash() error
 }
 
@@ -214,7 +214,12 @@ func (r *CvmfsReceiver) SubmitPayload(leasePath string, payload io.Reader, diges
 		Msgf("result: %v", result)
 
 	if result == nil {
-		r.statsMgr.MergeIntoLeaseStatistics(leasePath, &parsedReply.Statistics)
+		if err := r.statsMgr.MergeIntoLeaseStatistics(leasePath, &parsedReply.Statistics); err != nil {
+			gw.LogC(r.ctx, "receiver", gw.LogError).
+				Str("command", "submit payload").
+				Str("lease_path", leasePath).
+				Msgf("could not merge statistics: %v", err)
+		}
 	}
 
 	return result
@@ -222,7 +227,7 @@ func (r *CvmfsReceiver) SubmitPayload(leasePath string, payload io.Reader, diges
 
 // Commit command is sent to the worker
 func (r *CvmfsReceiver) Commit(leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
-	stats, err := r.statsMgr.PopLease(leasePath)
+	stats, err := r.statsMgr.GetLease(leasePath)
 	if err != nil {
 		return 0, fmt.Errorf("could not obtain statistics counters: %w", err)
 	}
@@ -262,6 +267,22 @@ func (r *CvmfsReceiver) Interrupt() error {
 	return err
 }
 
+// Kill the worker process and wait for it to exit
+func (r *CvmfsReceiver) Kill() error {
+	err := r.worker.Process.Kill()
+	if err == nil || errors.Is(err, os.ErrProcessDone) {
+		// The exit status of the killed process is not an error
+		var exitErr *exec.ExitError
+		if err = r.worker.Wait(); errors.As(err, &exitErr) {
+			err = nil
+		}
+	}
+	gw.LogC(r.ctx, "receiver", gw.LogDebug).
+		Str("command", "kill").
+		Msgf("result (err): %v", err)
+	return err
+}
+
 // Method used only in testing, we provide an empty implementation here
 func (r *CvmfsReceiver) TestCrash() error {
 	reply, err := r.call(receiverTestCrash, nil, nil)
@@ -0,0 +1,301 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+)
+
+// Status of the receiver process of a worker
+const (
+	WorkerHealthy   = "healthy"
+	WorkerUnhealthy = "unhealthy"
+	WorkerStopped   = "stopped"
+)
+
+// Reasons for restarting the receiver process of a worker
+const (
+	restartCrash       = "crash"
+	restartHealthCheck = "health_check"
+	restartMaxTasks    = "max_tasks"
+	restartIdle        = "idle"
+	restartInterrupted = "interrupted"
+)
+
+// WorkerHealth is a snapshot of the state of a receiver worker
+type WorkerHealth struct {
+	ID        int       `json:"id"`
+	Status    string    `json:"status"`
+	Busy      bool      `json:"busy"`
+	Tasks     int       `json:"tasks"`
+	Restarts  int       `json:"restarts"`
+	Started   time.Time `json:"started"`
+	LastUsed  time.Time `json:"last_used"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// worker owns a long-lived receiver process and runs the tasks it picks up
+// from the pool on it
+type worker struct {
+	pool     *Pool
+	receiver Receiver
+	health   WorkerHealth
+	lock     sync.Mutex // protects health
+}
+
+func (w *worker) getHealth() WorkerHealth {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.health
+}
+
+func (w *worker) updateHealth(update func(h *WorkerHealth)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	update(&w.health)
+}
+
+func (w *worker) run(tasks <-chan task) {
+	gw.Log("worker_pool", gw.LogDebug).
+		Int("worker_id", w.health.ID).
+		Msg("started")
+
+	defer w.pool.wg.Done()
+
+	w.start()
+	defer w.stop()
+
+	ticker := time.NewTicker(w.pool.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+M:
+	for {
+		select {
+		case task, more := <-tasks:
+			if !more {
+				break M
+			}
+			w.process(task)
+		case <-ticker.C:
+			w.check()
+		}
+	}
+
+	gw.Log("worker_pool", gw.LogDebug).
+		Int("worker_id", w.getHealth().ID).
+		Msg("finished")
+}
+
+// start a new receiver process. On failure the worker is marked as unhealthy,
+// and the start is retried before the next task or health check
+func (w *worker) start() {
+	receiver, err := NewReceiver(
+		context.Background(), w.pool.workerExec, w.pool.mock, w.pool.smgr)
+	if err != nil {
+		gw.Log("worker_pool", gw.LogError).
+			Int("worker_id", w.getHealth().ID).
+			Msgf("could not start receiver: %v", err)
+		w.updateHealth(func(h *WorkerHealth) {
+			h.Status = WorkerUnhealthy
+			h.LastError = err.Error()
+		})
+		return
+	}
+
+	w.receiver = receiver
+	now := time.Now()
+	w.updateHealth(func(h *WorkerHealth) {
+		h.Status = WorkerHealthy
+		h.Tasks = 0
+		h.Started = now
+		h.LastUsed = now
+	})
+}
+
+// stop the receiver process, if there is one
+func (w *worker) stop() {
+	if w.receiver == nil {
+		return
+	}
+	if err := w.receiver.Quit(); err != nil {
+		gw.Log("worker_pool", gw.LogError).
+			Int("worker_id", w.getHealth().ID).
+			Msgf("error when quitting the receiver: %v", err.Error())
+	}
+	w.receiver = nil
+	w.updateHealth(func(h *WorkerHealth) {
+		h.Status = WorkerStopped
+	})
+}
+
+// restart replaces the receiver process with a new one
+func (w *worker) restart(reason string) {
+	id := w.getHealth().ID
+	gw.Log("worker_pool", gw.LogInfo).
+		Int("worker_id", id).
+		Str("reason", reason).
+		Msg("restarting receiver")
+
+	w.stop()
+	metrics.ReceiverWorkerRestarts.WithLabelValues(reason).Inc()
+	w.updateHealth(func(h *WorkerHealth) {
+		h.Restarts++
+	})
+	w.start()
+}
+
+// healthy returns true once the worker has a responsive receiver process,
+// starting or restarting it as needed
+func (w *worker) healthy() bool {
+	if w.receiver == nil {
+		w.start()
+	} else if err := w.receiver.Echo(); err != nil {
+		w.updateHealth(func(h *WorkerHealth) {
+			h.LastError = err.Error()
+		})
+		w.restart(restartHealthCheck)
+	}
+	return w.receiver != nil
+}
+
+// check is run periodically on an idle worker
+func (w *worker) check() {
+	idleTimeout := w.pool.options.IdleTimeout
+	if w.receiver != nil && idleTimeout > 0 && time.Since(w.getHealth().LastUsed) > idleTimeout {
+		w.restart(restartIdle)
+		return
+	}
+	w.healthy()
+}
+
+func (w *worker) process(task task) {
+	atomic.AddInt64(&w.pool.busyWorkers, 1)
+	defer atomic.AddInt64(&w.pool.busyWorkers, -1)
+	w.updateHealth(func(h *WorkerHealth) {
+		h.Busy = true
+	})
+	defer w.updateHealth(func(h *WorkerHealth) {
+		h.Busy = false
+	})
+
+	t0 := time.Now()
+	if err := task.Context().Err(); err != nil {
+		task.Reply() <- fmt.Errorf("task not started: %w", err)
+		return
+	}
+	if !w.healthy() {
+		task.Reply() <- fmt.Errorf("receiver unavailable: %v", w.getHealth().LastError)
+		return
+	}
+
+	stopWatching := w.interruptOnCancel(task.Context())
+
+	var taskType string
+	var result error
+	var finalRev uint64
+	switch t := task.(type) {
+	case payloadTask:
+		result = w.receiver.SubmitPayload(t.leasePath, t.payload, t.digest, t.headerSize)
+		taskType = "payload"
+	case commitTask:
+		finalRev, result = w.receiver.Commit(t.leasePath, t.oldRootHash, t.newRootHash, t.tag)
+		taskType = "commit"
+		t.finalRevChan <- finalRev
+		close(t.finalRevChan)
+	case testCrashTask:
+		result = w.receiver.TestCrash()
+		taskType = "testcrash"
+	default:
+		stopWatching()
+		task.Reply() <- fmt.Errorf("unknown task type")
+		return
+	}
+
+	interrupted := stopWatching()
+
+	task.Reply() <- result
+	close(task.Reply())
+
+	var tasks int
+	w.updateHealth(func(h *WorkerHealth) {
+		h.Tasks++
+		h.LastUsed = time.Now()
+		if result != nil {
+			h.LastError = result.Error()
+		}
+		tasks = h.Tasks
+	})
+
+	gw.LogC(task.Context(), "worker_pool", gw.LogDebug).
+		Int("worker_id", w.getHealth().ID).
+		Dur("task_dt", time.Since(t0)).
+		Msgf("%v task complete", taskType)
+
+	maxTasks := w.pool.options.MaxTasks
+	switch {
+	case interrupted:
+		w.restart(restartInterrupted)
+	case crashed(result):
+		w.restart(restartCrash)
+	case maxTasks > 0 && tasks >= maxTasks:
+		w.restart(restartMaxTasks)
+	}
+}
+
+// interruptOnCancel interrupts the receiver process if the context is done
+// before the returned function is called, and kills it if the task is still
+// running after PoolOptions.InterruptGracePeriod. The function returns true
+// if the receiver was interrupted, once a killed receiver process has exited
+func (w *worker) interruptOnCancel(ctx context.Context) func() bool {
+	receiver := w.receiver
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			gw.LogC(ctx, "worker_pool", gw.LogInfo).
+				Int("worker_id", w.getHealth().ID).
+				Msgf("interrupting receiver: %v", ctx.Err())
+			if err := receiver.Interrupt(); err != nil {
+				gw.LogC(ctx, "worker_pool", gw.LogError).
+					Int("worker_id", w.getHealth().ID).
+					Msgf("could not interrupt receiver: %v", err)
+			}
+			grace := time.NewTimer(w.pool.options.InterruptGracePeriod)
+			defer grace.Stop()
+			select {
+			case <-done:
+			case <-grace.C:
+				gw.LogC(ctx, "worker_pool", gw.LogError).
+					Int("worker_id", w.getHealth().ID).
+					Dur("grace_period", w.pool.options.InterruptGracePeriod).
+					Msg("interrupted receiver did not stop in time, killing it")
+				if err := receiver.Kill(); err != nil {
+					gw.LogC(ctx, "worker_pool", gw.LogError).
+						Int("worker_id", w.getHealth().ID).
+						Msgf("could not kill receiver: %v", err)
+				}
+			}
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+// crashed returns true if the error shows that the receiver process has
+// exited while processing a request
+func crashed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -2,9 +2,11 @@ package statistics
 
 import (
 	"fmt"
-	"os/exec"
-	"sync"
+	"strings"
 	"time"
+
+	"github.com/cvmfs/gateway/internal/gateway/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type PublishCounters struct {
@@ -15,63 +17,70 @@ type PublishCounters struct {
 	UploadedCatalogBytes int64 `json:"sz_uploaded_catalog_bytes"`
 }
 
+// Add the values of other to the counters
+func (c *PublishCounters) Add(other PublishCounters) {
+	c.ChunksAdded += other.ChunksAdded
+	c.ChunksDuplicated += other.ChunksDuplicated
+	c.CatalogsAdded += other.CatalogsAdded
+	c.UploadedBytes += other.UploadedBytes
+	c.UploadedCatalogBytes += other.UploadedCatalogBytes
+}
+
 type Statistics struct {
 	Publish   PublishCounters `json:"publish"`
 	StartTime string          `json:"start_time"`
 }
 
-type StatisticsMgr struct {
-	leaseStatistics map[string]Statistics
-	readLock        sync.Mutex
+// NewStatistics returns the zeroed counters of a publish operation starting
+// now
+func NewStatistics() Statistics {
+	return Statistics{StartTime: time.Now().Format("2006-01-02 15:04:05")}
 }
 
-func NewStatisticsMgr() *StatisticsMgr {
-	return &StatisticsMgr{leaseStatistics: make(map[string]Statistics)}
+// Store keeps the statistics counters of the active leases, identified by
+// their lease path
+type Store interface {
+	GetLeaseStatistics(leasePath string) (Statistics, error)
+	// MergeLeaseStatistics atomically adds the counters of other to the
+	// counters of the lease
+	MergeLeaseStatistics(leasePath string, other *Statistics) error
 }
 
-func (m *StatisticsMgr) CreateLease(leasePath string) error {
-	m.readLock.Lock()
-	defer m.readLock.Unlock()
-	if _, ex := m.leaseStatistics[leasePath]; ex {
-		return fmt.Errorf("could not create statistics entry for lease %s, entry already exists", leasePath)
-	}
-	m.leaseStatistics[leasePath] = Statistics{StartTime: time.Now().Format("2006-01-02 15:04:05")}
-	return nil
+type StatisticsMgr struct {
+	store Store
 }
 
-func (m *StatisticsMgr) PopLease(leasePath string) (Statistics, error) {
-	m.readLock.Lock()
-	defer m.readLock.Unlock()
-	res, prs := m.leaseStatistics[leasePath]
-	if !prs {
-		return Statistics{}, fmt.Errorf("no statistics counters for lease %s", leasePath)
+func NewStatisticsMgr(store Store) *StatisticsMgr {
+	return &StatisticsMgr{store: store}
+}
+
+func (m *StatisticsMgr) GetLease(leasePath string) (Statistics, error) {
+	res, err := m.store.GetLeaseStatistics(leasePath)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("no statistics counters for lease %s: %w", leasePath, err)
 	}
-	delete(m.leaseStatistics, leasePath)
 	return res, nil
 }
 
 func (m *StatisticsMgr) MergeIntoLeaseStatistics(leasePath string, other *Statistics) error {
-	m.readLock.Lock()
-	defer m.readLock.Unlock()
-	c, prs := m.leaseStatistics[leasePath]
-	if !prs {
-		return fmt.Errorf("statistics counters not found for lease %s", leasePath)
+	if err := m.store.MergeLeaseStatistics(leasePath, other); err != nil {
+		return fmt.Errorf("could not update statistics counters for lease %s: %w", leasePath, err)
 	}
-	c.Publish.ChunksAdded += other.Publish.ChunksAdded
-	c.Publish.ChunksDuplicated += other.Publish.ChunksDuplicated
-	c.Publish.CatalogsAdded += other.Publish.CatalogsAdded
-	c.Publish.UploadedBytes += other.Publish.UploadedBytes
-	c.Publish.UploadedCatalogBytes += other.Publish.UploadedCatalogBytes
-	m.leaseStatistics[leasePath] = c
+
+	repository := strings.SplitN(leasePath, "/", 2)[0]
+	addCounter(metrics.ChunksAdded, other.Publish.ChunksAdded, repository)
+	addCounter(metrics.ChunksDuplicated, other.Publish.ChunksDuplicated, repository)
+	addCounter(metrics.CatalogsAdded, other.Publish.CatalogsAdded, repository)
+	addCounter(metrics.UploadedBytes, other.Publish.UploadedBytes, repository)
+	addCounter(metrics.UploadedCatalogBytes, other.Publish.UploadedCatalogBytes, repository)
+
 	return nil
 }
 
-func (m *StatisticsMgr) UploadStatsPlots(repoName string) error {
-	scriptPath := "/usr/share/cvmfs-server/upload_stats_plots.sh"
-	cmd := exec.Command(scriptPath, repoName)
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("statistics plots upload failed: %w", err)
+// addCounter adds a value reported by the receiver to a counter of the
+// repository. Counters can only increase, negative values are ignored
+func addCounter(c *prometheus.CounterVec, value int64, repository string) {
+	if value > 0 {
+		c.WithLabelValues(repository).Add(float64(value))
 	}
-	return nil
 }
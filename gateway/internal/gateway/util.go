@@ -13,6 +13,7 @@ type ContextKey int
 const (
 	IDKey ContextKey = iota
 	T0Key
+	KeyIDKey // ID of the key which signed the request
 )
 
 // SetupCloseHandler to run the specified actions on Ctrl-C
@@ -31,3 +32,18 @@ func SetupCloseHandler(actions []func()) chan struct{} {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	return done
 }
+
+// SetupReloadHandler to run the specified actions on SIGHUP. Must be called
+// after SetupCloseHandler, which resets the signal handlers
+func SetupReloadHandler(actions []func()) {
+	c := make(chan os.Signal, 1)
+	go func() {
+		for range c {
+			Log("reload_handler", LogInfo).Msg("hangup received")
+			for _, action := range actions {
+				action()
+			}
+		}
+	}()
+	signal.Notify(c, syscall.SIGHUP)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
@@ -49,9 +50,20 @@ func main() {
 		}
 	}()
 
+	var certs *fe.CertificateReloader
+	if cfg.TLSCertFile != "" {
+		certs, err = fe.NewCertificateReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			gw.Log("main", gw.LogError).
+				Err(err).
+				Msg("could not load the TLS certificates")
+			os.Exit(1)
+		}
+	}
+
 	go func() {
 		timeout := services.Config.MaxLeaseTime
-		if err := fe.Start(services, cfg.Port, timeout); err != nil {
+		if err := fe.Start(services, cfg.Port, timeout, certs); err != nil {
 			gw.Log("main", gw.LogError).
 				Err(err).
 				Msg("starting the HTTP front-end failed")
@@ -61,6 +73,26 @@ func main() {
 
 	done := gw.SetupCloseHandler([]func(){})
 
+	gw.SetupReloadHandler([]func(){
+		func() {
+			if _, err := services.ReloadAccessConfig(context.Background()); err != nil {
+				gw.Log("main", gw.LogError).
+					Err(err).
+					Msg("could not reload the repository access configuration")
+			}
+		},
+		func() {
+			if certs == nil {
+				return
+			}
+			if err := certs.Reload(); err != nil {
+				gw.Log("main", gw.LogError).
+					Err(err).
+					Msg("could not reload the TLS certificates")
+			}
+		},
+	})
+
 	gw.Log("main", gw.LogInfo).Msg("waiting for interrupt")
 	<-done
 }